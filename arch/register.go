@@ -2,6 +2,9 @@ package arch
 
 type RegisterValue = uint8
 
+// RegisterCount is the number of general purpose registers
+const RegisterCount = 16
+
 const (
 	ZeroRegister          RegisterValue = 0
 	SyscallResultRegister RegisterValue = 7
@@ -14,4 +17,13 @@ const (
 const (
 	ENO_IO                = 1
 	ENO_BadFileDescriptor = 2
+	ENO_BadAddress        = 3
+	ENO_OutOfMemory       = 4
+	ENO_InvalidArgument   = 5
+	ENO_NotFound          = 6
+	ENO_Exists            = 7
+	ENO_PermissionDenied  = 8
+	ENO_IsDirectory       = 9
+	ENO_NoSyscall         = 10
+	ENO_Interrupted       = 11
 )
@@ -24,8 +24,12 @@ type BadComputedAddressError struct {
 }
 
 func (b *BadComputedAddressError) Error() string {
-	return fmt.Sprintf("cannot represent computed value for label %s as %s: computed value is %d",
-		describeLocatedToken(b.Label), b.signText(), b.Computed)
+	return fmt.Sprintf("cannot represent computed value for label %q as %s: computed value is %d",
+		lexer.DescribeToken(b.Label), b.signText(), b.Computed)
+}
+
+func (b *BadComputedAddressError) Span() Span {
+	return TokenSpan(b.Label)
 }
 
 func (b *BadComputedAddressError) signText() string {
@@ -35,3 +39,23 @@ func (b *BadComputedAddressError) signText() string {
 		return "unsigned 16-bit integer"
 	}
 }
+
+// StatementError is an error in a statement that does not refer to a
+// specific token of it
+type StatementError struct {
+	// Statement is the first token of the statement
+	Statement *lexer.Token
+	Err       error
+}
+
+func (s *StatementError) Error() string {
+	return s.Err.Error()
+}
+
+func (s *StatementError) Unwrap() error {
+	return s.Err
+}
+
+func (s *StatementError) Span() Span {
+	return TokenSpan(s.Statement)
+}
@@ -0,0 +1,207 @@
+package asmerr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/asm/lexer"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Span is the part of a source line that an error refers to. Row and Column
+// are 1-based, and a Row of 0 means that the position is unknown.
+type Span struct {
+	Row    int
+	Column int
+	// Length is the number of bytes covered
+	Length int
+}
+
+// TokenSpan returns the span of the token in the source
+func TokenSpan(token *lexer.Token) Span {
+	if token.Row <= 0 {
+		// tokens created by pseudo-instructions have no position
+		return Span{}
+	}
+
+	length := len(token.Value)
+	switch token.Kind {
+	case lexer.LABEL:
+		// the $ is not part of the value
+		length++
+	case lexer.LABEL_DECLARATION:
+		// neither is the trailing colon
+		length += 2
+	case lexer.STRING:
+		// escape sequences have been replaced, so only mark the quote
+		length = 1
+	case lexer.LINE_END:
+		length = 1
+	}
+
+	return Span{
+		Row:    token.Row,
+		Column: token.Column,
+		Length: length,
+	}
+}
+
+// Spanned is implemented by errors that refer to a part of the source
+type Spanned interface {
+	error
+	Span() Span
+}
+
+type Severity uint8
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", uint8(s))
+	}
+}
+
+// Diagnostic is a problem found in a source file
+type Diagnostic struct {
+	Severity Severity
+	Span     Span
+	Message  string
+	// Err is the error the diagnostic was created from, if any
+	Err error
+}
+
+// NewDiagnostic returns an error diagnostic for err, located at its span if
+// it has one
+func NewDiagnostic(err error) *Diagnostic {
+	var diagnostic *Diagnostic
+	if errors.As(err, &diagnostic) {
+		return diagnostic
+	}
+
+	d := &Diagnostic{
+		Severity: SeverityError,
+		Message:  err.Error(),
+		Err:      err,
+	}
+	var spanned Spanned
+	if errors.As(err, &spanned) {
+		d.Span = spanned.Span()
+	}
+	return d
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Span.Row == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", d.Span.Row, d.Span.Column, d.Message)
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// Diagnostics collects the problems found in a source file
+type Diagnostics []*Diagnostic
+
+// Add adds err as a diagnostic, or all of its diagnostics if it is
+// Diagnostics itself
+func (d *Diagnostics) Add(err error) {
+	var list Diagnostics
+	if errors.As(err, &list) {
+		*d = append(*d, list...)
+	} else {
+		*d = append(*d, NewDiagnostic(err))
+	}
+}
+
+// HasErrors returns whether any of the diagnostics is an error
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort sorts the diagnostics by position
+func (d Diagnostics) Sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		a, b := d[i].Span, d[j].Span
+		if a.Row != b.Row {
+			return a.Row < b.Row
+		}
+		return a.Column < b.Column
+	})
+}
+
+// Err returns the diagnostics sorted by position if any of them is an error,
+// or nil otherwise
+func (d Diagnostics) Err() error {
+	if !d.HasErrors() {
+		return nil
+	}
+	d.Sort()
+	return d
+}
+
+func (d Diagnostics) Error() string {
+	messages := make([]string, len(d))
+	for i := range d {
+		messages[i] = d[i].Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Print writes the diagnostics in err compiler-style, followed by the source
+// line they refer to with a caret under the offending part
+func Print(w io.Writer, fileName string, source []byte, err error) {
+	var list Diagnostics
+	if !errors.As(err, &list) {
+		list = Diagnostics{NewDiagnostic(err)}
+	}
+
+	lines := strings.Split(string(source), "\n")
+	for _, d := range list {
+		if d.Span.Row == 0 {
+			_, _ = fmt.Fprintf(w, "%s: %s: %s\n", fileName, d.Severity, d.Message)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s:%d:%d: %s: %s\n", fileName, d.Span.Row, d.Span.Column, d.Severity, d.Message)
+		if d.Span.Row > len(lines) {
+			continue
+		}
+
+		line := strings.TrimRight(lines[d.Span.Row-1], "\r")
+		_, _ = fmt.Fprintf(w, "\t%s\n\t%s\n", line, caret(line, d.Span))
+	}
+}
+
+// caret returns a line marking the span under the source line. Tabs are kept
+// so that the marker lines up with the source.
+func caret(line string, span Span) string {
+	var builder strings.Builder
+	for i := 0; i < span.Column-1; i++ {
+		if i < len(line) && line[i] == '\t' {
+			builder.WriteByte('\t')
+		} else {
+			builder.WriteByte(' ')
+		}
+	}
+	builder.WriteByte('^')
+	for i := 1; i < span.Length; i++ {
+		builder.WriteByte('~')
+	}
+	return builder.String()
+}
@@ -11,10 +11,23 @@ func describeLocatedToken(token *lexer.Token) string {
 
 type InvalidImmediateError struct {
 	Token *lexer.Token
+	// Err is the reason the immediate is invalid, if any
+	Err error
 }
 
 func (i *InvalidImmediateError) Error() string {
-	return fmt.Sprintf("invalid immediate %s", describeLocatedToken(i.Token))
+	if i.Err != nil {
+		return fmt.Sprintf("invalid immediate %q: %v", lexer.DescribeToken(i.Token), i.Err)
+	}
+	return fmt.Sprintf("invalid immediate %q", lexer.DescribeToken(i.Token))
+}
+
+func (i *InvalidImmediateError) Unwrap() error {
+	return i.Err
+}
+
+func (i *InvalidImmediateError) Span() Span {
+	return TokenSpan(i.Token)
 }
 
 type LabelNotFoundError struct {
@@ -22,7 +35,11 @@ type LabelNotFoundError struct {
 }
 
 func (l *LabelNotFoundError) Error() string {
-	return fmt.Sprintf("undefined label %s", describeLocatedToken(l.Label))
+	return fmt.Sprintf("undefined label %q", lexer.DescribeToken(l.Label))
+}
+
+func (l *LabelNotFoundError) Span() Span {
+	return TokenSpan(l.Label)
 }
 
 type DuplicateLabelError struct {
@@ -31,5 +48,9 @@ type DuplicateLabelError struct {
 }
 
 func (d *DuplicateLabelError) Error() string {
-	return fmt.Sprintf("duplicate label %s (other: %s)", describeLocatedToken(d.Label), describeLocatedToken(d.Other))
+	return fmt.Sprintf("duplicate label %q (other: %s)", lexer.DescribeToken(d.Label), describeLocatedToken(d.Other))
+}
+
+func (d *DuplicateLabelError) Span() Span {
+	return TokenSpan(d.Label)
 }
@@ -1,6 +1,7 @@
 package asm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dnsge/orange/arch"
 	"github.com/dnsge/orange/asm/asmerr"
@@ -56,7 +57,7 @@ func assembleATypeInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Ins
 func assembleATypeImmInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Instruction, error) {
 	var regs []arch.RegisterValue
 	if len(args) == 2 {
-		parsedReg, err := parseRegister(args[0])
+		parsedReg, err := ParseRegister(args[0])
 		if err != nil {
 			return 0, err
 		}
@@ -75,7 +76,7 @@ func assembleATypeImmInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.
 		regs = parsedRegs
 	}
 
-	imm, err := parseUnsignedImmediate(args[len(args)-1])
+	imm, err := ParseUnsignedImmediate(args[len(args)-1])
 	if err != nil {
 		return 0, err
 	}
@@ -98,7 +99,7 @@ func assembleMTypeInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Ins
 		// aka de-referencing a pointer
 		imm = 0
 	} else if len(args) == 3 {
-		pImm, err := parseSignedImmediate(args[2])
+		pImm, err := ParseSignedImmediate(args[2])
 		if err != nil {
 			return 0, err
 		}
@@ -134,14 +135,14 @@ func assembleETypeInstruction(opcode arch.Opcode, args []*lexer.Token, relocator
 		}
 	}
 
-	regDest, err := parseRegister(args[0])
+	regDest, err := ParseRegister(args[0])
 	if err != nil {
 		return 0, err
 	}
 
 	var imm uint16
 	if len(args) == 2 {
-		imm, err = parseUnsignedImmediate(args[1])
+		imm, err = ParseUnsignedImmediate(args[1])
 	} else if len(args) == 3 {
 		if args[1].Kind == lexer.ADDRESS_OF {
 			imm, err = determine16AddressOf(args[2], relocator)
@@ -171,7 +172,7 @@ func assembleBTypeInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Ins
 		}
 	}
 
-	regA, err := parseRegister(args[0])
+	regA, err := ParseRegister(args[0])
 	if err != nil {
 		return 0, err
 	}
@@ -228,7 +229,7 @@ func assembleRTypeInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Ins
 		}
 	}
 
-	regA, err := parseRegister(args[0])
+	regA, err := ParseRegister(args[0])
 	if err != nil {
 		return 0, err
 	}
@@ -240,7 +241,9 @@ func assembleRTypeInstruction(opcode arch.Opcode, args []*lexer.Token) (arch.Ins
 	return arch.EncodeRTypeInstruction(instruction), nil
 }
 
-func parseRegister(registerName *lexer.Token) (arch.RegisterValue, error) {
+// ParseRegister returns the number of the register named by the token,
+// resolving aliases like rsp
+func ParseRegister(registerName *lexer.Token) (arch.RegisterValue, error) {
 	if alias, ok := registerAliases[registerName.Value]; ok {
 		return alias, nil
 	}
@@ -257,7 +260,7 @@ func parseRegister(registerName *lexer.Token) (arch.RegisterValue, error) {
 func parseRegisters(registers []*lexer.Token) ([]arch.RegisterValue, error) {
 	res := make([]arch.RegisterValue, len(registers))
 	for i := range registers {
-		parsed, err := parseRegister(registers[i])
+		parsed, err := ParseRegister(registers[i])
 		if err != nil {
 			return nil, err
 		}
@@ -266,7 +269,8 @@ func parseRegisters(registers []*lexer.Token) ([]arch.RegisterValue, error) {
 	return res, nil
 }
 
-func parseUnsignedImmediate(immTok *lexer.Token) (uint16, error) {
+// ParseUnsignedImmediate returns the value of a 16-bit unsigned immediate
+func ParseUnsignedImmediate(immTok *lexer.Token) (uint16, error) {
 	imm := immTok.Value[1:]
 	var base int
 
@@ -283,12 +287,13 @@ func parseUnsignedImmediate(immTok *lexer.Token) (uint16, error) {
 
 	res, err := strconv.ParseUint(imm, base, 16)
 	if err != nil {
-		return 0, err
+		return 0, immediateError(immTok, err)
 	}
 	return uint16(res), nil
 }
 
-func parseSignedImmediate(immTok *lexer.Token) (int16, error) {
+// ParseSignedImmediate returns the value of a 16-bit signed immediate
+func ParseSignedImmediate(immTok *lexer.Token) (int16, error) {
 	imm := immTok.Value[1:]
 	var base int
 
@@ -305,7 +310,7 @@ func parseSignedImmediate(immTok *lexer.Token) (int16, error) {
 
 	res, err := strconv.ParseInt(imm, base, 16)
 	if err != nil {
-		return 0, err
+		return 0, immediateError(immTok, err)
 	}
 	return int16(res), nil
 }
@@ -327,11 +332,21 @@ func parseSigned64Immediate(immTok *lexer.Token) (int64, error) {
 
 	res, err := strconv.ParseInt(imm, base, 64)
 	if err != nil {
-		return 0, err
+		return 0, immediateError(immTok, err)
 	}
 	return res, nil
 }
 
+// immediateError describes why the immediate could not be parsed
+func immediateError(immTok *lexer.Token, err error) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		// the token is already described, so only keep the reason
+		err = numErr.Err
+	}
+	return &asmerr.InvalidImmediateError{Token: immTok, Err: err}
+}
+
 func parseOffsetOrLabel(tok *lexer.Token, relocator parser.Relocator) (int16, error) {
 	if tok.Kind == lexer.LABEL {
 		instructionAddressOffset, err := relocator.SignedOffsetFor(tok)
@@ -342,7 +357,7 @@ func parseOffsetOrLabel(tok *lexer.Token, relocator parser.Relocator) (int16, er
 		instructionOffset := instructionAddressOffset / 4
 		return instructionOffset, nil
 	} else {
-		return parseSignedImmediate(tok)
+		return ParseSignedImmediate(tok)
 	}
 }
 
@@ -0,0 +1,62 @@
+package asm
+
+import (
+	"github.com/dnsge/orange/asm/parser"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/linker/objfile"
+	"sort"
+)
+
+// traverseLines calls lineFunc with the section, section-relative offset,
+// absolute address and source line of every instruction in the layout.
+func (l *Layout) traverseLines(lineFunc func(section *Section, offset int, address int, line int)) {
+	address := 0
+	for _, sec := range l.Sections {
+		offset := 0
+		for i, s := range sec.Statements {
+			// statements created by the assembler have no position
+			if s.Kind == parser.InstructionStatement && s.Body[0].Row > 0 {
+				lineFunc(sec, offset, address, s.Body[0].Row)
+			}
+			offset += sec.StatementSizes[i]
+			address += sec.StatementSizes[i]
+		}
+	}
+}
+
+// DebugInfo returns the source line of every instruction in the layout and
+// the address of every label, where fileName is the name of the source file.
+func (l *Layout) DebugInfo(fileName string) *debuginfo.Info {
+	info := new(debuginfo.Info)
+	l.traverseLines(func(_ *Section, _ int, address int, line int) {
+		info.AddLine(uint32(address), fileName, line)
+	})
+
+	labels := make([]string, 0, len(l.Labels))
+	for label := range l.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if labelAddress, ok := l.LocateLabel(label); ok {
+			info.AddSymbol(label, labelAddress)
+		}
+	}
+
+	info.Sort()
+	return info
+}
+
+// LineTable returns the source line of every instruction in the layout
+// relative to the start of its section, for object files.
+func (l *Layout) LineTable() []*objfile.LineTableEntry {
+	var table []*objfile.LineTableEntry
+	l.traverseLines(func(section *Section, offset int, _ int, line int) {
+		table = append(table, &objfile.LineTableEntry{
+			SectionName:   section.Name,
+			SectionOffset: offset,
+			Line:          line,
+		})
+	})
+	return table
+}
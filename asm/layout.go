@@ -1,6 +1,7 @@
 package asm
 
 import (
+	"errors"
 	"github.com/dnsge/orange/arch"
 	"github.com/dnsge/orange/asm/asmerr"
 	"github.com/dnsge/orange/asm/lexer"
@@ -13,7 +14,8 @@ type Layout struct {
 	Labels   map[string]*parser.Statement
 }
 
-func newLayout() *Layout {
+// NewLayout returns an empty layout
+func NewLayout() *Layout {
 	return &Layout{
 		Sections: []*Section{},
 		Labels:   make(map[string]*parser.Statement),
@@ -119,8 +121,12 @@ func (l *Layout) LocateLabelSection(label string) (*Section, bool) {
 //
 // We assume we are starting with the .text section, regardless of whether
 // this function was called earlier and terminated with a different section.
+//
+// Duplicate labels are reported together as asmerr.Diagnostics, keeping the
+// first declaration of each.
 func (l *Layout) InitWithStatements(statements []*parser.Statement) error {
 	currentSection := l.SectionByName("text") // initialize text as first section
+	var diagnostics asmerr.Diagnostics
 
 	for _, s := range statements {
 		if s.Kind == parser.DirectiveStatement {
@@ -133,13 +139,13 @@ func (l *Layout) InitWithStatements(statements []*parser.Statement) error {
 				labelName := directiveToken.Value
 				if other, ok := l.Labels[labelName]; ok {
 					// duplicate label found
-					return &asmerr.DuplicateLabelError{
+					diagnostics.Add(&asmerr.DuplicateLabelError{
 						Label: directiveToken,
 						Other: other.Body[0],
-					}
+					})
+				} else {
+					l.Labels[labelName] = s
 				}
-
-				l.Labels[labelName] = s
 			}
 		}
 
@@ -149,7 +155,7 @@ func (l *Layout) InitWithStatements(statements []*parser.Statement) error {
 		currentSection.StatementSizes = append(currentSection.StatementSizes, statementSize)
 	}
 
-	return nil
+	return diagnostics.Err()
 }
 
 type TraversalState interface {
@@ -174,10 +180,14 @@ func (l *Layout) Traverse(traversalFunc func(section *Section) error) error {
 
 // Assemble iterates over each statement throughout the binary, calling the
 // assembler function along the way with each statement and a bound TraversalState.
+//
+// Statements that fail to assemble are left as zero words, and the errors of
+// all of them are returned together as asmerr.Diagnostics.
 func (l *Layout) Assemble(assembleFunc func(*parser.Statement, TraversalState) ([]arch.Instruction, error)) error {
+	var diagnostics asmerr.Diagnostics
 	address := 0
 	for _, sec := range l.Sections {
-		j := 0
+		sectionOffset := 0
 		sec.AssembledStatements = make([]arch.Instruction, sec.Size/4)
 		for i := range sec.Statements {
 			s := sec.Statements[i]
@@ -190,9 +200,15 @@ func (l *Layout) Assemble(assembleFunc func(*parser.Statement, TraversalState) (
 			// apply assemble function
 			assembled, err := assembleFunc(s, bound)
 			if err != nil {
-				return err
+				var spanned asmerr.Spanned
+				if !errors.As(err, &spanned) {
+					err = &asmerr.StatementError{Statement: s.Body[0], Err: err}
+				}
+				diagnostics.Add(err)
+				assembled = nil
 			}
 
+			j := sectionOffset / 4
 			for _, a := range assembled {
 				sec.AssembledStatements[j] = a
 				j++
@@ -200,11 +216,12 @@ func (l *Layout) Assemble(assembleFunc func(*parser.Statement, TraversalState) (
 
 			// increment address counter
 			sSize := sec.StatementSizes[i]
+			sectionOffset += sSize
 			address += sSize
 		}
 	}
 
-	return nil
+	return diagnostics.Err()
 }
 
 type boundTraversalState struct {
@@ -1,7 +1,9 @@
 package lexer
 
 import (
+	"bytes"
 	"github.com/timtadh/lexmachine"
+	"github.com/timtadh/lexmachine/machines"
 	"io"
 )
 
@@ -35,5 +37,24 @@ func (t *Tokenizer) Next() (*Token, error) {
 		return nil, io.EOF
 	}
 
-	return tok.(*Token), nil
+	token := tok.(*Token)
+	if token.Kind == LINE_END {
+		// lexmachine places a newline at column 0 of the following line, so
+		// move it to the end of the line that it ends instead
+		newline := t.scanner.TC - 1
+		lineStart := bytes.LastIndexByte(t.scanner.Text[:newline], '\n') + 1
+		token.Row--
+		token.Column = newline - lineStart + 1
+	}
+
+	return token, nil
+}
+
+// Skip continues tokenizing after the input that Next failed to tokenize
+func (t *Tokenizer) Skip(unconsumed *machines.UnconsumedInput) {
+	if unconsumed.FailTC > t.scanner.TC {
+		t.scanner.TC = unconsumed.FailTC
+	} else {
+		t.scanner.TC++
+	}
 }
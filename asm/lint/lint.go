@@ -0,0 +1,147 @@
+// Package lint finds common mistakes in orange assembly that assemble fine
+// but are likely bugs, like writing to the zero register or leaving values
+// on the stack on some paths only.
+package lint
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/cfg"
+	"strings"
+)
+
+// Rule is one check of the lint pass
+type Rule struct {
+	Name        string
+	Description string
+
+	check func(p *pass)
+}
+
+// Rules holds all rules, which are enabled unless disabled by Config
+var Rules = []*Rule{
+	{Name: "zero-register", Description: "writes to r0, which are discarded", check: checkZeroRegister},
+	{Name: "stack-balance", Description: "paths that push and pop a different number of bytes", check: checkStackBalance},
+	{Name: "callee-saved", Description: "functions writing r10-r13 without pushing them", check: checkCalleeSaved},
+	{Name: "unreachable", Description: "instructions after B, BREG or HALT that no label leads to", check: checkUnreachable},
+	{Name: "branch-to-data", Description: "branches to labels of .string or .fill data", check: checkBranchToData},
+	{Name: "unused-label", Description: "labels that are never referenced", check: checkUnusedLabel},
+}
+
+// RuleNames returns the names of all rules
+func RuleNames() []string {
+	names := make([]string, len(Rules))
+	for i, rule := range Rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// Config selects the rules to run
+type Config struct {
+	// Disabled holds the names of the rules that are not run
+	Disabled map[string]bool
+
+	// Executable is set if the source is assembled to an executable, so
+	// that labels must be used within the file. Otherwise only private
+	// labels are reported as unused, since others may be used by the files
+	// it is linked with.
+	Executable bool
+}
+
+// Disable disables the named rules, failing for unknown names
+func (c *Config) Disable(names ...string) error {
+	if c.Disabled == nil {
+		c.Disabled = make(map[string]bool)
+	}
+	for _, name := range names {
+		if !isRule(name) {
+			return fmt.Errorf("unknown lint rule %q (rules: %s)", name, strings.Join(RuleNames(), ", "))
+		}
+		c.Disabled[name] = true
+	}
+	return nil
+}
+
+func isRule(name string) bool {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Warning is a finding of a rule
+type Warning struct {
+	Rule    string
+	Token   *lexer.Token
+	Message string
+}
+
+func (w *Warning) Error() string {
+	return fmt.Sprintf("%s [%s]", w.Message, w.Rule)
+}
+
+func (w *Warning) Span() asmerr.Span {
+	return asmerr.TokenSpan(w.Token)
+}
+
+// Check runs the enabled rules over the layout and returns their findings as
+// warnings. A nil config runs all rules.
+func Check(layout *asm.Layout, config *Config) asmerr.Diagnostics {
+	if config == nil {
+		config = &Config{}
+	}
+
+	p := &pass{
+		layout:   layout,
+		config:   config,
+		graph:    cfg.FromLayout(layout),
+		reported: make(map[reportKey]bool),
+	}
+	for _, rule := range Rules {
+		if config.Disabled[rule.Name] {
+			continue
+		}
+		p.rule = rule
+		rule.check(p)
+	}
+	return p.diagnostics
+}
+
+// pass is the state shared by the rules of one run of Check
+type pass struct {
+	layout *asm.Layout
+	config *Config
+	graph  *cfg.Graph
+
+	rule        *Rule
+	diagnostics asmerr.Diagnostics
+	reported    map[reportKey]bool
+}
+
+type reportKey struct {
+	rule  *Rule
+	token *lexer.Token
+}
+
+// report adds a warning of the current rule at the token. Tokens are only
+// reported once per rule, since blocks may be part of several functions.
+func (p *pass) report(token *lexer.Token, format string, args ...interface{}) {
+	key := reportKey{rule: p.rule, token: token}
+	if p.reported[key] {
+		return
+	}
+	p.reported[key] = true
+
+	d := asmerr.NewDiagnostic(&Warning{
+		Rule:    p.rule.Name,
+		Token:   token,
+		Message: fmt.Sprintf(format, args...),
+	})
+	d.Severity = asmerr.SeverityWarning
+	p.diagnostics = append(p.diagnostics, d)
+}
@@ -0,0 +1,149 @@
+package lint
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// lintSource runs the rule over the source, returning the findings as
+// "row:column: message" strings
+func lintSource(t *testing.T, rule string, source string, executable bool) []string {
+	tokens, err := parser.TokenizeAll([]byte(source))
+	require.NoError(t, err)
+	statements, err := parser.ParseTokens(tokens)
+	require.NoError(t, err)
+	layout := asm.NewLayout()
+	require.NoError(t, layout.InitWithStatements(statements))
+
+	config := &Config{Executable: executable}
+	for _, name := range RuleNames() {
+		if name != rule {
+			require.NoError(t, config.Disable(name))
+		}
+	}
+
+	res := []string{}
+	for _, d := range Check(layout, config) {
+		res = append(res, fmt.Sprintf("%d:%d: %s", d.Span.Row, d.Span.Column, d.Message))
+	}
+	return res
+}
+
+func TestZeroRegister(t *testing.T) {
+	assert.Equal(t, []string{
+		"1:7: write to r0 is discarded, since it is the zero register [zero-register]",
+		"3:6: write to rzr is discarded, since it is the zero register [zero-register]",
+	}, lintSource(t, "zero-register", `	MOVZ r0, #1
+	CMP r1, r2
+	POP rzr
+	STREG r0, [r1]
+`, false))
+}
+
+func TestStackBalance(t *testing.T) {
+	assert.Equal(t, []string{
+		"10:1: paths reach here with different stack depths (8 and 0 bytes pushed) [stack-balance]",
+		"18:2: $g returns with 0 bytes pushed here, but with 8 bytes on line 16 [stack-balance]",
+	}, lintSource(t, "stack-balance", `	BL $f
+	POP r1
+	BL $g
+	HALT
+$f:
+	PUSH r1
+	CMPI r1, #0
+	B.EQ $_f.skip
+	POP r1
+$_f.skip:
+	BREG rrp
+$g:
+	CMPI r1, #0
+	B.EQ $_g.other
+	PUSH r1
+	BREG rrp
+$_g.other:
+	BREG rrp
+`, false))
+
+	// functions returning values on the stack are balanced by their callers
+	assert.Empty(t, lintSource(t, "stack-balance", `$loop:
+	BL $value
+	POP r1
+	SUBI rsp, #16
+	ADDI rsp, #16
+	CMPI r1, #0
+	B.NEQ $loop
+	HALT
+$value:
+	PUSH r1
+	BREG rrp
+`, false))
+}
+
+func TestCalleeSaved(t *testing.T) {
+	assert.Equal(t, []string{
+		"8:7: $f writes callee-saved register r11 without saving it on the stack [callee-saved]",
+	}, lintSource(t, "callee-saved", `	MOVZ r10, #1
+	BL $f
+	HALT
+$f:
+	PUSH r10
+	MOVZ r10, #2
+	POP r10
+	MOVZ r11, #2
+	BREG rrp
+`, false))
+}
+
+func TestUnreachable(t *testing.T) {
+	assert.Equal(t, []string{
+		"5:2: unreachable code [unreachable]",
+	}, lintSource(t, "unreachable", `	B $end
+$_skip:	NOOP
+	NOOP
+	HALT
+	NOOP
+$end:
+	HALT
+.section data
+	.string "data"
+`, false))
+}
+
+func TestBranchToData(t *testing.T) {
+	assert.Equal(t, []string{
+		"1:7: branch to $message, which labels .string data [branch-to-data]",
+	}, lintSource(t, "branch-to-data", `	B.EQ $message
+	B $code
+$code:
+	HALT
+$message:	.string "hi"
+`, false))
+}
+
+func TestUnusedLabel(t *testing.T) {
+	source := `$start:
+	B $_used
+$_used:
+	HALT
+$_unused:
+$public:	HALT
+`
+	assert.Equal(t, []string{
+		"5:1: label $_unused is never used [unused-label]",
+	}, lintSource(t, "unused-label", source, false))
+	assert.Equal(t, []string{
+		"5:1: label $_unused is never used [unused-label]",
+		"6:1: label $public is never used [unused-label]",
+	}, lintSource(t, "unused-label", source, true))
+}
+
+func TestConfig_Disable(t *testing.T) {
+	config := &Config{}
+	assert.NoError(t, config.Disable("unreachable"))
+	assert.True(t, config.Disabled["unreachable"])
+	assert.Error(t, config.Disable("unknown"))
+}
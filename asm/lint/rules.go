@@ -0,0 +1,314 @@
+package lint
+
+import (
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/asm/parser"
+	"github.com/dnsge/orange/cfg"
+	"strings"
+)
+
+// writtenRegister returns the register operand written by the instruction,
+// or nil if it writes none
+func writtenRegister(s *parser.Statement) *lexer.Token {
+	switch s.Body[0].Kind {
+	case lexer.ADD, lexer.SUB, lexer.AND, lexer.OR, lexer.XOR,
+		lexer.ADDI, lexer.SUBI, lexer.LSL, lexer.LSR,
+		lexer.LDREG, lexer.LDWORD, lexer.LDHWRD, lexer.LDBYTE,
+		lexer.MOVZ, lexer.MOVK, lexer.POP:
+		return s.Body[1]
+	default:
+		return nil
+	}
+}
+
+// isRegister returns whether the token names the register
+func isRegister(tok *lexer.Token, register arch.RegisterValue) bool {
+	value, err := asm.ParseRegister(tok)
+	return err == nil && value == register
+}
+
+func isPrivate(label string) bool {
+	return strings.HasPrefix(label, "_")
+}
+
+func checkZeroRegister(p *pass) {
+	for _, b := range p.graph.Blocks {
+		for _, i := range b.Instructions {
+			dest := writtenRegister(i.Statement)
+			// pseudo-instructions like CMP write to r0 on purpose
+			if dest != nil && dest.Row > 0 && isRegister(dest, arch.ZeroRegister) {
+				p.report(dest, "write to %s is discarded, since it is the zero register", dest.Value)
+			}
+		}
+	}
+}
+
+// depth is the number of bytes pushed to the stack relative to the start of
+// the code being analyzed
+type depth struct {
+	bytes int
+	known bool
+}
+
+// stackEffect returns the depth after the instruction, which is unknown
+// after arbitrary writes to the stack pointer. calls holds the bytes that
+// local functions leave on the stack, which is unknown for other calls.
+func stackEffect(i *cfg.Instruction, d depth, calls map[string]int) depth {
+	if !d.known {
+		return d
+	}
+
+	s := i.Statement
+	switch s.Body[0].Kind {
+	case lexer.PUSH:
+		return depth{bytes: d.bytes + 8, known: true}
+	case lexer.POP:
+		if !isRegister(s.Body[1], arch.StackRegister) {
+			return depth{bytes: d.bytes - 8, known: true}
+		}
+	case lexer.ADDI, lexer.SUBI:
+		// ADDI rsp, #imm or ADDI rsp, rsp, #imm
+		if isRegister(s.Body[1], arch.StackRegister) && (len(s.Body) == 3 || isRegister(s.Body[2], arch.StackRegister)) {
+			imm, err := asm.ParseUnsignedImmediate(s.Body[len(s.Body)-1])
+			if err != nil {
+				return depth{}
+			}
+			if s.Body[0].Kind == lexer.SUBI {
+				return depth{bytes: d.bytes + int(imm), known: true}
+			}
+			return depth{bytes: d.bytes - int(imm), known: true}
+		}
+	case lexer.BL:
+		if bytes, ok := calls[i.Target]; ok && i.Target != "" {
+			return depth{bytes: d.bytes + bytes, known: true}
+		}
+		return depth{}
+	case lexer.BLR:
+		return depth{}
+	}
+
+	if dest := writtenRegister(s); dest != nil && isRegister(dest, arch.StackRegister) {
+		return depth{}
+	}
+	return d
+}
+
+// blockEffect returns the depth at the end of the block
+func blockEffect(b *cfg.Block, d depth, calls map[string]int) depth {
+	for _, i := range b.Instructions {
+		d = stackEffect(i, d, calls)
+	}
+	return d
+}
+
+// flowDepths returns the depths at the start of the blocks reachable from the
+// roots. Each root that is not reached from the roots before it starts at
+// depth 0, so that branches from earlier code reach labels before they are
+// assumed to be entries themselves. mismatch is called for blocks reached
+// with different depths.
+func flowDepths(roots []*cfg.Block, calls map[string]int, mismatch func(b *cfg.Block, existing depth, incoming depth)) map[*cfg.Block]depth {
+	entryDepths := make(map[*cfg.Block]depth)
+	for _, root := range roots {
+		if _, ok := entryDepths[root]; ok {
+			continue
+		}
+		entryDepths[root] = depth{known: true}
+
+		queue := []*cfg.Block{root}
+		for len(queue) > 0 {
+			b := queue[0]
+			queue = queue[1:]
+
+			out := blockEffect(b, entryDepths[b], calls)
+			for _, succ := range b.Succs {
+				existing, ok := entryDepths[succ]
+				if !ok {
+					entryDepths[succ] = out
+					queue = append(queue, succ)
+				} else if existing.known && out.known && existing.bytes != out.bytes {
+					mismatch(succ, existing, out)
+				}
+			}
+		}
+	}
+	return entryDepths
+}
+
+// returnDepths returns the depth at each return of the function
+func returnDepths(f *cfg.Function, calls map[string]int) []depth {
+	entryDepths := flowDepths([]*cfg.Block{f.Entry}, calls, func(*cfg.Block, depth, depth) {})
+	var res []depth
+	for _, b := range f.Blocks {
+		if terminator := b.Terminator(); terminator != nil && terminator.Kind == cfg.Return {
+			res = append(res, blockEffect(b, entryDepths[b], calls))
+		}
+	}
+	return res
+}
+
+// callEffects returns the bytes that the functions of the file leave on the
+// stack, for those whose returns agree
+func (p *pass) callEffects() map[string]int {
+	calls := make(map[string]int)
+	functions := p.graph.Functions()
+
+	// functions calling each other need several rounds
+	for round := 0; round <= len(functions); round++ {
+		changed := false
+		for _, f := range functions {
+			bytes, ok := 0, true
+			for i, d := range returnDepths(f, calls) {
+				if !d.known || (i > 0 && d.bytes != bytes) {
+					ok = false
+					break
+				}
+				bytes = d.bytes
+			}
+
+			if previous, exists := calls[f.Name]; ok && (!exists || previous != bytes) {
+				calls[f.Name] = bytes
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return calls
+}
+
+func checkStackBalance(p *pass) {
+	calls := p.callEffects()
+
+	var roots []*cfg.Block
+	for _, b := range p.graph.Blocks {
+		if !b.Continued {
+			roots = append(roots, b)
+		}
+	}
+	flowDepths(roots, calls, func(b *cfg.Block, a depth, other depth) {
+		p.report(b.Statements[0].Body[0], "paths reach here with different stack depths (%d and %d bytes pushed)", a.bytes, other.bytes)
+	})
+
+	// the returns of a function must leave the same values on the stack
+	for _, f := range p.graph.Functions() {
+		depths := returnDepths(f, calls)
+		first := -1
+		for i, d := range depths {
+			if !d.known {
+				continue
+			}
+			if first < 0 {
+				first = i
+			} else if d.bytes != depths[first].bytes {
+				p.report(f.Returns[i].Statement.Body[0], "$%s returns with %d bytes pushed here, but with %d bytes on line %d",
+					f.Name, d.bytes, depths[first].bytes, f.Returns[first].Statement.Body[0].Row)
+			}
+		}
+	}
+}
+
+func checkCalleeSaved(p *pass) {
+	for _, f := range p.graph.Functions() {
+		pushed := make(map[arch.RegisterValue]bool)
+		for _, b := range f.Blocks {
+			for _, i := range b.Instructions {
+				if i.Opcode != arch.PUSH {
+					continue
+				}
+				if register, err := asm.ParseRegister(i.Statement.Body[1]); err == nil {
+					pushed[register] = true
+				}
+			}
+		}
+
+		for _, b := range f.Blocks {
+			for _, i := range b.Instructions {
+				dest := writtenRegister(i.Statement)
+				if dest == nil {
+					continue
+				}
+				register, err := asm.ParseRegister(dest)
+				if err != nil || register < 10 || register > 13 || pushed[register] {
+					continue
+				}
+				p.report(dest, "$%s writes callee-saved register %s without saving it on the stack", f.Name, dest.Value)
+			}
+		}
+	}
+}
+
+func checkUnreachable(p *pass) {
+	// only report the start of unreachable code, which may span blocks
+	reported := false
+	for _, b := range p.graph.Blocks {
+		if b.First || b.Continued || len(b.Labels) > 0 || len(b.Preds) > 0 {
+			reported = false
+			continue
+		}
+		if len(b.Instructions) > 0 && !reported {
+			p.report(b.Instructions[0].Statement.Body[0], "unreachable code")
+			reported = true
+		}
+	}
+}
+
+func checkBranchToData(p *pass) {
+	for _, b := range p.graph.Blocks {
+		for _, i := range b.Instructions {
+			switch i.Kind {
+			case cfg.Jump, cfg.CondJump, cfg.Call:
+			default:
+				continue
+			}
+			if i.Target == "" {
+				continue
+			}
+			target, ok := p.graph.Label(i.Target)
+			if !ok {
+				continue
+			}
+
+			label := i.Statement.Body[1]
+			for _, ts := range target.Statements[len(target.Labels):] {
+				if ts.Kind == parser.DirectiveStatement && asm.IsDataDirective(ts.Body[0].Kind) {
+					directive := ".fill"
+					if ts.Body[0].Kind == lexer.STRING_STATEMENT {
+						directive = ".string"
+					}
+					p.report(label, "branch to $%s, which labels %s data", label.Value, directive)
+				}
+				break
+			}
+		}
+	}
+}
+
+func checkUnusedLabel(p *pass) {
+	used := make(map[string]bool)
+	for _, b := range p.graph.Blocks {
+		for _, s := range b.Statements {
+			for _, tok := range s.Body {
+				if tok.Kind == lexer.LABEL {
+					used[tok.Value] = true
+				}
+			}
+		}
+	}
+
+	for _, b := range p.graph.Blocks {
+		for _, s := range b.Statements[:len(b.Labels)] {
+			name := s.Body[0].Value
+			if used[name] || (!isPrivate(name) && !p.config.Executable) {
+				continue
+			}
+			if address, ok := p.layout.LocateLabel(name); ok && address == 0 && p.config.Executable {
+				// labels the entry point of the program
+				continue
+			}
+			p.report(s.Body[0], "label $%s is never used", name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// listingEntry is the output of the statements of one source line
+type listingEntry struct {
+	section *Section
+	offset  int
+	address int
+	words   []uint32
+}
+
+// WriteListing writes a listing of the assembled layout: every source line
+// with its section, address and the words emitted for it, followed by the
+// symbol table. Lines emitting several words are continued on the following
+// lines.
+//
+// Addresses are relative to the start of their section. If absolute is true,
+// as for executables, the absolute addresses are listed as well.
+func (l *Layout) WriteListing(w io.Writer, source []byte, absolute bool) error {
+	entries := make(map[int]*listingEntry)
+	address := 0
+	for _, sec := range l.Sections {
+		offset := 0
+		for i, s := range sec.Statements {
+			size := sec.StatementSizes[i]
+			// statements created by the assembler have no position
+			if row := s.Body[0].Row; row > 0 {
+				entry, ok := entries[row]
+				if !ok {
+					entry = &listingEntry{
+						section: sec,
+						offset:  offset,
+						address: address,
+					}
+					entries[row] = entry
+				}
+				for _, word := range sec.AssembledStatements[offset/4 : (offset+size)/4] {
+					entry.words = append(entry.words, uint32(word))
+				}
+			}
+			offset += size
+			address += size
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	blankAddress := "        "
+	if absolute {
+		blankAddress += "  " + blankAddress
+	}
+	formatAddress := func(entry *listingEntry, offset int) string {
+		if absolute {
+			return fmt.Sprintf("%08x  %08x", entry.offset+offset, entry.address+offset)
+		}
+		return fmt.Sprintf("%08x", entry.offset+offset)
+	}
+
+	// columns left empty would otherwise leave trailing spaces
+	writeLine := func(format string, a ...interface{}) {
+		_, _ = bw.WriteString(strings.TrimRight(fmt.Sprintf(format, a...), " "))
+		_ = bw.WriteByte('\n')
+	}
+
+	if absolute {
+		writeLine(" line  %-8s  %-8s  %-8s  %-8s  source", "section", "offset", "address", "word")
+	} else {
+		writeLine(" line  %-8s  %-8s  %-8s  source", "section", "offset", "word")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		entry, ok := entries[i+1]
+		if !ok {
+			writeLine("%5d  %-8s  %s  %-8s  %s", i+1, "", blankAddress, "", line)
+			continue
+		}
+
+		if len(entry.words) == 0 {
+			// only labels or sections, which emit nothing
+			writeLine("%5d  %-8s  %s  %-8s  %s", i+1, entry.section.Name, formatAddress(entry, 0), "", line)
+			continue
+		}
+		for j, word := range entry.words {
+			if j == 0 {
+				writeLine("%5d  %-8s  %s  %08x  %s", i+1, entry.section.Name, formatAddress(entry, 0), word, line)
+			} else {
+				writeLine("%5s  %-8s  %s  %08x", "", "", formatAddress(entry, j*4), word)
+			}
+		}
+	}
+
+	l.writeSymbolTable(bw, absolute)
+	return bw.Flush()
+}
+
+// writeSymbolTable lists the labels of the layout by address
+func (l *Layout) writeSymbolTable(w io.Writer, absolute bool) {
+	type symbol struct {
+		name    string
+		section *Section
+		offset  int
+		address int
+	}
+
+	var symbols []symbol
+	for name, statement := range l.Labels {
+		address, ok := l.LocateStatement(statement)
+		if !ok {
+			continue
+		}
+		offset, section, _ := l.LocateStatementWithinSection(statement)
+		symbols = append(symbols, symbol{
+			name:    name,
+			section: section,
+			offset:  offset,
+			address: address,
+		})
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].address != symbols[j].address {
+			return symbols[i].address < symbols[j].address
+		}
+		return symbols[i].name < symbols[j].name
+	})
+
+	_, _ = fmt.Fprintf(w, "\nsymbols:\n")
+	for _, s := range symbols {
+		if absolute {
+			_, _ = fmt.Fprintf(w, "  %-8s  %08x  %08x  $%s\n", s.section.Name, s.offset, s.address, s.name)
+		} else {
+			_, _ = fmt.Fprintf(w, "  %-8s  %08x  $%s\n", s.section.Name, s.offset, s.name)
+		}
+	}
+}
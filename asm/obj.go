@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/dnsge/orange/arch"
 	"github.com/dnsge/orange/asm/parser"
+	"github.com/dnsge/orange/debuginfo"
 	"github.com/dnsge/orange/linker/objfile"
 	"io"
 )
@@ -14,17 +15,11 @@ type ObjectFile struct {
 	SymbolTable     []*objfile.SymbolTableEntry
 	RelocationTable []*objfile.RelocationTableEntry
 
-	symbolTableMap map[string]struct{}
-}
+	// SourceFile and LineTable form the optional debug section
+	SourceFile string
+	LineTable  []*objfile.LineTableEntry
 
-func printObjectFile(of *ObjectFile) {
-	for _, s := range of.SymbolTable {
-		fmt.Printf("symbol %s\n", s)
-	}
-
-	for _, r := range of.RelocationTable {
-		fmt.Printf("relocation %s\n", r)
-	}
+	symbolTableMap map[string]struct{}
 }
 
 func CreateObjectFile(layout *Layout) (*ObjectFile, error) {
@@ -94,6 +89,11 @@ func (o *ObjectFile) AssembleStatement(layout *Layout) func(s *parser.Statement,
 // - for each entry, [label name] [section name] [offset] [resolved]
 // - for each entry, [label name] [section name] [offset] [instruction/directive id]
 // [raw assembled instructions]
+//
+// If the object file has a line table, the debug section follows:
+//
+// debug [quoted source file name] [# of line table entries]
+// - for each entry, [section name] [offset] [line]
 func (o *ObjectFile) WriteToFile(layout *Layout, outputFile io.Writer) (err error) {
 	// Write # of sections, size of symbol table, size of relocation table
 	_, err = fmt.Fprintf(outputFile, "%d %d %d\n", len(o.Sections), len(o.SymbolTable), len(o.RelocationTable))
@@ -135,6 +135,21 @@ func (o *ObjectFile) WriteToFile(layout *Layout, outputFile io.Writer) (err erro
 		}
 		return nil
 	})
+	if err != nil || o.SourceFile == "" {
+		return
+	}
+
+	_, err = fmt.Fprintf(outputFile, "%s %q %d\n", debuginfo.Marker, o.SourceFile, len(o.LineTable))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range o.LineTable {
+		err = entry.MarshalTo(outputFile)
+		if err != nil {
+			return
+		}
+	}
 
 	return
 }
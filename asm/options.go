@@ -0,0 +1,135 @@
+package asm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/asm/parser"
+	"io"
+	"strings"
+)
+
+// Target is the kind of file produced by the assembler
+type Target uint8
+
+const (
+	// TargetObjectFile produces an object file to be linked
+	TargetObjectFile Target = iota
+	// TargetExecutable produces an executable, which requires all labels to
+	// be declared in the source file
+	TargetExecutable
+)
+
+// Verbosity controls which progress messages are logged to the Sink
+type Verbosity uint8
+
+const (
+	// Quiet logs nothing
+	Quiet Verbosity = iota
+	// Verbose logs the sizes of the sections and the symbol and relocation
+	// tables of object files
+	Verbose
+	// Trace additionally logs every statement as it is assembled
+	Trace
+)
+
+// Sink receives the output of the assembler besides the assembled file
+type Sink interface {
+	// Log receives a progress message enabled by Options.Verbosity
+	Log(message string)
+	// Diagnostic receives each warning and error found in the source, in
+	// order of position
+	Diagnostic(d *asmerr.Diagnostic)
+}
+
+// Options configures the assembler. The zero value assembles an object file
+// without debug info and reports nothing besides the returned error.
+type Options struct {
+	Target Target
+
+	// Debug includes the source line table and symbols in the output
+	Debug bool
+	// FileName is the name of the source file recorded in the debug info
+	FileName string
+
+	// Listing receives a listing of the assembled program if it is set
+	Listing io.Writer
+
+	// Sink receives log messages and diagnostics if it is set
+	Sink      Sink
+	Verbosity Verbosity
+
+	// Lint checks the layout before it is assembled. The diagnostics it
+	// returns, usually warnings, are reported along with those of the
+	// assembler.
+	Lint func(layout *Layout) asmerr.Diagnostics
+
+	// WarningsAsErrors reports warnings as errors, failing the assembly
+	WarningsAsErrors bool
+}
+
+// assembler holds the state of one run of Assemble
+type assembler struct {
+	options     *Options
+	diagnostics asmerr.Diagnostics
+}
+
+// logf logs a message to the sink if the verbosity is at least level
+func (a *assembler) logf(level Verbosity, format string, args ...interface{}) {
+	if a.options.Sink == nil || a.options.Verbosity < level {
+		return
+	}
+	a.options.Sink.Log(fmt.Sprintf(format, args...))
+}
+
+// finish reports the diagnostics collected so far to the sink and returns
+// them if any of them is an error
+func (a *assembler) finish() error {
+	if a.options.WarningsAsErrors {
+		for _, d := range a.diagnostics {
+			d.Severity = asmerr.SeverityError
+		}
+	}
+
+	a.diagnostics.Sort()
+	if a.options.Sink != nil {
+		for _, d := range a.diagnostics {
+			a.options.Sink.Diagnostic(d)
+		}
+	}
+	return a.diagnostics.Err()
+}
+
+// logSections logs the size of each section of the layout
+func (a *assembler) logSections(layout *Layout) {
+	for _, sec := range layout.Sections {
+		a.logf(Verbose, "section %s: %d bytes", sec.Name, len(sec.AssembledStatements)*4)
+	}
+}
+
+// logObjectFile logs the symbol and relocation tables of the object file
+func (a *assembler) logObjectFile(obj *ObjectFile) {
+	for _, s := range obj.SymbolTable {
+		a.logf(Verbose, "symbol %s", s)
+	}
+	for _, r := range obj.RelocationTable {
+		a.logf(Verbose, "relocation %s", r)
+	}
+}
+
+// traceStatements wraps assembleFunc to log each statement before it is
+// assembled
+func (a *assembler) traceStatements(assembleFunc func(*parser.Statement, TraversalState) ([]arch.Instruction, error)) func(*parser.Statement, TraversalState) ([]arch.Instruction, error) {
+	if a.options.Sink == nil || a.options.Verbosity < Trace {
+		return assembleFunc
+	}
+	return func(s *parser.Statement, state TraversalState) ([]arch.Instruction, error) {
+		descriptions := make([]string, len(s.Body))
+		for i, tok := range s.Body {
+			descriptions[i] = lexer.DescribeToken(tok)
+		}
+		a.logf(Trace, "%s", strings.Join(descriptions, " "))
+		return assembleFunc(s, state)
+	}
+}
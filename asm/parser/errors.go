@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/lexer"
+)
+
+// InvalidTokenError is returned for input that does not form a token
+type InvalidTokenError struct {
+	Row    int
+	Column int
+}
+
+func (i *InvalidTokenError) Error() string {
+	return "invalid token"
+}
+
+func (i *InvalidTokenError) Span() asmerr.Span {
+	return asmerr.Span{Row: i.Row, Column: i.Column, Length: 1}
+}
+
+// ExpectedLineEndError is returned when a statement follows another
+// statement on the same line
+type ExpectedLineEndError struct {
+	// Token starts the second statement
+	Token *lexer.Token
+}
+
+func (e *ExpectedLineEndError) Error() string {
+	return fmt.Sprintf("%v before %s", ErrExpectedLineEnd, lexer.DescribeToken(e.Token))
+}
+
+func (e *ExpectedLineEndError) Unwrap() error {
+	return ErrExpectedLineEnd
+}
+
+func (e *ExpectedLineEndError) Span() asmerr.Span {
+	return asmerr.TokenSpan(e.Token)
+}
+
+// UnexpectedTokenError is returned when a token cannot start a statement
+type UnexpectedTokenError struct {
+	Token    *lexer.Token
+	Expected string
+}
+
+func (u *UnexpectedTokenError) Error() string {
+	return fmt.Sprintf("unexpected token %s (expected %s)", lexer.DescribeToken(u.Token), u.Expected)
+}
+
+func (u *UnexpectedTokenError) Span() asmerr.Span {
+	return asmerr.TokenSpan(u.Token)
+}
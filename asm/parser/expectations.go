@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"github.com/dnsge/orange/asm/asmerr"
 	"github.com/dnsge/orange/asm/lexer"
 	"strings"
 )
@@ -153,7 +154,8 @@ func ExtractExpectedStructure(stream *lexer.TokenStream, dest *[]*lexer.Token, e
 		} else {
 			return &ExtractionError{
 				expectations:  []*Expectation{exp},
-				parseMessages: []string{fmt.Sprintf("unexpected token %s at %d:%d (expected %s)", lexer.DescribeToken(actual), actual.Row, actual.Column, e.Describe())},
+				parseMessages: []string{fmt.Sprintf("unexpected token %s (expected %s)", lexer.DescribeToken(actual), e.Describe())},
+				token:         actual,
 			}
 		}
 	}
@@ -174,6 +176,10 @@ func OneOf(expectations ...*Expectation) *OneOfExpectations {
 // but instead extracts the first matching expectation from OneOfExpectations.
 func ExtractOneOfExpectedStructure(stream *lexer.TokenStream, dest *[]*lexer.Token, exps *OneOfExpectations) error {
 	var errorMessages []string
+	// the error is placed at the token where the expectation that matched
+	// the most tokens failed
+	var errorToken *lexer.Token
+	bestProgress := -1
 
 	origDest := make([]*lexer.Token, len(*dest), cap(*dest))
 	copy(origDest, *dest)
@@ -183,12 +189,16 @@ outer:
 	for _, exp := range exps.expectations {
 		*dest = origDest
 		stream.Jump(startStreamPos)
-		tokenProgress := 0 // TODO: Use tokenProgress to select most likely error
+		tokenProgress := 0
 		for _, e := range exp.entries {
 			if !stream.HasNext() {
 				// Only report EOF errors if we cared about capturing the last token
 				if e.Keep() {
 					errorMessages = append(errorMessages, fmt.Sprintf("expected token %s but got EOF", e.Describe()))
+					if tokenProgress > bestProgress {
+						bestProgress = tokenProgress
+						errorToken = nil
+					}
 					continue outer
 				} else {
 					continue
@@ -202,7 +212,11 @@ outer:
 					*dest = append(*dest, actual)
 				}
 			} else {
-				errorMessages = append(errorMessages, fmt.Sprintf("unexpected token %s at %d:%d (expected %s)", lexer.DescribeToken(actual), actual.Row, actual.Column, e.Describe()))
+				errorMessages = append(errorMessages, fmt.Sprintf("unexpected token %s (expected %s)", lexer.DescribeToken(actual), e.Describe()))
+				if tokenProgress > bestProgress {
+					bestProgress = tokenProgress
+					errorToken = actual
+				}
 				continue outer
 			}
 		}
@@ -214,6 +228,7 @@ outer:
 	return &ExtractionError{
 		expectations:  exps.expectations,
 		parseMessages: errorMessages,
+		token:         errorToken,
 	}
 }
 
@@ -261,6 +276,16 @@ func (o *OneOfExpectations) Description() string {
 type ExtractionError struct {
 	expectations  []*Expectation
 	parseMessages []string
+	// token is the token that did not match, or the first token of the
+	// statement if the input ended
+	token *lexer.Token
+}
+
+func (ee *ExtractionError) Span() asmerr.Span {
+	if ee.token == nil {
+		return asmerr.Span{}
+	}
+	return asmerr.TokenSpan(ee.token)
 }
 
 func (ee *ExtractionError) Error() string {
@@ -268,11 +293,14 @@ func (ee *ExtractionError) Error() string {
 	multiple := len(ee.expectations) > 1
 
 	builder.WriteString("failed to parse statement: ")
-	if multiple {
-		builder.WriteString("no match among multiple = [\n")
-	} else {
-		builder.WriteRune('\n')
+	if !multiple {
+		builder.WriteString(ee.expectations[0].Description())
+		builder.WriteString(" ==> ")
+		builder.WriteString(ee.parseMessages[0])
+		return builder.String()
 	}
+
+	builder.WriteString("no match among multiple = [\n")
 	for i := range ee.expectations {
 		builder.WriteRune('\t')
 		builder.WriteString(ee.expectations[i].Description())
@@ -280,9 +308,7 @@ func (ee *ExtractionError) Error() string {
 		builder.WriteString(ee.parseMessages[i])
 		builder.WriteRune('\n')
 	}
-	if multiple {
-		builder.WriteRune(']')
-	}
+	builder.WriteRune(']')
 
 	return builder.String()
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"github.com/dnsge/orange/asm/asmerr"
 	"github.com/dnsge/orange/asm/lexer"
 	"github.com/timtadh/lexmachine/machines"
 	"io"
@@ -19,7 +20,10 @@ var (
 	ErrExpectedLineEnd = fmt.Errorf("expected line end")
 )
 
-// TokenizeAll converts the given data into a slice of Tokens
+// TokenizeAll converts the given data into a slice of Tokens.
+//
+// Lines containing invalid tokens are left out and reported together as
+// asmerr.Diagnostics, along with the tokens of the remaining lines.
 func TokenizeAll(data []byte) ([]*lexer.Token, error) {
 	var allTokens []*lexer.Token
 	t, err := lexer.New(data)
@@ -27,6 +31,8 @@ func TokenizeAll(data []byte) ([]*lexer.Token, error) {
 		return nil, err
 	}
 
+	var diagnostics asmerr.Diagnostics
+	badRow := 0
 	for {
 		tok, err := t.Next()
 		if err == io.EOF {
@@ -34,17 +40,31 @@ func TokenizeAll(data []byte) ([]*lexer.Token, error) {
 		} else if err != nil {
 			var unconsumed *machines.UnconsumedInput
 			if errors.As(err, &unconsumed) {
-				return nil, fmt.Errorf("invalid token at %d:%d", unconsumed.StartLine, unconsumed.StartColumn)
-
+				diagnostics.Add(&InvalidTokenError{
+					Row:    unconsumed.StartLine,
+					Column: unconsumed.StartColumn,
+				})
+
+				// drop the rest of the line so that it is not reported again
+				// by the parser
+				badRow = unconsumed.StartLine
+				for len(allTokens) > 0 && allTokens[len(allTokens)-1].Row == badRow {
+					allTokens = allTokens[:len(allTokens)-1]
+				}
+				t.Skip(unconsumed)
+				continue
 			} else {
 				return nil, err
 			}
 		}
 
+		if tok.Row == badRow && tok.Kind != lexer.LINE_END {
+			continue
+		}
 		allTokens = append(allTokens, tok)
 	}
 
-	return allTokens, nil
+	return allTokens, diagnostics.Err()
 }
 
 // Statement is the most basic element of an Orange assembly program.
@@ -84,14 +104,21 @@ type Relocator interface {
 // on a separate line. This is not a limitation of the parsing, but a deliberate
 // design choice. Therefore, we must reach a LINE_END token before beginning parsing
 // of another Statement.
+//
+// When a statement fails to parse, the rest of its line is skipped and parsing
+// continues on the next line. All errors are returned together as
+// asmerr.Diagnostics, along with the statements that did parse.
 func ParseTokens(tokens []*lexer.Token) ([]*Statement, error) {
 	stream := lexer.NewTokenStream(tokens)
 
 	var statements []*Statement
+	var diagnostics asmerr.Diagnostics
 	waitingForLineEnd := false
 	for stream.HasNext() {
+		statementStart := stream.Pos()
 		currentToken := stream.Pop()
 		var producedStatement *Statement
+		var err error
 		if currentToken.Kind == lexer.COMMENT {
 			// consume and ignore token
 			continue
@@ -99,41 +126,35 @@ func ParseTokens(tokens []*lexer.Token) ([]*Statement, error) {
 			waitingForLineEnd = false
 			// consume and ignore token
 			continue
+		} else if waitingForLineEnd {
+			// make sure nothing trails a Statement
+			err = &ExpectedLineEndError{Token: currentToken}
 		} else if lexer.IsTokenOp(currentToken.Kind) {
-			// make sure Statement does not trail other Statement
-			if waitingForLineEnd {
-				return nil, ErrExpectedLineEnd
-			}
-
-			if opStatement, err := parseOpTokens(currentToken, stream); err != nil {
-				return nil, err
-			} else {
-				producedStatement = opStatement
+			if producedStatement, err = parseOpTokens(currentToken, stream); err == nil {
 				waitingForLineEnd = true
 			}
 		} else if lexer.IsTokenDirective(currentToken.Kind) {
-			// make sure Statement does not trail other Statement
-			if waitingForLineEnd {
-				return nil, ErrExpectedLineEnd
-			}
-
-			if dStatement, err := parseDirectiveTokens(currentToken, stream); err != nil {
-				return nil, err
-			} else {
-				if dStatement.Body[len(dStatement.Body)-1].Kind == lexer.LINE_END {
+			if producedStatement, err = parseDirectiveTokens(currentToken, stream); err == nil {
+				if producedStatement.Body[len(producedStatement.Body)-1].Kind == lexer.LINE_END {
 					// The directive processed the new line, don't update waitingForLineEnd
-					dStatement.Body = dStatement.Body[:len(dStatement.Body)-1] // remove last token
+					producedStatement.Body = producedStatement.Body[:len(producedStatement.Body)-1] // remove last token
 				}
-
-				producedStatement = dStatement
 			}
 		} else {
-			return nil, fmt.Errorf("unexpected token %s at %d:%d (expected statement)", lexer.DescribeToken(currentToken), currentToken.Row, currentToken.Column)
+			err = &UnexpectedTokenError{Token: currentToken, Expected: "statement"}
 		}
 
-		translated, err := translateStatement(producedStatement)
+		var translated []*Statement
+		if err == nil {
+			translated, err = translateStatement(producedStatement)
+		}
 		if err != nil {
-			return nil, err
+			// resynchronize at the end of the line and continue with the
+			// next statement
+			diagnostics.Add(err)
+			skipLine(stream, statementStart)
+			waitingForLineEnd = false
+			continue
 		}
 
 		for i := range translated {
@@ -141,7 +162,17 @@ func ParseTokens(tokens []*lexer.Token) ([]*Statement, error) {
 		}
 	}
 
-	return statements, nil
+	return statements, diagnostics.Err()
+}
+
+// skipLine moves the stream past the first LINE_END at or after pos
+func skipLine(stream *lexer.TokenStream, pos int) {
+	stream.Jump(pos)
+	for stream.HasNext() {
+		if stream.Pop().Kind == lexer.LINE_END {
+			return
+		}
+	}
 }
 
 // parseOpTokens attempts to parse an instruction statement from the TokenStream
@@ -187,6 +218,11 @@ func handlePrefixedExtraction(stream *lexer.TokenStream, prefix *lexer.Token, ex
 	body := make([]*lexer.Token, 1, extractable.ExtractionCount()+1)
 	body[0] = prefix
 	if err := extractable.Extract(stream, &body); err != nil {
+		var extractionErr *ExtractionError
+		if errors.As(err, &extractionErr) && extractionErr.token == nil {
+			// the input ended, so blame the incomplete statement
+			extractionErr.token = prefix
+		}
 		return nil, err
 	} else {
 		return body, nil
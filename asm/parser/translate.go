@@ -33,7 +33,7 @@ var pseudoStatements = []pseudoStatement{
 			// SUBI r0, r1, #imm
 
 			newBody := []*lexer.Token{
-				remapToken(cmpiStatement.Body[0], lexer.SUB, "SUBI"),
+				remapToken(cmpiStatement.Body[0], lexer.SUBI, "SUBI"),
 				blankToken(lexer.REGISTER, "r0"),
 				cmpiStatement.Body[1],
 				cmpiStatement.Body[2],
@@ -1,89 +1,180 @@
 package asm
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm/asmerr"
 	"github.com/dnsge/orange/asm/lexer"
 	"github.com/dnsge/orange/asm/parser"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/executable"
 	"io"
 )
 
-func readFileAndLayout(inputFile io.Reader) (*Layout, error) {
+// readLayout tokenizes, parses and lays out the input, collecting the
+// problems found in the source. The layout is nil if the source could not be
+// parsed. Layout errors, like duplicate labels, still return the layout so
+// that assembling it can report further errors.
+func (a *assembler) readLayout(inputFile io.Reader) (*Layout, []byte, error) {
 	rawData, err := io.ReadAll(inputFile)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// tokenize the raw file
+	// tokenize the raw file, continuing with the valid lines to report their
+	// parse errors as well
 	tokens, err := parser.TokenizeAll(rawData)
 	if err != nil {
-		return nil, err
+		var list asmerr.Diagnostics
+		if !errors.As(err, &list) {
+			return nil, nil, err
+		}
+		a.diagnostics.Add(err)
 	}
 
 	// parse tokens into statements
 	statements, err := parser.ParseTokens(tokens)
 	if err != nil {
-		return nil, err
+		a.diagnostics.Add(err)
+	}
+	if a.diagnostics.HasErrors() {
+		return nil, rawData, nil
 	}
 
 	// initialize the layout
-	layout := newLayout()
-	err = layout.InitWithStatements(statements)
-	if err != nil {
-		return nil, err
+	layout := NewLayout()
+	if err := layout.InitWithStatements(statements); err != nil {
+		a.diagnostics.Add(err)
 	}
+	return layout, rawData, nil
+}
 
-	return layout, nil
+// assembleLayout assembles the statements of the layout, collecting their
+// errors
+func (a *assembler) assembleLayout(layout *Layout, assembleFunc func(*parser.Statement, TraversalState) ([]arch.Instruction, error)) {
+	if err := layout.Assemble(a.traceStatements(assembleFunc)); err != nil {
+		a.diagnostics.Add(err)
+	}
 }
 
-func AssembleExecutable(inputFile io.Reader, outputFile io.Writer) error {
-	layout, err := readFileAndLayout(inputFile)
+// Assemble assembles the source read from inputFile and writes the file
+// selected by options.Target to outputFile. A nil options is the same as the
+// zero Options. Problems in the source are returned as asmerr.Diagnostics.
+func Assemble(inputFile io.Reader, outputFile io.Writer, options *Options) error {
+	if options == nil {
+		options = &Options{}
+	}
+
+	a := &assembler{options: options}
+	layout, source, err := a.readLayout(inputFile)
 	if err != nil {
 		return err
 	}
+	if layout == nil {
+		return a.finish()
+	}
+	if options.Lint != nil {
+		a.diagnostics = append(a.diagnostics, options.Lint(layout)...)
+	}
+
+	switch options.Target {
+	case TargetExecutable:
+		return a.assembleExecutable(layout, source, outputFile)
+	case TargetObjectFile:
+		return a.assembleObjectFile(layout, source, outputFile)
+	default:
+		return fmt.Errorf("unknown target %d", options.Target)
+	}
+}
 
-	err = layout.Assemble(AssembleStatement)
+// ReadLayout tokenizes, parses and lays out the source read from inputFile
+// without assembling it, for tools analyzing its statements. Problems in the
+// source are returned as asmerr.Diagnostics.
+func ReadLayout(inputFile io.Reader) (*Layout, error) {
+	a := &assembler{options: &Options{}}
+	layout, _, err := a.readLayout(inputFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := a.finish(); err != nil {
+		return nil, err
 	}
+	return layout, nil
+}
 
-	return writeStatements(layout, outputFile)
+// AssembleExecutable assembles an executable without debug info
+func AssembleExecutable(inputFile io.Reader, outputFile io.Writer) error {
+	return Assemble(inputFile, outputFile, &Options{Target: TargetExecutable})
 }
 
+// AssembleObjectFile assembles an object file without debug info
 func AssembleObjectFile(inputFile io.Reader, outputFile io.Writer) error {
-	layout, err := readFileAndLayout(inputFile)
-	if err != nil {
+	return Assemble(inputFile, outputFile, &Options{Target: TargetObjectFile})
+}
+
+func (a *assembler) assembleExecutable(layout *Layout, source []byte, outputFile io.Writer) error {
+	a.assembleLayout(layout, AssembleStatement)
+	if err := a.finish(); err != nil {
 		return err
 	}
+	a.logSections(layout)
+
+	if a.options.Listing != nil {
+		if err := layout.WriteListing(a.options.Listing, source, true); err != nil {
+			return fmt.Errorf("write listing: %w", err)
+		}
+	}
+
+	var info *debuginfo.Info
+	if a.options.Debug {
+		info = layout.DebugInfo(a.options.FileName)
+	}
+	return writeStatements(layout, info, outputFile)
+}
 
+func (a *assembler) assembleObjectFile(layout *Layout, source []byte, outputFile io.Writer) error {
 	obj, err := CreateObjectFile(layout)
 	if err != nil {
 		return err
 	}
 
-	err = layout.Assemble(obj.AssembleStatement(layout))
-	if err != nil {
+	a.assembleLayout(layout, obj.AssembleStatement(layout))
+	if err := a.finish(); err != nil {
 		return err
 	}
+	a.logSections(layout)
+	a.logObjectFile(obj)
 
-	printObjectFile(obj)
+	if a.options.Debug {
+		obj.SourceFile = a.options.FileName
+		obj.LineTable = layout.LineTable()
+	}
+
+	if a.options.Listing != nil {
+		// section addresses are only known once linked
+		if err := layout.WriteListing(a.options.Listing, source, false); err != nil {
+			return fmt.Errorf("write listing: %w", err)
+		}
+	}
 
 	return obj.WriteToFile(layout, outputFile)
 }
 
-// writeStatements writes the assembled statements in layout to outputFile.
-// Order of section writing is determined by Layout.Traverse.
-func writeStatements(layout *Layout, outputFile io.Writer) error {
-	return layout.Traverse(func(section *Section) error {
-		for _, a := range section.AssembledStatements {
-			err := binary.Write(outputFile, arch.ByteOrder, a)
-			if err != nil {
-				return err
-			}
-		}
+// writeStatements writes the assembled statements in layout to outputFile as
+// an executable with optional debug info. Order of section writing is
+// determined by Layout.Traverse.
+func writeStatements(layout *Layout, debug *debuginfo.Info, outputFile io.Writer) error {
+	exe := &executable.File{Debug: debug}
+	err := layout.Traverse(func(section *Section) error {
+		exe.AddSection(section.Name, section.AssembledStatements)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return exe.MarshalTo(outputFile)
 }
 
 // AssembleStatement turns a parser.Statement into a 32-bit word that will exist in
@@ -95,7 +186,6 @@ func writeStatements(layout *Layout, outputFile io.Writer) error {
 // Strings are thus padded with null bytes at the end to make the string occupy a
 // multiple of 32 bits.
 func AssembleStatement(s *parser.Statement, state TraversalState) ([]arch.Instruction, error) {
-	printStatement(s)
 	if s.Kind == parser.InstructionStatement {
 		assembled, err := assembleInstruction(s, state)
 		if err != nil {
@@ -114,10 +204,3 @@ func AssembleStatement(s *parser.Statement, state TraversalState) ([]arch.Instru
 func IsDataDirective(kind lexer.TokenKind) bool {
 	return kind == lexer.FILL_STATEMENT || kind == lexer.STRING_STATEMENT
 }
-
-func printStatement(statement *parser.Statement) {
-	for _, tok := range statement.Body {
-		fmt.Printf("%s ", lexer.DescribeToken(tok))
-	}
-	fmt.Printf("\n")
-}
@@ -0,0 +1,143 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func assembleErrors(t *testing.T, source string) asmerr.Diagnostics {
+	err := Assemble(strings.NewReader(source), &bytes.Buffer{}, &Options{Target: TargetExecutable})
+	require.Error(t, err)
+
+	var diagnostics asmerr.Diagnostics
+	require.True(t, errors.As(err, &diagnostics), "not diagnostics: %v", err)
+	return diagnostics
+}
+
+func spans(diagnostics asmerr.Diagnostics) []asmerr.Span {
+	res := make([]asmerr.Span, len(diagnostics))
+	for i := range diagnostics {
+		res[i] = diagnostics[i].Span
+	}
+	return res
+}
+
+func TestAssemble_ParseErrorRecovery(t *testing.T) {
+	diagnostics := assembleErrors(t, `	MOVZ r1, #0
+	ADD r1, r2,
+	MOVZ r1 @ r2
+	HALT HALT
+	ADDI r1, r2, r3
+	HALT
+`)
+
+	assert.Equal(t, []asmerr.Span{
+		{Row: 2, Column: 12, Length: 1},
+		{Row: 3, Column: 10, Length: 1},
+		{Row: 4, Column: 7, Length: 4},
+		{Row: 5, Column: 15, Length: 2},
+	}, spans(diagnostics))
+	assert.True(t, errors.Is(diagnostics[2], parser.ErrExpectedLineEnd))
+}
+
+func TestAssemble_AssembleErrorRecovery(t *testing.T) {
+	diagnostics := assembleErrors(t, `	ADDI r1, #99999
+	B $nowhere
+$x:
+$x:	HALT
+	STREG r1, [r2, #-70000]
+`)
+
+	assert.Equal(t, []asmerr.Span{
+		{Row: 1, Column: 11, Length: 6},
+		{Row: 2, Column: 4, Length: 8},
+		{Row: 4, Column: 1, Length: 3},
+		{Row: 5, Column: 17, Length: 7},
+	}, spans(diagnostics))
+	assert.Equal(t, `invalid immediate "#99999": value out of range`, diagnostics[0].Message)
+}
+
+func TestPrint(t *testing.T) {
+	source := "\tMOVZ r1, #0\n\tB $nowhere\n"
+	err := AssembleExecutable(strings.NewReader(source), &bytes.Buffer{})
+
+	var out bytes.Buffer
+	asmerr.Print(&out, "test.orange", []byte(source), err)
+	assert.Equal(t, "test.orange:2:4: error: undefined label \"nowhere\"\n"+
+		"\t\tB $nowhere\n"+
+		"\t\t  ^~~~~~~~\n", out.String())
+}
+
+func TestWriteListing(t *testing.T) {
+	source := `	ADR r1, $message
+	HALT
+
+.section data
+$message:	.string "hi"
+`
+	var listing bytes.Buffer
+	err := Assemble(strings.NewReader(source), &bytes.Buffer{}, &Options{
+		Target:  TargetExecutable,
+		Listing: &listing,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, ` line  section   offset    address   word      source
+    1  text      00000000  00000000  1e100008  	ADR r1, $message
+    2  text      00000004  00000004  3e000000  	HALT
+    3
+    4  data      00000000  00000008            .section data
+    5  data      00000000  00000008  00006968  $message:	.string "hi"
+
+symbols:
+  data      00000000  00000008  $message
+`, listing.String())
+}
+
+type recordingSink struct {
+	messages    []string
+	diagnostics asmerr.Diagnostics
+}
+
+func (r *recordingSink) Log(message string) {
+	r.messages = append(r.messages, message)
+}
+
+func (r *recordingSink) Diagnostic(d *asmerr.Diagnostic) {
+	r.diagnostics = append(r.diagnostics, d)
+}
+
+func TestAssemble_Sink(t *testing.T) {
+	sink := &recordingSink{}
+	err := Assemble(strings.NewReader("\tMOVZ r1, #1\n\tB $done\n"), &bytes.Buffer{}, &Options{
+		Sink:      sink,
+		Verbosity: Trace,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"MOVZ r1 #1",
+		"B done",
+		"section text: 8 bytes",
+		"symbol [done@- : offset=0, resolved=false]",
+		"relocation [done@text : offset=4, kind=B]",
+	}, sink.messages)
+	assert.Empty(t, sink.diagnostics)
+
+	sink = &recordingSink{}
+	err = Assemble(strings.NewReader("\tB $nowhere\n\tADDI r1, #99999\n"), &bytes.Buffer{}, &Options{
+		Target: TargetExecutable,
+		Sink:   sink,
+	})
+	require.Error(t, err)
+	assert.Empty(t, sink.messages)
+	assert.Equal(t, []asmerr.Span{
+		{Row: 1, Column: 4, Length: 8},
+		{Row: 2, Column: 11, Length: 6},
+	}, spans(sink.diagnostics))
+}
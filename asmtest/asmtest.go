@@ -0,0 +1,142 @@
+// Package asmtest tests individual functions of orange assembly sources. A
+// Suite links the sources with a generated driver that passes the arguments
+// of a Case in r1-r4 and calls the function with BL, following the ABI in
+// ISA.md. Once the function returns, the values it left on the stack are
+// compared with the expected ones, and the callee-saved registers r10-r13 and
+// the stack pointer are checked.
+//
+// Cases are defined in Go, usually as a table:
+//
+//	suite := &asmtest.Suite{
+//		Function: "strLen",
+//		Files:    []string{"../programs/std/strio.orange"},
+//	}
+//	suite.Test(t, []asmtest.Case{
+//		{Name: "empty", Args: []asmtest.Arg{asmtest.String("")}, Returns: []int64{0}},
+//		{Name: "hello", Args: []asmtest.Arg{asmtest.String("hello")}, Returns: []int64{5}},
+//	})
+//
+// or loaded from a YAML case file with LoadSuite.
+package asmtest
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"strings"
+)
+
+const (
+	// maxArgs is the number of argument registers, r1-r4
+	maxArgs = 4
+
+	// DefaultMaxSteps is the number of instructions a case may execute
+	// unless the suite sets MaxSteps
+	DefaultMaxSteps = 1_000_000
+
+	labelPrefix = "_asmtest."
+	returnLabel = labelPrefix + "return"
+)
+
+// calleeSaved holds the registers a function must restore before returning
+var calleeSaved = []arch.RegisterValue{10, 11, 12, 13}
+
+// canary returns the value the driver puts in a callee-saved register before
+// calling the function
+func canary(register arch.RegisterValue) uint64 {
+	return 0x5a5a_0000_c0de_0000 | uint64(register)
+}
+
+// Arg is an argument passed to the function in a register
+type Arg struct {
+	value    int64
+	str      string
+	isString bool
+}
+
+// Int returns an argument passed by value
+func Int(value int64) Arg {
+	return Arg{value: value}
+}
+
+// String returns an argument passed as a pointer to a null-terminated copy of
+// s in the data section of the driver
+func String(s string) Arg {
+	return Arg{str: s, isString: true}
+}
+
+func (a Arg) String() string {
+	if a.isString {
+		return fmt.Sprintf("%q", a.str)
+	}
+	return fmt.Sprint(a.value)
+}
+
+// Case is a call of the function under test
+type Case struct {
+	Name string
+	// Args are passed in r1-r4, in order
+	Args []Arg
+	// Returns are the values the function must leave on the stack, in the
+	// order the caller pops them
+	Returns []int64
+
+	Stdin string
+	// Stdout is the output the function must write
+	Stdout string
+}
+
+// fillWords returns .fill directives for the bytes of s and a null
+// terminator, eight bytes at a time. Unlike .string, any byte can be
+// represented.
+func fillWords(s string) []int64 {
+	data := append([]byte(s), 0)
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+
+	var words []int64
+	for i := 0; i < len(data); i += 8 {
+		words = append(words, int64(arch.ByteOrder.Uint64(data[i:i+8])))
+	}
+	return words
+}
+
+// driver returns the source of a program that calls function with the
+// arguments of c and halts once it returns
+func driver(function string, c *Case) (string, error) {
+	if len(c.Args) > maxArgs {
+		return "", fmt.Errorf("%d arguments given, but only r1-r%d pass arguments", len(c.Args), maxArgs)
+	}
+
+	var text, data strings.Builder
+	_, _ = fmt.Fprintf(&text, "; driver generated by asmtest to call $%s\n", function)
+	_, _ = fmt.Fprintf(&text, ".section text\n")
+
+	// fill the callee-saved registers with values that are checked later
+	_, _ = fmt.Fprintf(&text, "\tADR r9, $%ssaved\n", labelPrefix)
+	_, _ = fmt.Fprintf(&data, "$%ssaved:\n", labelPrefix)
+	for i, register := range calleeSaved {
+		_, _ = fmt.Fprintf(&text, "\tLDREG r%d, [r9, #%d]\n", register, i*8)
+		_, _ = fmt.Fprintf(&data, "\t.fill #%d\n", int64(canary(register)))
+	}
+
+	for i, arg := range c.Args {
+		label := fmt.Sprintf("%sarg%d", labelPrefix, i)
+		_, _ = fmt.Fprintf(&data, "$%s:\t; %s\n", label, arg)
+		if arg.isString {
+			_, _ = fmt.Fprintf(&text, "\tADR r%d, $%s\n", i+1, label)
+			for _, word := range fillWords(arg.str) {
+				_, _ = fmt.Fprintf(&data, "\t.fill #%d\n", word)
+			}
+		} else {
+			_, _ = fmt.Fprintf(&text, "\tADR r9, $%s\n", label)
+			_, _ = fmt.Fprintf(&text, "\tLDREG r%d, [r9]\n", i+1)
+			_, _ = fmt.Fprintf(&data, "\t.fill #%d\n", arg.value)
+		}
+	}
+
+	_, _ = fmt.Fprintf(&text, "\tBL $%s\n", function)
+	_, _ = fmt.Fprintf(&text, "$%s:\n\tHALT\n\n", returnLabel)
+	_, _ = fmt.Fprintf(&text, ".section data\n%s", data.String())
+	return text.String(), nil
+}
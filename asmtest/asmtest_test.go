@@ -0,0 +1,139 @@
+package asmtest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const strio = "../programs/std/strio.orange"
+
+func TestStrLen(t *testing.T) {
+	suite := &Suite{Function: "strLen", Files: []string{strio}}
+	suite.Test(t, []Case{
+		{Args: []Arg{String("")}, Returns: []int64{0}},
+		{Args: []Arg{String("a")}, Returns: []int64{1}},
+		{Args: []Arg{String("hello, world")}, Returns: []int64{12}},
+		{Args: []Arg{String("tab\tand newline\n")}, Returns: []int64{16}},
+	})
+}
+
+func TestStrCmp(t *testing.T) {
+	suite := &Suite{Function: "strCmp", Files: []string{strio}}
+	suite.Test(t, []Case{
+		{Args: []Arg{String(""), String("")}, Returns: []int64{0}},
+		{Args: []Arg{String("orange"), String("orange")}, Returns: []int64{0}},
+		{Args: []Arg{String("a"), String("b")}, Returns: []int64{-1}},
+		{Args: []Arg{String("b"), String("a")}, Returns: []int64{1}},
+		{Args: []Arg{String("abc"), String("ab")}, Returns: []int64{'c'}},
+		{Args: []Arg{String("ab"), String("abc")}, Returns: []int64{-'c'}},
+	})
+}
+
+func TestPrintStr(t *testing.T) {
+	suite := &Suite{Function: "printStr", Files: []string{strio}}
+	suite.Test(t, []Case{
+		{Args: []Arg{String("hi!\n")}, Stdout: "hi!\n"},
+		{Args: []Arg{String("")}},
+	})
+}
+
+func TestSuite_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.orange")
+	require.NoError(t, os.WriteFile(path, []byte(`$add:
+	;; add returns r1 + r2, but clobbers r11 and pushes an extra value
+	ADD r11, r1, r2
+	PUSH r11
+	PUSH r11
+	BREG rrp
+
+$sum:
+	;; sum returns r1 + r2
+	ADD r3, r1, r2
+	PUSH r3
+	BREG rrp
+`), 0644))
+
+	r := (&Suite{Function: "add", Files: []string{path}}).Run(context.Background(), &Case{
+		Args:    []Arg{Int(2), Int(-3)},
+		Returns: []int64{-1},
+	})
+	assert.NoError(t, r.Err)
+	assert.Equal(t, []string{
+		"callee-saved register r11 = 0xffffffffffffffff, want 0x5a5a0000c0de000b",
+		"stack pointer = 0x7fffffd8, want 0x7fffffe0 for 1 returned values (-8 bytes)",
+	}, r.Failures)
+
+	r = (&Suite{Function: "sum", Files: []string{path}}).Run(context.Background(), &Case{
+		Args:    []Arg{Int(2), Int(-3)},
+		Returns: []int64{-1},
+	})
+	assert.True(t, r.Passed(), r.Failures)
+	assert.Equal(t, []uint64{^uint64(0)}, r.Returns)
+}
+
+func TestLoadSuite(t *testing.T) {
+	dir := t.TempDir()
+	strioPath, err := filepath.Abs(strio)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib.orange"), []byte(`$first:
+	;; first returns r1
+	PUSH r1
+	BREG rrp
+`), 0644))
+
+	path := filepath.Join(dir, "cases.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`function: strCmp
+files: [`+strioPath+`, lib.orange]
+maxSteps: 5000
+cases:
+  - name: equal
+    args: [orange, orange]
+    returns: [0]
+  - args: ["a", "b"]
+    returns: [-1]
+`), 0644))
+
+	suite, cases, err := LoadSuite(path)
+	require.NoError(t, err)
+	assert.Equal(t, &Suite{
+		Function: "strCmp",
+		Files:    []string{strioPath, filepath.Join(dir, "lib.orange")},
+		MaxSteps: 5000,
+	}, suite)
+	require.Len(t, cases, 2)
+	assert.Equal(t, []Arg{String("orange"), String("orange")}, cases[0].Args)
+	suite.Test(t, cases)
+
+	require.NoError(t, os.WriteFile(path, []byte(`function: first
+files: [lib.orange]
+cases:
+  - args: [-7]
+    returns: [-7]
+  - args: ["12"]
+`), 0644))
+	suite, cases, err = LoadSuite(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Arg{Int(-7)}, cases[0].Args)
+	assert.Equal(t, []Arg{String("12")}, cases[1].Args, "quoted numbers are strings")
+	suite.Test(t, cases[:1])
+
+	for source, want := range map[string]string{
+		"files: [lib.orange]\n":                           "missing function",
+		"function: f\ncases:\n  - args: [[1]]\n":          "cases.yaml:3: argument must be a string or an integer",
+		"function: f\ncases:\n  - arguments: [1]\n":       "field arguments not found",
+		"function: f\ncases:\n  - args: [0x10]\n  - {}\n": "",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(source), 0644))
+		_, _, err := LoadSuite(path)
+		if want == "" {
+			assert.NoError(t, err, source)
+		} else {
+			require.Error(t, err, source)
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package asmtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/linker"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Suite tests a function of a set of source files
+type Suite struct {
+	// Function is the label of the function, without the $
+	Function string
+	// Files are the source files linked with the driver
+	Files []string
+	// MaxSteps is the number of instructions a case may execute, or zero for
+	// DefaultMaxSteps
+	MaxSteps uint64
+}
+
+// Result is the outcome of a call of the function
+type Result struct {
+	Case *Case
+
+	// Returns holds the values left on the stack by the function, as many as
+	// the case expects
+	Returns []uint64
+	Stdout  string
+
+	// Err is set if the case could not be built or the function did not
+	// return
+	Err error
+	// Failures describes each expectation the call did not meet
+	Failures []string
+}
+
+// Passed returns whether the function returned as expected
+func (r *Result) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+func (r *Result) fail(format string, args ...interface{}) {
+	r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+}
+
+// assemble assembles a source file to an object file
+func assemble(source io.Reader, fileName string) ([]byte, error) {
+	var out bytes.Buffer
+	err := asm.Assemble(source, &out, &asm.Options{
+		Target:   asm.TargetObjectFile,
+		Debug:    true,
+		FileName: fileName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble %s: %w", fileName, err)
+	}
+	return out.Bytes(), nil
+}
+
+// assembleFile assembles the source file at path to an object file
+func assembleFile(path string) ([]byte, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	return assemble(source, path)
+}
+
+// build links the files of the suite with a driver for the case
+func (s *Suite) build(c *Case) (*executable.File, error) {
+	source, err := driver(s.Function, c)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := assemble(strings.NewReader(source), "driver.orange")
+	if err != nil {
+		return nil, err
+	}
+	// the driver comes first so that execution starts with it
+	objects := []io.Reader{bytes.NewReader(object)}
+	for _, path := range s.Files {
+		object, err := assembleFile(path)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, bytes.NewReader(object))
+	}
+
+	var out bytes.Buffer
+	if err := linker.Link(objects, &out); err != nil {
+		return nil, fmt.Errorf("failed to link: %w", err)
+	}
+	exe, err := executable.Read(&out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read executable: %w", err)
+	}
+	return exe, nil
+}
+
+// returnAddress returns the address of the HALT that the function returns to
+func returnAddress(exe *executable.File) (uint32, error) {
+	for _, symbol := range exe.Debug.Symbols {
+		if symbol.Name == returnLabel {
+			return symbol.Address, nil
+		}
+	}
+	return 0, fmt.Errorf("driver has no %s label", returnLabel)
+}
+
+// Run calls the function with the arguments of the case and checks how it
+// returned
+func (s *Suite) Run(ctx context.Context, c *Case) *Result {
+	r := &Result{Case: c}
+
+	exe, err := s.build(c)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	halt, err := returnAddress(exe)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	var stdout bytes.Buffer
+	mem := memory.New()
+	sim := vm.NewVirtualMachine(mem, true)
+	sim.SetStandardStreams(strings.NewReader(c.Stdin), &stdout, io.Discard)
+	if err := sim.LoadExecutable(exe, nil, nil, vm.DefaultStackSize); err != nil {
+		r.Err = err
+		return r
+	}
+	sp := sim.Register(arch.StackRegister)
+
+	maxSteps := s.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	err = sim.Run(ctx, vm.RunOptions{MaxSteps: maxSteps})
+	r.Stdout = stdout.String()
+	if err != nil {
+		r.Err = fmt.Errorf("$%s did not return: %w", s.Function, err)
+		return r
+	}
+	// the program counter is past the HALT that stopped the program
+	if pc := sim.PC() - 4; pc != halt {
+		r.Err = fmt.Errorf("$%s did not return: halted at 0x%08x", s.Function, pc)
+		return r
+	}
+
+	for _, register := range calleeSaved {
+		if value, want := sim.Register(register), canary(register); value != want {
+			r.fail("callee-saved register r%d = 0x%x, want 0x%x", register, value, want)
+		}
+	}
+
+	wantSP := sp - uint64(8*len(c.Returns))
+	if got := sim.Register(arch.StackRegister); got != wantSP {
+		// the returned values cannot be found if the stack is unbalanced
+		r.fail("stack pointer = 0x%x, want 0x%x for %d returned values (%+d bytes)",
+			got, wantSP, len(c.Returns), int64(got-wantSP))
+	} else {
+		for i, want := range c.Returns {
+			value, err := mem.Read(uint32(wantSP)+uint32(8*i), 64)
+			if err != nil {
+				r.Err = fmt.Errorf("failed to read returned value: %w", err)
+				return r
+			}
+			r.Returns = append(r.Returns, value)
+			if value != uint64(want) {
+				r.fail("returned value %d = %d, want %d", i, int64(value), want)
+			}
+		}
+	}
+
+	if r.Stdout != c.Stdout {
+		r.fail("stdout = %q, want %q", r.Stdout, c.Stdout)
+	}
+	return r
+}
+
+// Test runs each case as a subtest of t
+func (s *Suite) Test(t *testing.T, cases []Case) {
+	t.Helper()
+
+	for i := range cases {
+		c := &cases[i]
+		name := c.Name
+		if name == "" {
+			args := make([]string, len(c.Args))
+			for i, arg := range c.Args {
+				args[i] = arg.String()
+			}
+			name = fmt.Sprintf("%s(%s)", s.Function, strings.Join(args, ", "))
+		}
+
+		t.Run(name, func(t *testing.T) {
+			r := s.Run(context.Background(), c)
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			for _, failure := range r.Failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
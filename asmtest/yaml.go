@@ -0,0 +1,100 @@
+package asmtest
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// caseFile is the YAML form of a suite and its cases
+type caseFile struct {
+	Function string     `yaml:"function"`
+	Files    []string   `yaml:"files"`
+	MaxSteps uint64     `yaml:"maxSteps"`
+	Cases    []caseYAML `yaml:"cases"`
+}
+
+type caseYAML struct {
+	Name    string      `yaml:"name"`
+	Args    []yaml.Node `yaml:"args"`
+	Returns []int64     `yaml:"returns"`
+	Stdin   string      `yaml:"stdin"`
+	Stdout  string      `yaml:"stdout"`
+}
+
+// LoadSuite reads a Suite and its cases from a YAML case file:
+//
+//	function: strCmp
+//	files: [../programs/std/strio.orange]
+//	cases:
+//	  - name: equal
+//	    args: [orange, orange]
+//	    returns: [0]
+//
+// Arguments written as YAML strings are passed with String and integers with
+// Int, so a number passed as a string must be quoted. Files are relative to
+// the directory of the case file.
+func LoadSuite(path string) (*Suite, []Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var file caseFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Function == "" {
+		return nil, nil, fmt.Errorf("%s: missing function", path)
+	}
+
+	suite := &Suite{
+		Function: file.Function,
+		MaxSteps: file.MaxSteps,
+	}
+	for _, name := range file.Files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		suite.Files = append(suite.Files, name)
+	}
+
+	cases := make([]Case, len(file.Cases))
+	for i, c := range file.Cases {
+		cases[i] = Case{
+			Name:    c.Name,
+			Returns: c.Returns,
+			Stdin:   c.Stdin,
+			Stdout:  c.Stdout,
+		}
+		for _, node := range c.Args {
+			arg, err := argFromYAML(&node)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: %w", path, node.Line, err)
+			}
+			cases[i].Args = append(cases[i].Args, arg)
+		}
+	}
+	return suite, cases, nil
+}
+
+// argFromYAML converts a string or integer scalar to an Arg
+func argFromYAML(node *yaml.Node) (Arg, error) {
+	if node.Kind == yaml.ScalarNode {
+		switch node.Tag {
+		case "!!str":
+			return String(node.Value), nil
+		case "!!int":
+			var value int64
+			if err := node.Decode(&value); err != nil {
+				return Arg{}, err
+			}
+			return Int(value), nil
+		}
+	}
+	return Arg{}, fmt.Errorf("argument must be a string or an integer")
+}
@@ -0,0 +1,108 @@
+package cfg
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/executable"
+	"strings"
+)
+
+// FromExecutable builds the graph of the text segment of an executable.
+// Labels are taken from its debug info, if any. Every word with a valid
+// opcode is taken as an instruction, since data in the text segment can not
+// be told apart from code.
+func FromExecutable(exe *executable.File) *Graph {
+	symbols := make(map[uint32][]string)
+	if exe.Debug != nil {
+		for _, symbol := range exe.Debug.Symbols {
+			symbols[symbol.Address] = append(symbols[symbol.Address], symbol.Name)
+		}
+	}
+
+	var sections []*section
+	for _, seg := range exe.Segments {
+		if seg.Name != executable.TextSection {
+			continue
+		}
+
+		gs := &section{name: seg.Name}
+		for n, word := range seg.Words {
+			address := seg.Address + uint32(n)*4
+			gs.items = append(gs.items, &item{
+				address:     address,
+				labels:      symbols[address],
+				instruction: wordInstruction(word, address, symbols),
+			})
+		}
+		sections = append(sections, gs)
+	}
+	return build(sections)
+}
+
+// wordInstruction decodes the word at the address, returning nil if it is
+// not a valid instruction
+func wordInstruction(word arch.Instruction, address uint32, symbols map[uint32][]string) *Instruction {
+	opcode := arch.GetOpcode(word)
+	var reg arch.RegisterValue
+	switch arch.GetInstructionType(opcode) {
+	case arch.IType_Invalid:
+		return nil
+	case arch.IType_B:
+		reg = arch.DecodeBTypeInstruction(word, opcode).RegA
+	}
+
+	i := &Instruction{
+		Address: address,
+		Opcode:  opcode,
+		Kind:    kindOf(opcode, reg),
+		Word:    word,
+	}
+	if arch.GetInstructionType(opcode) == arch.IType_BI {
+		offset := arch.DecodeBTypeImmInstruction(word, opcode).Offset
+		i.TargetAddress = uint32(int64(address) + int64(offset)*4)
+		i.HasTargetAddress = true
+		if names := symbols[i.TargetAddress]; len(names) > 0 {
+			i.Target = names[0]
+		}
+	}
+	return i
+}
+
+// disassemble returns the assembly of the word. target is the label that a
+// branch goes to, which is shown instead of its offset if it is set.
+func disassemble(word arch.Instruction, target string) string {
+	opcode := arch.GetOpcode(word)
+	// B.EQ and the like are named B_EQ by arch
+	name := strings.Replace(opcode.String(), "_", ".", 1)
+
+	switch arch.GetInstructionType(opcode) {
+	case arch.IType_A:
+		i := arch.DecodeATypeInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d, r%d, r%d", name, i.RegDest, i.RegA, i.RegB)
+	case arch.IType_AI:
+		i := arch.DecodeATypeImmInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d, r%d, #%d", name, i.RegDest, i.RegA, i.Immediate)
+	case arch.IType_M:
+		i := arch.DecodeMTypeInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d, [r%d, #%d]", name, i.RegA, i.RegB, i.Immediate)
+	case arch.IType_E:
+		i := arch.DecodeETypeInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d, #0x%x", name, i.RegDest, i.Immediate)
+	case arch.IType_B:
+		i := arch.DecodeBTypeInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d", name, i.RegA)
+	case arch.IType_BI:
+		if target != "" {
+			return fmt.Sprintf("%s $%s", name, target)
+		}
+		i := arch.DecodeBTypeImmInstruction(word, opcode)
+		return fmt.Sprintf("%s #%d", name, i.Offset)
+	case arch.IType_R:
+		i := arch.DecodeRTypeInstruction(word, opcode)
+		return fmt.Sprintf("%s r%d", name, i.RegA)
+	case arch.IType_O:
+		return name
+	default:
+		return fmt.Sprintf(".fill 0x%08x", word)
+	}
+}
@@ -0,0 +1,85 @@
+package cfg
+
+import (
+	"sort"
+)
+
+// CallGraph records which functions call which. Graphs of several object
+// files are combined by name, so that calls to labels of other files are
+// resolved.
+type CallGraph struct {
+	// Functions holds the names of all callers and callees, sorted
+	Functions []string
+	// Calls maps callers to their sorted callees. BLR calls are not
+	// included, since their target is not known.
+	Calls map[string][]string
+}
+
+// NewCallGraph returns the call graph of the graphs. Calls from code that is
+// not part of a function are attributed to the block it is reached from,
+// like the entry point of a program.
+func NewCallGraph(graphs ...*Graph) *CallGraph {
+	calls := make(map[string]map[string]bool)
+	names := make(map[string]bool)
+	addCall := func(caller string, callee string) {
+		if calls[caller] == nil {
+			calls[caller] = make(map[string]bool)
+		}
+		calls[caller][callee] = true
+		names[caller] = true
+		names[callee] = true
+	}
+
+	for _, g := range graphs {
+		owners := make(map[*Block][]string)
+		for _, f := range g.Functions() {
+			names[f.Name] = true
+			for _, b := range f.Blocks {
+				owners[b] = append(owners[b], f.Name)
+			}
+		}
+
+		// code outside functions is grouped by the blocks that start it
+		for _, b := range g.Blocks {
+			if _, ok := owners[b]; ok || b.Continued || len(b.Preds) > 0 {
+				continue
+			}
+			for _, rb := range g.Reachable(b) {
+				if len(owners[rb]) == 0 {
+					owners[rb] = []string{b.Name()}
+				}
+			}
+		}
+
+		for _, b := range g.Blocks {
+			for _, i := range b.Instructions {
+				if i.Kind != Call {
+					continue
+				}
+				callee := i.Target
+				if target, ok := g.Target(i); ok && callee == "" {
+					callee = target.Name()
+				}
+				if callee == "" {
+					continue
+				}
+				for _, caller := range owners[b] {
+					addCall(caller, callee)
+				}
+			}
+		}
+	}
+
+	c := &CallGraph{Calls: make(map[string][]string)}
+	for name := range names {
+		c.Functions = append(c.Functions, name)
+	}
+	sort.Strings(c.Functions)
+	for caller, callees := range calls {
+		for callee := range callees {
+			c.Calls[caller] = append(c.Calls[caller], callee)
+		}
+		sort.Strings(c.Calls[caller])
+	}
+	return c
+}
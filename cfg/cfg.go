@@ -0,0 +1,333 @@
+// Package cfg builds control-flow graphs of orange programs, either from the
+// statements of an assembler layout or from the words of an executable, and
+// the call graph between their functions.
+package cfg
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm/parser"
+	"strings"
+)
+
+// Kind describes how an instruction transfers control
+type Kind uint8
+
+const (
+	// Next continues with the following instruction
+	Next Kind = iota
+	// Jump is an unconditional B
+	Jump
+	// CondJump is a conditional branch like B.EQ, which continues with the
+	// following instruction if it is not taken
+	CondJump
+	// Call is a BL, which returns to the following instruction
+	Call
+	// IndirectCall is a BLR to the address in a register
+	IndirectCall
+	// Return is a BREG rrp
+	Return
+	// IndirectJump is a BREG to another register
+	IndirectJump
+	// Halt stops the VM
+	Halt
+)
+
+// kindOf returns the kind of an instruction. reg is the register operand of
+// BREG.
+func kindOf(opcode arch.Opcode, reg arch.RegisterValue) Kind {
+	switch opcode {
+	case arch.B:
+		return Jump
+	case arch.B_EQ, arch.B_NEQ, arch.B_LT, arch.B_LE, arch.B_GT, arch.B_GE:
+		return CondJump
+	case arch.BL:
+		return Call
+	case arch.BLR:
+		return IndirectCall
+	case arch.BREG:
+		if reg == arch.ReturnRegister {
+			return Return
+		}
+		return IndirectJump
+	case arch.HALT:
+		return Halt
+	default:
+		return Next
+	}
+}
+
+// Instruction is an instruction of a Block
+type Instruction struct {
+	Address uint32
+	Opcode  arch.Opcode
+	Kind    Kind
+
+	// Target is the label that a branch or call goes to, if it is known
+	Target string
+	// TargetAddress is the address that a branch or call goes to, if
+	// HasTargetAddress is set. Labels of other object files have no address.
+	TargetAddress    uint32
+	HasTargetAddress bool
+
+	// Statement is the source of the instruction in graphs built from a
+	// layout
+	Statement *parser.Statement
+	// Word is the encoded instruction in graphs built from an executable
+	Word arch.Instruction
+}
+
+// EndsBlock returns whether control does not simply continue with the
+// following instruction. Calls return to it.
+func (i *Instruction) EndsBlock() bool {
+	switch i.Kind {
+	case Jump, CondJump, Return, IndirectJump, Halt:
+		return true
+	default:
+		return false
+	}
+}
+
+func (i *Instruction) String() string {
+	if i.Statement != nil {
+		return formatStatement(i.Statement)
+	}
+	return disassemble(i.Word, i.Target)
+}
+
+// Block is a basic block: instructions that are only entered at the first
+// one and only left after the last one
+type Block struct {
+	Section string
+	// Address is the address of the first statement of the block
+	Address uint32
+	Labels  []string
+
+	// Statements holds all statements of the block in graphs built from a
+	// layout, including labels and data directives
+	Statements   []*parser.Statement
+	Instructions []*Instruction
+
+	Succs []*Block
+	Preds []*Block
+
+	// First is set for the first block of its section
+	First bool
+	// Continued is set if the block before it continues into it
+	Continued bool
+}
+
+// Name returns the first label of the block, or its address
+func (b *Block) Name() string {
+	if len(b.Labels) > 0 {
+		return b.Labels[0]
+	}
+	return fmt.Sprintf("0x%08x", b.Address)
+}
+
+// Terminator returns the last instruction of the block if it ends the block
+func (b *Block) Terminator() *Instruction {
+	if len(b.Instructions) == 0 {
+		return nil
+	}
+	last := b.Instructions[len(b.Instructions)-1]
+	if !last.EndsBlock() {
+		return nil
+	}
+	return last
+}
+
+// Graph is the control-flow graph of a program. Calls are not edges of the
+// graph, but are collected into functions and the CallGraph.
+type Graph struct {
+	// Blocks are in address order
+	Blocks []*Block
+
+	labels    map[string]*Block
+	addresses map[uint32]*Block
+	functions []*Function
+}
+
+// Label returns the block starting at the label
+func (g *Graph) Label(name string) (*Block, bool) {
+	b, ok := g.labels[name]
+	return b, ok
+}
+
+// Target returns the block that a branch or call goes to, if it is part of
+// the graph
+func (g *Graph) Target(i *Instruction) (*Block, bool) {
+	if i.Target != "" {
+		if b, ok := g.labels[i.Target]; ok {
+			return b, true
+		}
+	}
+	if i.HasTargetAddress {
+		b, ok := g.addresses[i.TargetAddress]
+		return b, ok
+	}
+	return nil, false
+}
+
+// Reachable returns the blocks reachable from entry without following calls,
+// in address order
+func (g *Graph) Reachable(entry *Block) []*Block {
+	seen := map[*Block]bool{entry: true}
+	stack := []*Block{entry}
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, succ := range b.Succs {
+			if !seen[succ] {
+				seen[succ] = true
+				stack = append(stack, succ)
+			}
+		}
+	}
+
+	var res []*Block
+	for _, b := range g.Blocks {
+		if seen[b] {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// item is a statement or word of a section, in address order
+type item struct {
+	address uint32
+	labels  []string
+	// statement is only set for layouts
+	statement *parser.Statement
+	// instruction is nil for labels and data
+	instruction *Instruction
+}
+
+type section struct {
+	name  string
+	items []*item
+}
+
+// build splits the sections into blocks and connects them. Blocks start at
+// the start of a section, at labels and branch targets and after
+// instructions that end a block.
+func build(sections []*section) *Graph {
+	g := &Graph{
+		labels:    make(map[string]*Block),
+		addresses: make(map[uint32]*Block),
+	}
+
+	targets := make(map[uint32]bool)
+	for _, sec := range sections {
+		for _, it := range sec.items {
+			if it.instruction != nil && it.instruction.HasTargetAddress {
+				targets[it.instruction.TargetAddress] = true
+			}
+		}
+	}
+
+	var sectionBlocks [][]*Block
+	for _, sec := range sections {
+		var blocks []*Block
+		var current *Block
+		// hasContent is set once the current block has more than labels
+		hasContent := false
+		endsBlock := false
+
+		for _, it := range sec.items {
+			leader := current == nil || endsBlock || len(it.labels) > 0 || targets[it.address]
+			if current == nil || (leader && hasContent) {
+				current = &Block{
+					Section: sec.name,
+					Address: it.address,
+					First:   len(blocks) == 0,
+				}
+				blocks = append(blocks, current)
+				hasContent = false
+			}
+
+			for _, label := range it.labels {
+				current.Labels = append(current.Labels, label)
+				if _, ok := g.labels[label]; !ok {
+					// like the layout, keep the first of duplicate labels
+					g.labels[label] = current
+				}
+			}
+			if it.statement != nil {
+				current.Statements = append(current.Statements, it.statement)
+			}
+			if it.instruction != nil {
+				current.Instructions = append(current.Instructions, it.instruction)
+			}
+			// label declarations of layouts take no space, while the words
+			// of executables do even if they are labeled
+			if len(it.labels) == 0 || it.statement == nil {
+				hasContent = true
+			}
+			endsBlock = it.instruction != nil && it.instruction.EndsBlock()
+		}
+
+		for _, b := range blocks {
+			if _, ok := g.addresses[b.Address]; !ok {
+				g.addresses[b.Address] = b
+			}
+		}
+		sectionBlocks = append(sectionBlocks, blocks)
+		g.Blocks = append(g.Blocks, blocks...)
+	}
+
+	// branches may go to any section
+	for _, blocks := range sectionBlocks {
+		for i, b := range blocks {
+			var next *Block
+			if i+1 < len(blocks) {
+				next = blocks[i+1]
+			}
+			g.connect(b, next)
+		}
+	}
+	return g
+}
+
+// connect adds the edges leaving b, where next is the following block in its
+// section or nil
+func (g *Graph) connect(b *Block, next *Block) {
+	addEdge := func(to *Block) {
+		for _, succ := range b.Succs {
+			if succ == to {
+				return
+			}
+		}
+		b.Succs = append(b.Succs, to)
+		to.Preds = append(to.Preds, b)
+	}
+	addNext := func() {
+		if next != nil {
+			next.Continued = true
+			addEdge(next)
+		}
+	}
+
+	terminator := b.Terminator()
+	if terminator == nil {
+		addNext()
+		return
+	}
+
+	switch terminator.Kind {
+	case Jump, CondJump:
+		// branches to other files leave the graph
+		if target, ok := g.Target(terminator); ok {
+			addEdge(target)
+		}
+		if terminator.Kind == CondJump {
+			addNext()
+		}
+	}
+}
+
+// isPrivate returns whether the label is local to its file
+func isPrivate(label string) bool {
+	return strings.HasPrefix(label, "_")
+}
@@ -0,0 +1,99 @@
+package cfg
+
+import (
+	"bytes"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const testSource = `	MOVZ r1, #3
+	BL $count
+	POP r1
+	HALT
+$count:
+	PUSH r10
+	MOVZ r10, #0
+$_count.loop:
+	ADDI r10, r10, #1
+	CMP r10, r1
+	B.LT $_count.loop
+	BL $done
+	CMPI r10, #100
+	B.GT #-7
+	POP r10
+	BREG rrp
+$done:
+	BREG rrp
+`
+
+// describe returns the blocks of the graph as "name: successors" strings
+func describe(g *Graph) []string {
+	var res []string
+	for _, b := range g.Blocks {
+		var succs []string
+		for _, succ := range b.Succs {
+			succs = append(succs, succ.Name())
+		}
+		res = append(res, b.Name()+": "+strings.Join(succs, " "))
+	}
+	return res
+}
+
+func functionNames(g *Graph) []string {
+	var res []string
+	for _, f := range g.Functions() {
+		res = append(res, f.Name)
+	}
+	return res
+}
+
+func TestFromLayout(t *testing.T) {
+	layout, err := asm.ReadLayout(strings.NewReader(testSource))
+	require.NoError(t, err)
+	g := FromLayout(layout)
+
+	assert.Equal(t, []string{
+		"0x00000000: ",
+		"count: _count.loop",
+		"_count.loop: _count.loop 0x00000024",
+		"0x00000024: count 0x00000030",
+		"0x00000030: ",
+		"done: ",
+	}, describe(g))
+	assert.Equal(t, []string{"count", "done"}, functionNames(g))
+	assert.Equal(t, "SUB r0, r10, r1", g.Blocks[2].Instructions[1].String())
+
+	calls := NewCallGraph(g)
+	assert.Equal(t, []string{"0x00000000", "count", "done"}, calls.Functions)
+	assert.Equal(t, map[string][]string{
+		"0x00000000": {"count"},
+		"count":      {"done"},
+	}, calls.Calls)
+}
+
+func TestFromExecutable(t *testing.T) {
+	var image bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(testSource), &image, &asm.Options{
+		Target: asm.TargetExecutable,
+		Debug:  true,
+	}))
+	exe, err := executable.Read(&image)
+	require.NoError(t, err)
+	g := FromExecutable(exe)
+
+	assert.Equal(t, []string{
+		"0x00000000: ",
+		"count: _count.loop",
+		"_count.loop: _count.loop 0x00000024",
+		"0x00000024: count 0x00000030",
+		"0x00000030: ",
+		"done: ",
+	}, describe(g))
+	assert.Equal(t, []string{"count", "done"}, functionNames(g))
+	assert.Equal(t, "B.LT $_count.loop", g.Blocks[2].Instructions[2].String())
+	assert.Equal(t, "B.GT $count", g.Blocks[3].Instructions[2].String())
+}
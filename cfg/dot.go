@@ -0,0 +1,80 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dotString quotes s for Graphviz, where lines end with \l to align them to
+// the left
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\l`) + `"`
+}
+
+// WriteDot writes the graph in the Graphviz dot format. Fallthrough edges are
+// dashed and calls to blocks of the graph are dotted.
+func (g *Graph) WriteDot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "digraph cfg {")
+	_, _ = fmt.Fprintln(bw, `	node [shape=box, fontname="monospace"];`)
+
+	ids := make(map[*Block]string)
+	for n, b := range g.Blocks {
+		ids[b] = fmt.Sprintf("b%d", n)
+	}
+
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		label.WriteString(fmt.Sprintf("%s (0x%08x)\n", b.Name(), b.Address))
+		for _, i := range b.Instructions {
+			label.WriteString(fmt.Sprintf("  %s\n", i))
+		}
+		_, _ = fmt.Fprintf(bw, "\t%s [label=%s];\n", ids[b], dotString(label.String()))
+	}
+
+	for n, b := range g.Blocks {
+		var branchTarget *Block
+		if terminator := b.Terminator(); terminator != nil {
+			branchTarget, _ = g.Target(terminator)
+		}
+		for _, succ := range b.Succs {
+			// edges to the following block that no branch takes fall through
+			if succ != branchTarget && n+1 < len(g.Blocks) && g.Blocks[n+1] == succ {
+				_, _ = fmt.Fprintf(bw, "\t%s -> %s [style=dashed];\n", ids[b], ids[succ])
+			} else {
+				_, _ = fmt.Fprintf(bw, "\t%s -> %s;\n", ids[b], ids[succ])
+			}
+		}
+		for _, i := range b.Instructions {
+			if i.Kind != Call {
+				continue
+			}
+			if target, ok := g.Target(i); ok {
+				_, _ = fmt.Fprintf(bw, "\t%s -> %s [style=dotted];\n", ids[b], ids[target])
+			}
+		}
+	}
+
+	_, _ = fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteDot writes the call graph in the Graphviz dot format
+func (c *CallGraph) WriteDot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "digraph calls {")
+	for _, name := range c.Functions {
+		_, _ = fmt.Fprintf(bw, "\t%s;\n", dotString(name))
+	}
+	for _, caller := range c.Functions {
+		for _, callee := range c.Calls[caller] {
+			_, _ = fmt.Fprintf(bw, "\t%s -> %s;\n", dotString(caller), dotString(callee))
+		}
+	}
+	_, _ = fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,63 @@
+package cfg
+
+// Function is code entered at a block that is either called or labeled with
+// a public label that the code before it does not continue into, and that
+// returns with BREG rrp
+type Function struct {
+	// Name is the label of the entry, preferring the called one, or its
+	// address
+	Name  string
+	Entry *Block
+	// Blocks are the blocks reachable from the entry without following
+	// calls, in address order
+	Blocks []*Block
+	// Returns holds the BREG rrp instructions of the function
+	Returns []*Instruction
+}
+
+// Functions returns the functions of the graph in address order
+func (g *Graph) Functions() []*Function {
+	if g.functions != nil {
+		return g.functions
+	}
+
+	called := make(map[*Block]string)
+	for _, b := range g.Blocks {
+		for _, i := range b.Instructions {
+			if i.Kind != Call {
+				continue
+			}
+			if target, ok := g.Target(i); ok {
+				name := i.Target
+				if name == "" {
+					name = target.Name()
+				}
+				called[target] = name
+			}
+		}
+	}
+
+	res := []*Function{}
+	for _, b := range g.Blocks {
+		name, ok := called[b]
+		if !ok {
+			if len(b.Labels) == 0 || b.Continued || isPrivate(b.Labels[0]) {
+				continue
+			}
+			name = b.Labels[0]
+		}
+
+		f := &Function{Name: name, Entry: b, Blocks: g.Reachable(b)}
+		for _, fb := range f.Blocks {
+			if terminator := fb.Terminator(); terminator != nil && terminator.Kind == Return {
+				f.Returns = append(f.Returns, terminator)
+			}
+		}
+		if len(f.Returns) > 0 {
+			res = append(res, f)
+		}
+	}
+
+	g.functions = res
+	return res
+}
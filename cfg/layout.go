@@ -0,0 +1,101 @@
+package cfg
+
+import (
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/asm/parser"
+	"strings"
+)
+
+// FromLayout builds the graph of the statements of a layout. Branches to
+// labels of other files have no target in the graph.
+func FromLayout(layout *asm.Layout) *Graph {
+	var sections []*section
+	address := uint32(0)
+	for _, sec := range layout.Sections {
+		gs := &section{name: sec.Name}
+		for i, s := range sec.Statements {
+			it := &item{address: address, statement: s}
+			if s.Kind == parser.DirectiveStatement && s.Body[0].Kind == lexer.LABEL_DECLARATION {
+				it.labels = []string{s.Body[0].Value}
+			} else if s.Kind == parser.InstructionStatement {
+				it.instruction = layoutInstruction(layout, s, address)
+			}
+			gs.items = append(gs.items, it)
+			address += uint32(sec.StatementSizes[i])
+		}
+		sections = append(sections, gs)
+	}
+	return build(sections)
+}
+
+// layoutInstruction returns the instruction of the statement at the address
+func layoutInstruction(layout *asm.Layout, s *parser.Statement, address uint32) *Instruction {
+	opcode := lexer.GetTokenOpOpcode(s.Body[0].Kind)
+	var reg arch.RegisterValue
+	if opcode == arch.BREG && len(s.Body) == 2 {
+		reg, _ = asm.ParseRegister(s.Body[1])
+	}
+
+	i := &Instruction{
+		Address:   address,
+		Opcode:    opcode,
+		Kind:      kindOf(opcode, reg),
+		Statement: s,
+	}
+
+	if arch.GetInstructionType(opcode) == arch.IType_BI && len(s.Body) == 2 {
+		operand := s.Body[1]
+		if operand.Kind == lexer.LABEL {
+			i.Target = operand.Value
+			i.TargetAddress, i.HasTargetAddress = layout.LocateLabel(operand.Value)
+		} else if offset, err := asm.ParseSignedImmediate(operand); err == nil {
+			i.TargetAddress = uint32(int64(address) + int64(offset)*4)
+			i.HasTargetAddress = true
+		}
+	}
+	return i
+}
+
+// formatStatement returns the statement as it would be written in source.
+// Pseudo-instructions are shown as the instructions they were translated to.
+func formatStatement(s *parser.Statement) string {
+	var operands []string
+	bracket := false
+	for i := 1; i < len(s.Body); i++ {
+		tok := s.Body[i]
+		var operand string
+		switch tok.Kind {
+		case lexer.LABEL:
+			operand = "$" + tok.Value
+		case lexer.ADDRESS_OF:
+			if i+1 < len(s.Body) {
+				operand = tok.Value + " $" + s.Body[i+1].Value
+				i++
+			} else {
+				operand = tok.Value
+			}
+		case lexer.STRING:
+			operand = `"` + tok.Value + `"`
+		default:
+			operand = tok.Value
+		}
+
+		// the memory operand of M-type instructions is written as [rB, #imm]
+		if s.Kind == parser.InstructionStatement && i == 2 &&
+			arch.GetInstructionType(lexer.GetTokenOpOpcode(s.Body[0].Kind)) == arch.IType_M {
+			operand = "[" + operand
+			bracket = true
+		}
+		operands = append(operands, operand)
+	}
+	if bracket {
+		operands[len(operands)-1] += "]"
+	}
+
+	if len(operands) == 0 {
+		return s.Body[0].Value
+	}
+	return s.Body[0].Value + " " + strings.Join(operands, ", ")
+}
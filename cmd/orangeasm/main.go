@@ -1,17 +1,62 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/lint"
 	"os"
+	"strings"
 )
 
 var (
 	executableFlag = flag.Bool("executable", false, "Compile to executable")
+	debugFlag      = flag.Bool("g", false, "Include source line tables and symbols for debugging")
+	listingFlag    = flag.String("listing", "", "Write a listing of the assembled program to `file`")
+	werrorFlag     = flag.Bool("Werror", false, "Treat warnings as errors")
+	lintFlag       = flag.Bool("lint", false, "Warn about likely mistakes")
+	lintDisable    = flag.String("lint-disable", "", "Comma-separated lint `rules` to skip: "+strings.Join(lint.RuleNames(), ", "))
+	verbosity      verbosityFlag
 )
 
+// verbosityFlag counts how often -v is given
+type verbosityFlag asm.Verbosity
+
+func (v *verbosityFlag) String() string {
+	return fmt.Sprint(uint8(*v))
+}
+
+func (v *verbosityFlag) Set(string) error {
+	if *v < verbosityFlag(asm.Trace) {
+		*v++
+	}
+	return nil
+}
+
+func (v *verbosityFlag) IsBoolFlag() bool {
+	return true
+}
+
+// printSink writes log messages and diagnostics to stderr, showing the source
+// lines that diagnostics refer to
+type printSink struct {
+	fileName string
+	source   []byte
+}
+
+func (p *printSink) Log(message string) {
+	_, _ = fmt.Fprintln(os.Stderr, message)
+}
+
+func (p *printSink) Diagnostic(d *asmerr.Diagnostic) {
+	asmerr.Print(os.Stderr, p.fileName, p.source, d)
+}
+
 func main() {
+	flag.Var(&verbosity, "v", "Log progress; repeat to log every statement")
 	flag.Parse()
 
 	args := flag.Args()
@@ -21,14 +66,14 @@ func main() {
 		return
 	}
 
-	inputFile, err := os.Open(args[0])
+	// the source is kept to show the lines that errors refer to
+	source, err := os.ReadFile(args[0])
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "failed to open input file: %v\n", err)
 		os.Exit(1)
 		return
 	}
-
-	defer inputFile.Close()
+	inputFile := bytes.NewReader(source)
 
 	outputFile, err := os.Create(args[1])
 	if err != nil {
@@ -39,14 +84,48 @@ func main() {
 
 	defer outputFile.Close()
 
+	options := &asm.Options{
+		Target:           asm.TargetObjectFile,
+		Debug:            *debugFlag,
+		FileName:         args[0],
+		Sink:             &printSink{fileName: args[0], source: source},
+		Verbosity:        asm.Verbosity(verbosity),
+		WarningsAsErrors: *werrorFlag,
+	}
 	if *executableFlag {
-		err = asm.AssembleExecutable(inputFile, outputFile)
-	} else {
-		err = asm.AssembleObjectFile(inputFile, outputFile)
+		options.Target = asm.TargetExecutable
+	}
+	if *lintFlag {
+		config := &lint.Config{Executable: *executableFlag}
+		if *lintDisable != "" {
+			if err := config.Disable(strings.Split(*lintDisable, ",")...); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+				return
+			}
+		}
+		options.Lint = func(layout *asm.Layout) asmerr.Diagnostics {
+			return lint.Check(layout, config)
+		}
 	}
+	if *listingFlag != "" {
+		listingFile, err := os.Create(*listingFlag)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to open listing file: %v\n", err)
+			os.Exit(1)
+			return
+		}
 
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		defer listingFile.Close()
+		options.Listing = listingFile
+	}
+
+	if err := asm.Assemble(inputFile, outputFile, options); err != nil {
+		// diagnostics have already been printed by the sink
+		var diagnostics asmerr.Diagnostics
+		if !errors.As(err, &diagnostics) {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to assemble: %v\n", err)
+		}
 		os.Exit(1)
 		return
 	}
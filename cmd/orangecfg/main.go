@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/cfg"
+	"github.com/dnsge/orange/executable"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	dotFlag   = flag.Bool("dot", false, "Write the graphs in the Graphviz dot format")
+	callsFlag = flag.Bool("calls", false, "Write the call graph of all files instead of their control-flow graphs")
+)
+
+// readGraph builds the graph of an executable or of an assembly source file
+func readGraph(fileName string) (*cfg.Graph, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("orange-exe")) {
+		exe, err := executable.Read(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return cfg.FromExecutable(exe), nil
+	}
+
+	layout, err := asm.ReadLayout(bytes.NewReader(data))
+	if err != nil {
+		asmerr.Print(os.Stderr, fileName, data, err)
+		return nil, fmt.Errorf("failed to parse %s", fileName)
+	}
+	return cfg.FromLayout(layout), nil
+}
+
+// writeBlocks writes the blocks of the graph with their instructions and
+// successors
+func writeBlocks(w io.Writer, g *cfg.Graph) {
+	for _, b := range g.Blocks {
+		_, _ = fmt.Fprintf(w, "%s: section %s, address 0x%08x\n", b.Name(), b.Section, b.Address)
+		for _, i := range b.Instructions {
+			_, _ = fmt.Fprintf(w, "\t%08x  %s\n", i.Address, i)
+		}
+		if len(b.Succs) > 0 {
+			names := make([]string, len(b.Succs))
+			for n, succ := range b.Succs {
+				names[n] = succ.Name()
+			}
+			_, _ = fmt.Fprintf(w, "\t-> %s\n", strings.Join(names, ", "))
+		}
+	}
+}
+
+// writeCalls writes every function with its callees
+func writeCalls(w io.Writer, c *cfg.CallGraph) {
+	for _, name := range c.Functions {
+		if callees := c.Calls[name]; len(callees) > 0 {
+			_, _ = fmt.Fprintf(w, "%s -> %s\n", name, strings.Join(callees, ", "))
+		} else {
+			_, _ = fmt.Fprintln(w, name)
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [--dot] [--calls] [source or executable files...]\n", os.Args[0])
+		os.Exit(1)
+		return
+	}
+
+	graphs := make([]*cfg.Graph, len(args))
+	for i, fileName := range args {
+		g, err := readGraph(fileName)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		graphs[i] = g
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if *callsFlag {
+		calls := cfg.NewCallGraph(graphs...)
+		if *dotFlag {
+			_ = calls.WriteDot(out)
+		} else {
+			writeCalls(out, calls)
+		}
+		return
+	}
+
+	for i, g := range graphs {
+		if *dotFlag {
+			_ = g.WriteDot(out)
+			continue
+		}
+		if len(graphs) > 1 {
+			if i > 0 {
+				_, _ = fmt.Fprintln(out)
+			}
+			_, _ = fmt.Fprintf(out, "# %s\n", args[i])
+		}
+		writeBlocks(out, g)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/vm/coverage"
+	"os"
+)
+
+var (
+	lcovFlag = flag.Bool("lcov", false, "Write an LCOV tracefile instead of annotated source")
+)
+
+// readExecutable reads the executable whose runs were recorded
+func readExecutable(path string) (*executable.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open executable: %w", err)
+	}
+	defer f.Close()
+
+	exe, err := executable.Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read executable: %w", err)
+	}
+	return exe, nil
+}
+
+// readProfile reads a coverage file written by orangevm --coverage
+func readProfile(path string) (*coverage.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open coverage file: %w", err)
+	}
+	defer f.Close()
+
+	profile, err := coverage.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [--lcov] [executable] [coverage files...]\n", os.Args[0])
+		os.Exit(1)
+		return
+	}
+
+	exe, err := readExecutable(args[0])
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+
+	// the runs of a test suite are combined
+	profile := coverage.New()
+	for _, path := range args[1:] {
+		p, err := readProfile(path)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+			return
+		}
+		profile.Merge(p)
+	}
+
+	report, err := coverage.NewReport(profile, exe)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v (assemble with -g)\n", err)
+		os.Exit(1)
+		return
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if *lcovFlag {
+		_ = report.WriteLCOV(out)
+		return
+	}
+
+	for i, f := range report.Files {
+		source, err := os.ReadFile(f.Name)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to read source: %v\n", err)
+			continue
+		}
+		if i > 0 {
+			_, _ = fmt.Fprintln(out)
+		}
+		_ = f.WriteAnnotated(out, source)
+	}
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/internal/framing"
+	"github.com/dnsge/orange/vm/debug"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// threadID is the ID of the only thread of a program
+	threadID = 1
+
+	// references of the variable scopes
+	registersReference = 1
+	flagsReference     = 2
+	stackReference     = 3
+
+	// maxStackVariables is the number of stack slots shown as variables
+	maxStackVariables = 64
+
+	// maxBreakpointDistance is how many lines a breakpoint on a line
+	// without code is moved down to reach an instruction
+	maxBreakpointDistance = 16
+)
+
+var (
+	errNotLaunched = fmt.Errorf("no program launched")
+	errRunning     = fmt.Errorf("program is running")
+)
+
+// adapter implements the Debug Adapter Protocol for a single program
+type adapter struct {
+	reader *framing.Reader
+	writer *framing.Writer
+
+	// mu guards seq and cancel, which are used by the goroutine running
+	// the program
+	mu     sync.Mutex
+	seq    int
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	program     *program
+	session     *debug.Session
+	stopOnEntry bool
+	noDebug     bool
+
+	// breakpoints holds the addresses of the breakpoints set in each
+	// source file, and lastBreakpointID the ID given to the last one
+	breakpoints      map[string][]uint32
+	lastBreakpointID int
+
+	// afterResponse is called once the response to the current request
+	// has been sent
+	afterResponse func()
+}
+
+func newAdapter(r io.Reader, w io.Writer) *adapter {
+	return &adapter{
+		reader: framing.NewReader(r),
+		writer: framing.NewWriter(w),
+
+		breakpoints: make(map[string][]uint32),
+	}
+}
+
+// serve handles requests until the client disconnects
+func (a *adapter) serve() error {
+	defer a.interrupt()
+
+	for {
+		content, err := a.reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		body, err := a.handle(&req)
+		a.respond(&req, body, err)
+
+		if a.afterResponse != nil {
+			a.afterResponse()
+			a.afterResponse = nil
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (a *adapter) nextSeq() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	return a.seq
+}
+
+func (a *adapter) respond(req *request, body interface{}, err error) {
+	res := response{
+		Seq:        a.nextSeq(),
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		res.Message = err.Error()
+		res.Body = nil
+	}
+	a.send(res)
+}
+
+func (a *adapter) sendEvent(name string, body interface{}) {
+	a.send(event{
+		Seq:   a.nextSeq(),
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+func (a *adapter) send(message interface{}) {
+	// a client that went away is noticed when reading the next request
+	_ = a.writer.Write(message)
+}
+
+// handle executes a request and returns the body of the response
+func (a *adapter) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsStepBack:                 true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch":
+		return nil, a.launch(req.Arguments)
+	case "setBreakpoints":
+		return a.setBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return breakpointsBody{Breakpoints: []breakpoint{}}, nil
+	case "configurationDone":
+		return nil, a.configurationDone()
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		return a.stackTrace()
+	case "scopes":
+		return scopesBody{Scopes: []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "Flags", VariablesReference: flagsReference},
+			{Name: "Stack", VariablesReference: stackReference},
+		}}, nil
+	case "variables":
+		return a.variables(req.Arguments)
+	case "continue":
+		return continuedBody{AllThreadsContinued: true}, a.resume(a.session.Continue, "")
+	case "next", "stepIn":
+		return nil, a.resume(a.step, "step")
+	case "stepOut":
+		return nil, a.resume(a.stepOut, "step")
+	case "stepBack":
+		return nil, a.resume(a.reverseStep, "step")
+	case "reverseContinue":
+		return nil, a.resume(a.session.ReverseContinue, "")
+	case "pause":
+		a.interrupt()
+		return nil, nil
+	case "terminate":
+		a.interrupt()
+		a.afterResponse = func() {
+			a.sendEvent("terminated", nil)
+		}
+		return nil, nil
+	case "disconnect":
+		a.interrupt()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported request %q", req.Command)
+	}
+}
+
+func (a *adapter) launch(arguments json.RawMessage) error {
+	var args launchArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return fmt.Errorf("missing program")
+	}
+
+	prog, err := loadProgram(&args,
+		&outputWriter{adapter: a, category: "stdout"},
+		&outputWriter{adapter: a, category: "stderr"})
+	if err != nil {
+		return err
+	}
+
+	a.program = prog
+	historyLimit := args.HistoryLimit
+	if historyLimit <= 0 {
+		historyLimit = debug.DefaultHistoryLimit
+	}
+	a.session = debug.NewSession(prog.vm, historyLimit)
+	a.stopOnEntry = args.StopOnEntry
+	a.noDebug = args.NoDebug
+
+	// the client sends breakpoints once the program is loaded
+	a.afterResponse = func() {
+		a.sendEvent("initialized", nil)
+	}
+	return nil
+}
+
+func (a *adapter) configurationDone() error {
+	if a.program == nil {
+		return errNotLaunched
+	}
+
+	if a.noDebug {
+		a.session.ClearBreakpoints()
+		a.program.vm.DisableHistory()
+	} else if a.stopOnEntry {
+		a.afterResponse = func() {
+			a.sendEvent("stopped", stoppedBody{
+				Reason:            "entry",
+				ThreadID:          threadID,
+				AllThreadsStopped: true,
+			})
+		}
+		return nil
+	}
+
+	a.afterResponse = func() {
+		a.start(a.session.Continue, "")
+	}
+	return nil
+}
+
+func (a *adapter) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Clean(args.Source.Path)
+	breakpoints := make([]breakpoint, len(args.Breakpoints))
+	if a.program == nil || !a.program.sources[path] {
+		for i, requested := range args.Breakpoints {
+			breakpoints[i] = breakpoint{
+				Verified: false,
+				Message:  "source is not part of the program",
+				Line:     requested.Line,
+			}
+		}
+		return breakpointsBody{Breakpoints: breakpoints}, nil
+	}
+
+	if err := a.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	// the request replaces the breakpoints of this source only
+	for _, address := range a.breakpoints[path] {
+		a.session.RemoveBreakpoint(address)
+	}
+	delete(a.breakpoints, path)
+	for i, requested := range args.Breakpoints {
+		breakpoints[i] = a.addBreakpoint(path, requested.Line)
+	}
+	return breakpointsBody{Breakpoints: breakpoints}, nil
+}
+
+// addBreakpoint breaks at the first instruction on line of the source file
+// at path, or on the next line with an instruction
+func (a *adapter) addBreakpoint(path string, line int) breakpoint {
+	a.lastBreakpointID++
+	id := a.lastBreakpointID
+
+	for offset := 0; offset <= maxBreakpointDistance; offset++ {
+		addresses := a.program.info.AddressesFor(path, line+offset)
+		if len(addresses) > 0 {
+			a.session.AddBreakpoint(addresses[0])
+			a.breakpoints[path] = append(a.breakpoints[path], addresses[0])
+			return breakpoint{
+				ID:       id,
+				Verified: true,
+				Source:   source{Path: path},
+				Line:     line + offset,
+			}
+		}
+	}
+
+	return breakpoint{
+		ID:       id,
+		Verified: false,
+		Message:  "no instruction on this line",
+		Line:     line,
+	}
+}
+
+func (a *adapter) checkStopped() error {
+	if a.program == nil {
+		return errNotLaunched
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cancel != nil {
+		return errRunning
+	}
+	return nil
+}
+
+// resume runs the program in the background after the response is sent.
+// The stop is reported with reason if set, otherwise with the reason the
+// program stopped.
+func (a *adapter) resume(run func(ctx context.Context) *debug.Stop, reason string) error {
+	if err := a.checkStopped(); err != nil {
+		return err
+	}
+	a.afterResponse = func() {
+		a.start(run, reason)
+	}
+	return nil
+}
+
+func (a *adapter) start(run func(ctx context.Context) *debug.Stop, reason string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	a.mu.Lock()
+	a.cancel = cancel
+	a.done = done
+	a.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		stop := run(ctx)
+
+		a.mu.Lock()
+		a.cancel = nil
+		a.mu.Unlock()
+		cancel()
+
+		a.reportStop(stop, reason)
+	}()
+}
+
+// interrupt stops the running program and waits for it
+func (a *adapter) interrupt() {
+	a.mu.Lock()
+	cancel, done := a.cancel, a.done
+	a.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (a *adapter) step(context.Context) *debug.Stop {
+	return a.session.Step()
+}
+
+func (a *adapter) reverseStep(context.Context) *debug.Stop {
+	return a.session.ReverseStep()
+}
+
+// stepOut runs until the program returns to the address in the return
+// register, which holds the return address until the function calls
+// another function.
+func (a *adapter) stepOut(ctx context.Context) *debug.Stop {
+	returnAddress := uint32(a.program.vm.Register(arch.ReturnRegister))
+	for executed := 0; ; executed++ {
+		if executed%1024 == 0 && ctx.Err() != nil {
+			return &debug.Stop{Reason: debug.StopInterrupted, PC: a.program.vm.PC()}
+		}
+
+		stop := a.session.Step()
+		if stop.Reason != debug.StopStep || stop.PC == returnAddress {
+			return stop
+		}
+		if a.session.HasBreakpoint(stop.PC) {
+			stop.Reason = debug.StopBreakpoint
+			return stop
+		}
+	}
+}
+
+func (a *adapter) reportStop(stop *debug.Stop, reason string) {
+	body := stoppedBody{
+		Reason:            reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	}
+
+	switch stop.Reason {
+	case debug.StopHalted:
+		a.sendEvent("exited", exitedBody{ExitCode: a.program.vm.ExitCode()})
+		a.sendEvent("terminated", nil)
+		return
+	case debug.StopFault:
+		body.Reason = "exception"
+		body.Description = "Fault"
+		body.Text = stop.Fault.Error()
+	case debug.StopBreakpoint:
+		body.Reason = "breakpoint"
+	case debug.StopWatchpoint:
+		body.Reason = "data breakpoint"
+	case debug.StopInterrupted:
+		body.Reason = "pause"
+	case debug.StopHistoryStart:
+		body.Reason = "step"
+		body.Description = "Reached the start of the recorded history"
+	default:
+		if body.Reason == "" {
+			body.Reason = "step"
+		}
+	}
+	a.sendEvent("stopped", body)
+}
+
+func (a *adapter) stackTrace() (interface{}, error) {
+	if err := a.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	pc := a.program.vm.PC()
+	frame := stackFrame{
+		ID:                          0,
+		Name:                        fmt.Sprintf("0x%08x", pc),
+		Column:                      1,
+		InstructionPointerReference: fmt.Sprintf("0x%08x", pc),
+	}
+	if symbol, ok := a.program.info.SymbolFor(pc); ok {
+		frame.Name = symbol.Name
+		if symbol.Address != pc {
+			frame.Name += fmt.Sprintf("+%d", pc-symbol.Address)
+		}
+	}
+	if line, ok := a.program.info.LineFor(pc); ok {
+		frame.Source = &source{
+			Name: filepath.Base(line.File),
+			Path: line.File,
+		}
+		frame.Line = line.Line
+	}
+
+	return stackTraceBody{
+		StackFrames: []stackFrame{frame},
+		TotalFrames: 1,
+	}, nil
+}
+
+func (a *adapter) variables(arguments json.RawMessage) (interface{}, error) {
+	var args variablesArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if err := a.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	var variables []variable
+	switch args.VariablesReference {
+	case registersReference:
+		variables = a.registerVariables()
+	case flagsReference:
+		negative, zero, carry := a.program.vm.Flags()
+		variables = []variable{
+			{Name: "N", Value: fmt.Sprint(negative)},
+			{Name: "Z", Value: fmt.Sprint(zero)},
+			{Name: "C", Value: fmt.Sprint(carry)},
+		}
+	case stackReference:
+		variables = a.stackVariables()
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	return variablesBody{Variables: variables}, nil
+}
+
+// registerName returns the name of a register used by debuggers
+func registerName(n arch.RegisterValue) string {
+	switch n {
+	case arch.StackRegister:
+		return "rsp"
+	case arch.ReturnRegister:
+		return "rrp"
+	default:
+		return fmt.Sprintf("r%d", n)
+	}
+}
+
+func (a *adapter) registerVariables() []variable {
+	sim := a.program.vm
+	variables := make([]variable, 0, arch.RegisterCount+1)
+	for n := arch.RegisterValue(0); n < arch.RegisterCount; n++ {
+		val := sim.Register(n)
+		variables = append(variables, variable{
+			Name:  registerName(n),
+			Value: fmt.Sprintf("0x%016x (%d)", val, int64(val)),
+		})
+	}
+	return append(variables, variable{
+		Name:  "pc",
+		Value: fmt.Sprintf("0x%08x", sim.PC()),
+	})
+}
+
+// stackVariables returns the 64-bit slots between the stack pointer and the
+// top of the stack
+func (a *adapter) stackVariables() []variable {
+	sim := a.program.vm
+	sp := sim.Register(arch.StackRegister)
+	top := uint64(sim.Stack().Top)
+
+	var variables []variable
+	for address := sp; address+8 <= top && len(variables) < maxStackVariables; address += 8 {
+		val, err := sim.Peek(uint32(address), 64)
+		value := fmt.Sprintf("0x%016x (%d)", val, int64(val))
+		if err != nil {
+			value = "<unreadable>"
+		}
+		variables = append(variables, variable{
+			Name:            fmt.Sprintf("[rsp+%d]", address-sp),
+			Value:           value,
+			MemoryReference: fmt.Sprintf("0x%08x", address),
+		})
+	}
+	return variables
+}
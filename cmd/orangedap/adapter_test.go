@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/internal/framing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const counterProgram = `
+	MOVZ r1, #0
+	ADR r2, $counter
+$loop:
+	ADDI r1, #1
+	STREG r1, [r2]
+	CMPI r1, #3
+	B.NEQ $loop
+	HALT
+
+.section data
+
+$counter:	.fill #0
+`
+
+// client is a scripted DAP client
+type client struct {
+	t      *testing.T
+	writer *framing.Writer
+	reader *framing.Reader
+	seq    int
+	served chan error
+}
+
+// message holds the fields of any message sent by the adapter
+type message struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func startAdapter(t *testing.T) *client {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+
+	c := &client{
+		t:      t,
+		writer: framing.NewWriter(requestWriter),
+		reader: framing.NewReader(responseReader),
+		served: make(chan error, 1),
+	}
+	go func() {
+		c.served <- newAdapter(requestReader, responseWriter).serve()
+		_ = responseWriter.Close()
+	}()
+	t.Cleanup(func() {
+		_ = requestWriter.Close()
+		_ = responseReader.Close()
+	})
+	return c
+}
+
+func (c *client) next() *message {
+	content, err := c.reader.Read()
+	require.NoError(c.t, err)
+
+	var m message
+	require.NoError(c.t, json.Unmarshal(content, &m))
+	return &m
+}
+
+// request sends a request and returns its response, failing if it was not
+// successful
+func (c *client) request(command string, arguments interface{}, body interface{}) {
+	c.seq++
+	require.NoError(c.t, c.writer.Write(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": arguments,
+	}))
+
+	for {
+		m := c.next()
+		if m.Type != "response" {
+			continue
+		}
+		require.Equal(c.t, c.seq, m.RequestSeq)
+		require.True(c.t, m.Success, "%s failed: %s", command, m.Message)
+		if body != nil {
+			require.NoError(c.t, json.Unmarshal(m.Body, body))
+		}
+		return
+	}
+}
+
+// event waits for the named event
+func (c *client) event(name string, body interface{}) {
+	for {
+		m := c.next()
+		if m.Type == "event" && m.Event == name {
+			if body != nil {
+				require.NoError(c.t, json.Unmarshal(m.Body, body))
+			}
+			return
+		}
+	}
+}
+
+func (c *client) register(name string) string {
+	var vars variablesBody
+	c.request("variables", variablesArguments{VariablesReference: registersReference}, &vars)
+	for _, v := range vars.Variables {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	c.t.Fatalf("no register %s", name)
+	return ""
+}
+
+func TestAdapter_Breakpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter.orange")
+	require.NoError(t, os.WriteFile(path, []byte(counterProgram), 0644))
+
+	c := startAdapter(t)
+	var caps capabilities
+	c.request("initialize", map[string]string{"adapterID": "orange"}, &caps)
+	assert.True(t, caps.SupportsStepBack)
+
+	c.request("launch", launchArguments{Program: path}, nil)
+	c.event("initialized", nil)
+
+	// line 6 is the STREG instruction, line 9 is HALT and line 4 only
+	// declares a label
+	var set breakpointsBody
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: path},
+		Breakpoints: []sourceBreakpoint{{Line: 6}, {Line: 9}, {Line: 4}, {Line: 100}},
+	}, &set)
+	require.Len(t, set.Breakpoints, 4)
+	assert.True(t, set.Breakpoints[0].Verified)
+	assert.Equal(t, 6, set.Breakpoints[0].Line)
+	assert.True(t, set.Breakpoints[1].Verified)
+	assert.Equal(t, 9, set.Breakpoints[1].Line)
+	assert.True(t, set.Breakpoints[2].Verified)
+	assert.Equal(t, 5, set.Breakpoints[2].Line)
+	assert.False(t, set.Breakpoints[3].Verified)
+
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: path},
+		Breakpoints: []sourceBreakpoint{{Line: 6}, {Line: 9}},
+	}, &set)
+
+	c.request("configurationDone", nil, nil)
+
+	for i := 1; i <= 3; i++ {
+		var stopped stoppedBody
+		c.event("stopped", &stopped)
+		assert.Equal(t, "breakpoint", stopped.Reason)
+
+		var trace stackTraceBody
+		c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+		require.Len(t, trace.StackFrames, 1)
+		assert.Equal(t, 6, trace.StackFrames[0].Line)
+		assert.Equal(t, "loop+4", trace.StackFrames[0].Name)
+
+		assert.Equal(t, fmt.Sprintf("0x%016x (%d)", i, i), c.register("r1"))
+		c.request("continue", map[string]int{"threadId": threadID}, nil)
+	}
+
+	var stopped stoppedBody
+	c.event("stopped", &stopped)
+	assert.Equal(t, "breakpoint", stopped.Reason)
+
+	var trace stackTraceBody
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+	assert.Equal(t, 9, trace.StackFrames[0].Line)
+
+	// the stack starts with the argument count
+	var stack variablesBody
+	c.request("variables", variablesArguments{VariablesReference: stackReference}, &stack)
+	require.NotEmpty(t, stack.Variables)
+	assert.Equal(t, "[rsp+0]", stack.Variables[0].Name)
+	assert.Equal(t, "0x0000000000000001 (1)", stack.Variables[0].Value)
+
+	c.request("stepBack", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	assert.Equal(t, "step", stopped.Reason)
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+	assert.Equal(t, 8, trace.StackFrames[0].Line)
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	assert.Equal(t, "breakpoint", stopped.Reason)
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+
+	var exited exitedBody
+	c.event("exited", &exited)
+	assert.Equal(t, 0, exited.ExitCode)
+	c.event("terminated", nil)
+
+	c.request("disconnect", nil, nil)
+	assert.NoError(t, <-c.served)
+}
+
+func TestAdapter_Links(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.orange")
+	require.NoError(t, os.WriteFile(mainPath, []byte(`
+	BL $addOne
+	BL $double
+	HALT
+`), 0644))
+	libPath := filepath.Join(dir, "add.orange")
+	require.NoError(t, os.WriteFile(libPath, []byte(`
+$addOne:
+	ADDI r1, r1, #1
+	BREG rrp
+`), 0644))
+
+	// the object file is assembled from a source file that no longer exists
+	var object bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(`
+$double:
+	ADD r1, r1, r1
+	BREG rrp
+`), &object, &asm.Options{
+		Target:   asm.TargetObjectFile,
+		Debug:    true,
+		FileName: filepath.Join(dir, "double.orange"),
+	}))
+	objectPath := filepath.Join(dir, "double.o")
+	require.NoError(t, os.WriteFile(objectPath, object.Bytes(), 0644))
+
+	c := startAdapter(t)
+	c.request("initialize", map[string]string{"adapterID": "orange"}, nil)
+	c.request("launch", launchArguments{Program: mainPath, Links: []string{libPath, objectPath}}, nil)
+	c.event("initialized", nil)
+
+	var set breakpointsBody
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: libPath},
+		Breakpoints: []sourceBreakpoint{{Line: 3}},
+	}, &set)
+	require.Len(t, set.Breakpoints, 1)
+	assert.True(t, set.Breakpoints[0].Verified)
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: filepath.Join(dir, "double.orange")},
+		Breakpoints: []sourceBreakpoint{{Line: 3}},
+	}, &set)
+	require.Len(t, set.Breakpoints, 1)
+	assert.True(t, set.Breakpoints[0].Verified)
+	assert.Equal(t, 2, set.Breakpoints[0].ID, "breakpoint IDs are unique across sources")
+
+	c.request("configurationDone", nil, nil)
+
+	// breakpoints in one source do not replace those in another
+	var stopped stoppedBody
+	var trace stackTraceBody
+	c.event("stopped", &stopped)
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+	assert.Equal(t, "addOne", trace.StackFrames[0].Name)
+	assert.Equal(t, libPath, trace.StackFrames[0].Source.Path)
+	assert.Equal(t, 3, trace.StackFrames[0].Line)
+	assert.True(t, strings.HasPrefix(c.register("rsp"), "0x000000007fff"), "the stack pointer is named rsp")
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+	assert.Equal(t, "double", trace.StackFrames[0].Name)
+	assert.Equal(t, "double.orange", trace.StackFrames[0].Source.Name)
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.event("terminated", nil)
+	c.request("disconnect", nil, nil)
+	assert.NoError(t, <-c.served)
+}
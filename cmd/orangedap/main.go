@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// orangedap is a Debug Adapter Protocol server for Orange assembly
+// programs. Editors start it and communicate with it over stdin and stdout.
+func main() {
+	if len(os.Args) > 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s (speaks the Debug Adapter Protocol on stdin and stdout)\n", os.Args[0])
+		os.Exit(1)
+		return
+	}
+
+	if err := newAdapter(os.Stdin, os.Stdout).serve(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "orangedap: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/linker"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// program is a program loaded into a VM for debugging
+type program struct {
+	vm   *vm.VirtualMachine
+	info *debuginfo.Info
+	// sources are the absolute paths of the source files in info
+	sources map[string]bool
+}
+
+// loadProgram assembles the source file named in args, links it with the
+// source and object files in args.Links like orangelinker would and prepares
+// a VM to run it like orangevm would, writing its output to stdout and
+// stderr.
+func loadProgram(args *launchArguments, stdout io.Writer, stderr io.Writer) (*program, error) {
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := buildProgram(path, args.Links)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := vm.NewVirtualMachine(memory.New(), true)
+	// the program has no input, since the client owns the adapter's stdin
+	sim.SetStandardStreams(bytes.NewReader(nil), stdout, stderr)
+
+	if args.Sandbox != "" {
+		fileSystem, err := vm.DirFS(args.Sandbox)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sandbox: %w", err)
+		}
+		sim.SetFileSystem(fileSystem)
+	}
+
+	programArgs := append([]string{args.Program}, args.Args...)
+	if err := sim.LoadExecutable(exe, programArgs, args.Env, vm.DefaultStackSize); err != nil {
+		return nil, err
+	}
+
+	// object files may name their sources relative to where they were
+	// assembled, which is assumed to be the working directory
+	sources := make(map[string]bool)
+	for i, line := range exe.Debug.Lines {
+		if !filepath.IsAbs(line.File) {
+			if abs, err := filepath.Abs(line.File); err == nil {
+				exe.Debug.Lines[i].File = abs
+			}
+		}
+		sources[exe.Debug.Lines[i].File] = true
+	}
+
+	return &program{
+		vm:      sim,
+		info:    exe.Debug,
+		sources: sources,
+	}, nil
+}
+
+// buildProgram assembles the source file at path into an executable. If
+// there are links, which are .orange source files or object files, they are
+// linked with it.
+func buildProgram(path string, links []string) (*executable.File, error) {
+	var image []byte
+	if len(links) == 0 {
+		var err error
+		image, err = assemble(path, asm.TargetExecutable)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		object, err := assemble(path, asm.TargetObjectFile)
+		if err != nil {
+			return nil, err
+		}
+		objects := []io.Reader{bytes.NewReader(object)}
+
+		for _, link := range links {
+			link, err := filepath.Abs(link)
+			if err != nil {
+				return nil, err
+			}
+
+			if filepath.Ext(link) == ".orange" {
+				object, err = assemble(link, asm.TargetObjectFile)
+			} else {
+				object, err = os.ReadFile(link)
+			}
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, bytes.NewReader(object))
+		}
+
+		var out bytes.Buffer
+		if err := linker.Link(objects, &out); err != nil {
+			return nil, fmt.Errorf("failed to link program: %w", err)
+		}
+		image = out.Bytes()
+	}
+
+	exe, err := executable.Read(bytes.NewReader(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read assembled program: %w", err)
+	}
+	return exe, nil
+}
+
+// assemble assembles the source file at path with debug info
+func assemble(path string, target asm.Target) ([]byte, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	defer source.Close()
+
+	var out bytes.Buffer
+	err = asm.Assemble(source, &out, &asm.Options{
+		Target:   target,
+		Debug:    true,
+		FileName: path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble %s: %w", path, err)
+	}
+	return out.Bytes(), nil
+}
+
+// outputWriter forwards the output of the program to the client
+type outputWriter struct {
+	adapter  *adapter
+	category string
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	o.adapter.sendEvent("output", outputBody{
+		Category: o.category,
+		Output:   string(p),
+	})
+	return len(p), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import "encoding/json"
+
+// The subset of the Debug Adapter Protocol used by the adapter. See
+// https://microsoft.github.io/debug-adapter-protocol/specification
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsStepBack                 bool `json:"supportsStepBack"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type launchArguments struct {
+	// Program is the path of the .orange source file to debug
+	Program string `json:"program"`
+	// Links are .orange source files and object files linked with Program
+	Links       []string `json:"links"`
+	Args        []string `json:"args"`
+	Env         []string `json:"env"`
+	Sandbox     string   `json:"sandbox"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+	NoDebug     bool     `json:"noDebug"`
+	// HistoryLimit is the number of instructions that can be reversed, or
+	// zero for debug.DefaultHistoryLimit
+	HistoryLimit int `json:"historyLimit"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	ID       int    `json:"id,omitempty"`
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+	Source   source `json:"source,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+type breakpointsBody struct {
+	Breakpoints []breakpoint `json:"breakpoints"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type threadsBody struct {
+	Threads []thread `json:"threads"`
+}
+
+type stackFrame struct {
+	ID                          int     `json:"id"`
+	Name                        string  `json:"name"`
+	Source                      *source `json:"source,omitempty"`
+	Line                        int     `json:"line"`
+	Column                      int     `json:"column"`
+	InstructionPointerReference string  `json:"instructionPointerReference,omitempty"`
+}
+
+type stackTraceBody struct {
+	StackFrames []stackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type scopesBody struct {
+	Scopes []scope `json:"scopes"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type variablesBody struct {
+	Variables []variable `json:"variables"`
+}
+
+type stoppedBody struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	Text              string `json:"text,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+type continuedBody struct {
+	ThreadID            int  `json:"threadId"`
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type outputBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type exitedBody struct {
+	ExitCode int `json:"exitCode"`
+}
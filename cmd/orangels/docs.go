@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dnsge/orange"
+	"strings"
+)
+
+// opcodeDoc documents an instruction, as listed in ISA.md
+type opcodeDoc struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// formatLayout describes the encoding of an instruction type
+type formatLayout struct {
+	Bits        string
+	Description string
+}
+
+// opcodeDocs and formatLayouts are read from the tables of ISA.md, so the
+// hover text matches the documentation
+var (
+	opcodeDocs    = readOpcodeDocs(orange.ISA)
+	formatLayouts = readFormatLayouts(orange.ISA)
+)
+
+// registerDocs documents the registers with a special purpose
+var registerDocs = map[string]string{
+	"r0":  "Zero register (returns 0, ignores writes)",
+	"rzr": "Zero register (returns 0, ignores writes), alias of `r0`",
+	"r14": "Stack pointer",
+	"rsp": "Stack pointer, alias of `r14`",
+	"r15": "Return address (`BL` instruction)",
+	"rrp": "Return address (`BL` instruction), alias of `r15`",
+	"r7":  "Syscall return value",
+	"r8":  "Syscall error (or zero)",
+	"r9":  "Syscall number",
+}
+
+// directiveDocs documents the assembler directives
+var directiveDocs = map[string]string{
+	".section":   "Places the following statements in the named section",
+	".fill":      "Emits an immediate value",
+	".string":    "Emits a string",
+	".addressOf": "Emits the address of a label",
+}
+
+// tableRows returns the cells of the rows of the markdown table following
+// the given heading, without the header and delimiter rows
+func tableRows(markdown string, heading string) [][]string {
+	lines := strings.Split(markdown, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == heading {
+			start = i + 1
+			break
+		}
+	}
+	if start == -1 {
+		return nil
+	}
+
+	var rows [][]string
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "|") {
+			if len(rows) > 0 {
+				break
+			}
+			continue
+		}
+
+		var cells []string
+		for _, cell := range strings.Split(strings.Trim(line, "|"), "|") {
+			cells = append(cells, strings.Trim(strings.TrimSpace(cell), "`"))
+		}
+		rows = append(rows, cells)
+	}
+
+	if len(rows) < 2 {
+		return nil
+	}
+	return rows[2:]
+}
+
+func readOpcodeDocs(markdown string) map[string]opcodeDoc {
+	docs := make(map[string]opcodeDoc)
+	for _, row := range tableRows(markdown, "## Opcodes") {
+		if len(row) != 3 {
+			continue
+		}
+		docs[row[0]] = opcodeDoc{
+			Name:        row[0],
+			Type:        row[1],
+			Description: row[2],
+		}
+	}
+	return docs
+}
+
+func readFormatLayouts(markdown string) map[string]formatLayout {
+	layouts := make(map[string]formatLayout)
+	for _, row := range tableRows(markdown, "## Instruction Formats") {
+		if len(row) != 3 {
+			continue
+		}
+		layouts[row[0]] = formatLayout{
+			Bits:        row[1],
+			Description: row[2],
+		}
+	}
+	return layouts
+}
+
+// markdown returns the hover text of the instruction
+func (o opcodeDoc) markdown() string {
+	text := fmt.Sprintf("**%s** (%s)\n\n%s", o.Name, o.Type, o.Description)
+	if layout, ok := formatLayouts[o.Type]; ok {
+		text += fmt.Sprintf("\n\n%s: `%s`", layout.Description, layout.Bits)
+	}
+	return text
+}
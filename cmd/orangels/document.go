@@ -0,0 +1,234 @@
+package main
+
+import (
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/asmerr"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/asm/lint"
+	"github.com/dnsge/orange/asm/parser"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open source file and the result of analyzing it
+type document struct {
+	uri   string
+	lines []string
+
+	// tokens is nil if the document could not be tokenized
+	tokens      []*lexer.Token
+	diagnostics []diagnostic
+
+	// declarations maps label names to their declaring token
+	declarations map[string]*lexer.Token
+	// references maps label names to the tokens using them
+	references map[string][]*lexer.Token
+}
+
+// analyze runs the assembler on the text, collecting the problems found as
+// diagnostics, and indexes the labels of the valid lines
+func analyze(uri string, text string) *document {
+	doc := &document{
+		uri:          uri,
+		lines:        strings.Split(text, "\n"),
+		diagnostics:  []diagnostic{},
+		declarations: make(map[string]*lexer.Token),
+		references:   make(map[string][]*lexer.Token),
+	}
+
+	// lines with invalid tokens are left out, and reported by the assembler
+	tokens, _ := parser.TokenizeAll([]byte(text))
+	doc.tokens = tokens
+
+	for _, tok := range tokens {
+		switch tok.Kind {
+		case lexer.LABEL_DECLARATION:
+			if _, ok := doc.declarations[tok.Value]; !ok {
+				doc.declarations[tok.Value] = tok
+			}
+		case lexer.LABEL:
+			doc.references[tok.Value] = append(doc.references[tok.Value], tok)
+		}
+	}
+
+	// assemble an object file, since labels other than private ones may be
+	// resolved by the linker, and report likely mistakes as warnings
+	sink := &collectingSink{}
+	err := asm.Assemble(strings.NewReader(text), io.Discard, &asm.Options{
+		Target: asm.TargetObjectFile,
+		Sink:   sink,
+		Lint: func(layout *asm.Layout) asmerr.Diagnostics {
+			return lint.Check(layout, nil)
+		},
+	})
+	problems := sink.diagnostics
+	if err != nil && len(problems) == 0 {
+		problems.Add(err)
+	}
+
+	doc.addDiagnostics(problems)
+	return doc
+}
+
+// collectingSink collects the diagnostics of the assembler
+type collectingSink struct {
+	diagnostics asmerr.Diagnostics
+}
+
+func (c *collectingSink) Log(string) {}
+
+func (c *collectingSink) Diagnostic(d *asmerr.Diagnostic) {
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+// addDiagnostics converts the diagnostics to protocol diagnostics
+func (d *document) addDiagnostics(list asmerr.Diagnostics) {
+	for _, item := range list {
+		severity := severityError
+		if item.Severity == asmerr.SeverityWarning {
+			severity = severityWarning
+		}
+		d.diagnostics = append(d.diagnostics, diagnostic{
+			Range:    d.spanRange(item.Span),
+			Severity: severity,
+			Source:   "orange",
+			Message:  item.Message,
+		})
+	}
+}
+
+// spanRange converts a span to a protocol range. Spans without a position
+// cover the first line.
+func (d *document) spanRange(span asmerr.Span) textRange {
+	if span.Row == 0 {
+		return textRange{End: position{Character: d.character(0, len(d.lines[0]))}}
+	}
+	return textRange{
+		Start: d.position(span.Row, span.Column),
+		End:   d.position(span.Row, span.Column+span.Length),
+	}
+}
+
+// tokenRange returns the range covered by the token
+func (d *document) tokenRange(tok *lexer.Token) textRange {
+	return d.spanRange(asmerr.TokenSpan(tok))
+}
+
+// position converts a 1-based row and byte column to a protocol position
+func (d *document) position(row int, column int) position {
+	line := row - 1
+	if line < 0 {
+		line = 0
+	} else if line >= len(d.lines) {
+		line = len(d.lines) - 1
+	}
+	return position{
+		Line:      line,
+		Character: d.character(line, column-1),
+	}
+}
+
+// character converts a byte offset within the line to UTF-16 code units
+func (d *document) character(line int, offset int) int {
+	text := d.lines[line]
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(text) {
+		offset = len(text)
+	}
+
+	units := 0
+	for _, r := range text[:offset] {
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return units
+}
+
+// offset converts a protocol position to a byte offset within its line
+func (d *document) offset(pos position) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0
+	}
+	text := d.lines[pos.Line]
+
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return i
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return len(text)
+}
+
+// tokenAtPosition returns the token under the cursor, or nil
+func (d *document) tokenAtPosition(pos position) *lexer.Token {
+	row := pos.Line + 1
+	column := d.offset(pos) + 1
+	for _, tok := range d.tokens {
+		if tok.Row != row {
+			continue
+		}
+		// the end is inclusive so a cursor right after a token still
+		// refers to it
+		if column >= tok.Column && column <= tok.Column+asmerr.TokenSpan(tok).Length {
+			return tok
+		}
+	}
+	return nil
+}
+
+// wordRange returns the range of the partial word before the cursor, which
+// includes the $ of labels and the . of directives
+func (d *document) wordRange(pos position) textRange {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return textRange{Start: pos, End: pos}
+	}
+	text := d.lines[pos.Line]
+	end := d.offset(pos)
+
+	start := end
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+
+	return textRange{
+		Start: position{Line: pos.Line, Character: d.character(pos.Line, start)},
+		End:   pos,
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '$' || r == '.' || r == '_' ||
+		(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// labelAt returns the name of the label declared or referenced under the
+// cursor
+func (d *document) labelAt(pos position) (string, bool) {
+	tok := d.tokenAtPosition(pos)
+	if tok == nil || (tok.Kind != lexer.LABEL && tok.Kind != lexer.LABEL_DECLARATION) {
+		return "", false
+	}
+	return tok.Value, true
+}
+
+func (d *document) location(tok *lexer.Token) location {
+	return location{
+		URI:   d.uri,
+		Range: d.tokenRange(tok),
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// orangels is a Language Server Protocol server for Orange assembly files.
+// Editors start it and communicate with it over stdin and stdout.
+func main() {
+	if len(os.Args) > 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s (speaks the Language Server Protocol on stdin and stdout)\n", os.Args[0])
+		os.Exit(1)
+		return
+	}
+
+	s := newServer(os.Stdin, os.Stdout)
+	if err := s.serve(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "orangels: %v\n", err)
+		os.Exit(1)
+	}
+
+	// the protocol asks for a failing exit code when the client did not
+	// shut the server down first
+	if !s.shutdown {
+		os.Exit(1)
+	}
+}
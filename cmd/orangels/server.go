@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm/lexer"
+	"github.com/dnsge/orange/internal/framing"
+	"io"
+	"sort"
+	"strings"
+)
+
+// errExit is returned by handlers to stop serving
+var errExit = errors.New("exit")
+
+// server implements the Language Server Protocol for orange assembly files
+type server struct {
+	reader *framing.Reader
+	writer *framing.Writer
+
+	initialized bool
+	shutdown    bool
+	documents   map[string]*document
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	return &server{
+		reader:    framing.NewReader(r),
+		writer:    framing.NewWriter(w),
+		documents: make(map[string]*document),
+	}
+}
+
+// serve handles messages until the client sends exit or closes the
+// connection
+func (s *server) serve() error {
+	for {
+		content, err := s.reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+		if msg.Method == "" {
+			// a response to a request we never send
+			continue
+		}
+
+		result, rpcErr := s.handle(&msg)
+		if rpcErr == errExit {
+			return nil
+		}
+		if msg.ID == nil {
+			// notifications have no response
+			continue
+		}
+
+		res := response{
+			JSONRPC: "2.0",
+			ID:      msg.ID,
+			Result:  result,
+		}
+		if rpcErr != nil {
+			res.Result = nil
+			res.Error = toResponseError(rpcErr)
+		}
+		if err := s.writer.Write(res); err != nil {
+			return err
+		}
+	}
+}
+
+// requestError is an error with a JSON-RPC error code
+type requestError struct {
+	code    int
+	message string
+}
+
+func (r *requestError) Error() string {
+	return r.message
+}
+
+func toResponseError(err error) *responseError {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return &responseError{Code: reqErr.code, Message: reqErr.message}
+	}
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+func (s *server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		return &initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   textDocumentSyncFull,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				ReferencesProvider: true,
+				CompletionProvider: completionOptions{
+					TriggerCharacters: []string{"$", "."},
+				},
+			},
+			ServerInfo: serverInfo{Name: "orangels"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "exit":
+		return nil, errExit
+	}
+
+	if !s.initialized {
+		return nil, &requestError{code: codeServerNotInitialized, message: "server not initialized"}
+	} else if s.shutdown {
+		return nil, &requestError{code: codeInvalidRequest, message: "server is shut down"}
+	}
+
+	switch msg.Method {
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// with full synchronization, the last change holds the whole text
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.publishDiagnostics(params.TextDocument.URI, []diagnostic{})
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(&params), nil
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.references(&params), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(&params), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(&params), nil
+	default:
+		return nil, &requestError{code: codeMethodNotFound, message: fmt.Sprintf("unsupported method %s", msg.Method)}
+	}
+}
+
+// update analyzes the new text of a document and publishes its diagnostics
+func (s *server) update(uri string, text string) error {
+	doc := analyze(uri, text)
+	s.documents[uri] = doc
+	return s.publishDiagnostics(uri, doc.diagnostics)
+}
+
+func (s *server) publishDiagnostics(uri string, diagnostics []diagnostic) error {
+	err := s.writer.Write(&notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: &publishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: diagnostics,
+		},
+	})
+	if err != nil {
+		// the connection is gone, so stop serving
+		return errExit
+	}
+	return nil
+}
+
+// definition returns the declaration of the label under the cursor
+func (s *server) definition(params *textDocumentPositionParams) interface{} {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	name, ok := doc.labelAt(params.Position)
+	if !ok {
+		return nil
+	}
+	decl, ok := doc.declarations[name]
+	if !ok {
+		return nil
+	}
+	return doc.location(decl)
+}
+
+// references returns the uses of the label under the cursor
+func (s *server) references(params *referenceParams) []location {
+	locations := []location{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return locations
+	}
+	name, ok := doc.labelAt(params.Position)
+	if !ok {
+		return locations
+	}
+
+	if decl, ok := doc.declarations[name]; ok && params.Context.IncludeDeclaration {
+		locations = append(locations, doc.location(decl))
+	}
+	for _, ref := range doc.references[name] {
+		locations = append(locations, doc.location(ref))
+	}
+	return locations
+}
+
+// hover documents the instruction, register, directive or label under the
+// cursor
+func (s *server) hover(params *textDocumentPositionParams) interface{} {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	tok := doc.tokenAtPosition(params.Position)
+	if tok == nil {
+		return nil
+	}
+
+	var text string
+	switch {
+	case lexer.IsTokenOp(tok.Kind):
+		opDoc, ok := opcodeDocs[tok.Value]
+		if !ok {
+			return nil
+		}
+		text = opDoc.markdown()
+	case tok.Kind == lexer.REGISTER:
+		text = fmt.Sprintf("**%s**", tok.Value)
+		if regDoc, ok := registerDocs[tok.Value]; ok {
+			text += "\n\n" + regDoc
+		}
+	case lexer.IsTokenDirective(tok.Kind) && tok.Kind != lexer.LABEL_DECLARATION:
+		text = fmt.Sprintf("**%s**\n\n%s", tok.Value, directiveDocs[tok.Value])
+	case tok.Kind == lexer.LABEL || tok.Kind == lexer.LABEL_DECLARATION:
+		decl, ok := doc.declarations[tok.Value]
+		if !ok {
+			text = fmt.Sprintf("**$%s**\n\nNot declared in this file", tok.Value)
+		} else {
+			text = fmt.Sprintf("**$%s**\n\nDeclared on line %d", tok.Value, decl.Row)
+		}
+	default:
+		return nil
+	}
+
+	r := doc.tokenRange(tok)
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: text},
+		Range:    &r,
+	}
+}
+
+// completion suggests instructions, registers, directives and labels. The
+// client filters them by the partial word before the cursor.
+func (s *server) completion(params *textDocumentPositionParams) *completionList {
+	list := &completionList{Items: []completionItem{}}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return list
+	}
+	word := doc.wordRange(params.Position)
+	prefix := doc.lines[params.Position.Line][doc.offset(word.Start):doc.offset(word.End)]
+
+	add := func(label string, kind int, detail string, documentation string) {
+		item := completionItem{
+			Label:    label,
+			Kind:     kind,
+			Detail:   detail,
+			TextEdit: &textEdit{Range: word, NewText: label},
+		}
+		if documentation != "" {
+			item.Documentation = &markupContent{Kind: "markdown", Value: documentation}
+		}
+		list.Items = append(list.Items, item)
+	}
+
+	switch {
+	case strings.HasPrefix(prefix, "$"):
+		for _, name := range sortedKeys(doc.declarations) {
+			add("$"+name, completionFunction, fmt.Sprintf("line %d", doc.declarations[name].Row), "")
+		}
+	case strings.HasPrefix(prefix, "."):
+		for _, name := range sortedKeys(directiveDocs) {
+			add(name, completionKeyword, "directive", directiveDocs[name])
+		}
+	default:
+		for _, name := range sortedKeys(opcodeDocs) {
+			opDoc := opcodeDocs[name]
+			add(name, completionKeyword, opDoc.Description, opDoc.markdown())
+		}
+		for _, name := range registerNames() {
+			add(name, completionVariable, registerDocs[name], "")
+		}
+	}
+	return list
+}
+
+// registerNames returns the names of the registers and their aliases
+func registerNames() []string {
+	var names []string
+	for n := arch.RegisterValue(0); n < arch.RegisterCount; n++ {
+		names = append(names, fmt.Sprintf("r%d", n))
+	}
+	return append(names, "rzr", "rsp", "rrp")
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*lexer.Token:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]opcodeDoc:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
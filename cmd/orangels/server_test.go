@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dnsge/orange/internal/framing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+const documentURI = "file:///counter.orange"
+
+const counterSource = `	MOVZ r1, #0
+	ADR r2, $counter
+$loop:
+	ADDI r1, #1
+	STREG r1, [r2]
+	CMPI r1, #3
+	B.NEQ $loop
+	HALT
+
+.section data
+
+$counter:	.fill #0
+`
+
+// client is a scripted LSP client
+type client struct {
+	t      *testing.T
+	writer *framing.Writer
+	reader *framing.Reader
+	id     int
+	served chan error
+}
+
+// incoming holds the fields of any message sent by the server
+type incoming struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func startServer(t *testing.T) *client {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+
+	c := &client{
+		t:      t,
+		writer: framing.NewWriter(requestWriter),
+		reader: framing.NewReader(responseReader),
+		served: make(chan error, 1),
+	}
+	go func() {
+		c.served <- newServer(requestReader, responseWriter).serve()
+		_ = responseWriter.Close()
+	}()
+	t.Cleanup(func() {
+		_ = requestWriter.Close()
+		_ = responseReader.Close()
+	})
+	return c
+}
+
+func (c *client) next() *incoming {
+	content, err := c.reader.Read()
+	require.NoError(c.t, err)
+
+	var m incoming
+	require.NoError(c.t, json.Unmarshal(content, &m))
+	return &m
+}
+
+// request sends a request and decodes its result, failing if it returned an
+// error
+func (c *client) request(method string, params interface{}, result interface{}) {
+	c.id++
+	require.NoError(c.t, c.writer.Write(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      c.id,
+		"method":  method,
+		"params":  params,
+	}))
+
+	for {
+		m := c.next()
+		if m.ID == nil {
+			continue
+		}
+		require.Equal(c.t, c.id, *m.ID)
+		require.Nil(c.t, m.Error, "%s failed", method)
+		if result != nil {
+			require.NoError(c.t, json.Unmarshal(m.Result, result))
+		}
+		return
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	require.NoError(c.t, c.writer.Write(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}))
+}
+
+// diagnostics waits for the diagnostics of the test document
+func (c *client) diagnostics() []diagnostic {
+	for {
+		m := c.next()
+		if m.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params publishDiagnosticsParams
+		require.NoError(c.t, json.Unmarshal(m.Params, &params))
+		require.Equal(c.t, documentURI, params.URI)
+		return params.Diagnostics
+	}
+}
+
+func at(line int, character int) textDocumentPositionParams {
+	return textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: documentURI},
+		Position:     position{Line: line, Character: character},
+	}
+}
+
+func change(text string) didChangeParams {
+	return didChangeParams{
+		TextDocument:   textDocumentItem{URI: documentURI},
+		ContentChanges: []contentChange{{Text: text}},
+	}
+}
+
+func TestServer(t *testing.T) {
+	c := startServer(t)
+
+	var result initializeResult
+	c.request("initialize", map[string]interface{}{}, &result)
+	assert.True(t, result.Capabilities.HoverProvider)
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", didOpenParams{
+		TextDocument: textDocumentItem{URI: documentURI, Text: counterSource},
+	})
+	assert.Empty(t, c.diagnostics())
+
+	// the reference to $loop on line 7 leads to its declaration on line 3
+	var definition location
+	c.request("textDocument/definition", at(6, 9), &definition)
+	assert.Equal(t, textRange{
+		Start: position{Line: 2, Character: 0},
+		End:   position{Line: 2, Character: 6},
+	}, definition.Range)
+
+	var refs []location
+	c.request("textDocument/references", referenceParams{textDocumentPositionParams: at(11, 2)}, &refs)
+	require.Len(t, refs, 1)
+	assert.Equal(t, position{Line: 1, Character: 9}, refs[0].Range.Start)
+
+	var h hover
+	c.request("textDocument/hover", at(4, 3), &h)
+	assert.Contains(t, h.Contents.Value, "**STREG** (M-Type)")
+	assert.Contains(t, h.Contents.Value, "Store 8 byte register")
+
+	var completions completionList
+	c.request("textDocument/completion", at(6, 8), &completions)
+	require.Len(t, completions.Items, 2)
+	assert.Equal(t, "$counter", completions.Items[0].Label)
+	assert.Equal(t, "$loop", completions.Items[1].Label)
+
+	c.request("textDocument/completion", at(0, 3), &completions)
+	labels := make([]string, len(completions.Items))
+	for i, item := range completions.Items {
+		labels[i] = item.Label
+	}
+	assert.Contains(t, labels, "MOVZ")
+	assert.Contains(t, labels, "SYSCALL")
+	assert.Contains(t, labels, "rsp")
+
+	// an unknown private label is an error, at the reference
+	c.notify("textDocument/didChange", change("\tB $_missing\n"))
+	diagnostics := c.diagnostics()
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, textRange{
+		Start: position{Line: 0, Character: 3},
+		End:   position{Line: 0, Character: 12},
+	}, diagnostics[0].Range)
+
+	c.notify("textDocument/didChange", change("$a:\n$a:\n"))
+	diagnostics = c.diagnostics()
+	require.Len(t, diagnostics, 1)
+	assert.Contains(t, diagnostics[0].Message, "duplicate label")
+	assert.Equal(t, 1, diagnostics[0].Range.Start.Line)
+
+	// every bad line is reported
+	c.notify("textDocument/didChange", change("\tADD r1,\n\tHALT\n\tMOVZ r1 @\n"))
+	diagnostics = c.diagnostics()
+	require.Len(t, diagnostics, 2)
+	assert.Equal(t, textRange{
+		Start: position{Line: 0, Character: 8},
+		End:   position{Line: 0, Character: 8},
+	}, diagnostics[0].Range)
+	assert.Equal(t, 2, diagnostics[1].Range.Start.Line)
+
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	assert.NoError(t, <-c.served)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dnsge/orange/golden"
+	"os"
+	"os/signal"
+)
+
+var (
+	verboseFlag = flag.Bool("v", false, "Print passing cases too")
+)
+
+func main() {
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"programs"}
+	}
+
+	cases, err := golden.Discover(paths...)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return
+	}
+	if len(cases) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "no test cases found")
+		os.Exit(1)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	failed := 0
+	for _, c := range cases {
+		r := c.Run(ctx)
+		if !r.Passed() {
+			failed++
+		}
+		if !r.Passed() || *verboseFlag {
+			r.Report(os.Stdout)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d cases failed\n", failed, len(cases))
+		os.Exit(1)
+		return
+	}
+	fmt.Printf("ok: %d cases passed\n", len(cases))
+}
@@ -1,60 +1,458 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/executable"
 	"github.com/dnsge/orange/memory"
 	"github.com/dnsge/orange/vm"
+	"github.com/dnsge/orange/vm/callcheck"
+	"github.com/dnsge/orange/vm/coverage"
+	"github.com/dnsge/orange/vm/debug"
+	"github.com/dnsge/orange/vm/gdb"
+	"github.com/dnsge/orange/vm/profile"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
 )
 
 const (
-	stackBottom = 0x7FFF0000
-	stackSize   = 0x10000
+	// exit codes used when the program is stopped by the VM
+	exitCodeFault           = 139
+	exitCodeTimeout         = 124
+	exitCodeInterrupted     = 130
+	exitCodeBudgetExhausted = 152
+	exitCodeKilled          = 137
 )
 
 var (
-	quietFlag = flag.Bool("quiet", false, "Disable printing state")
+	quietFlag         = flag.Bool("quiet", false, "Disable printing state")
+	stackSizeFlag     = flag.Uint("stack-size", vm.DefaultStackSize, "Size of the stack in bytes")
+	sandboxFlag       = flag.String("sandbox", "", "Host directory that the program may access files in")
+	deterministicFlag = flag.Bool("deterministic", false, "Derive the clock from the instruction count and randomness from --seed")
+	seedFlag          = flag.Int64("seed", 0, "Seed for random numbers in deterministic mode")
+	maxStepsFlag      = flag.Uint64("max-steps", 0, "Maximum number of instructions to execute (0 for no limit)")
+	timeoutFlag       = flag.Duration("timeout", 0, "Maximum wall-clock run time, e.g. 10s (0 for no limit)")
+	saveSnapshotFlag  = flag.String("save-snapshot", "", "Write the VM state to this file when the program stops")
+	loadSnapshotFlag  = flag.String("load-snapshot", "", "Resume from a snapshot instead of loading an executable")
+	recordFlag        = flag.String("record", "", "Record the syscalls made by the program to this file")
+	replayFlag        = flag.String("replay", "", "Replay the syscalls recorded in this file instead of executing them")
+	gdbFlag           = flag.String("gdb", "", "Wait for a GDB remote debugger to connect on this address, e.g. :1234")
+	historyLimitFlag  = flag.Int("history-limit", debug.DefaultHistoryLimit, "Number of instructions that can be reversed with --gdb (0 for no limit)")
+	profileFlag       = flag.String("profile", "", "Count executed instructions, writing a pprof profile to this file and a report to stderr")
+	coverageFlag      = flag.String("coverage", "", "Record the executed instructions and branch directions to this file, see orangecov")
+	checkCallsFlag    = flag.Bool("check-calls", false, "Report functions that do not restore r10-r13 or leave the stack unbalanced when they return")
+	profileTopFlag    = flag.Int("profile-top", 10, "Number of functions and instructions in the --profile report (0 for all)")
+	envFlag           envList
 )
 
+// envList collects the values of a repeatable NAME=value flag
+type envList []string
+
+func (e *envList) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *envList) Set(val string) error {
+	if !strings.Contains(val, "=") {
+		return fmt.Errorf("environment variable %q must be of the form NAME=value", val)
+	}
+	*e = append(*e, val)
+	return nil
+}
+
+func init() {
+	flag.Var(&envFlag, "env", "Environment variable NAME=value passed to the program (repeatable)")
+}
+
 func main() {
 	flag.Parse()
 
 	args := flag.Args()
-	if len(args) != 1 {
-		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [input file]\n", os.Args[0])
+	if len(args) == 0 && *loadSnapshotFlag == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s [input file] [-- program arguments...]\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "       %s --load-snapshot [snapshot file]\n", os.Args[0])
 		os.Exit(1)
 		return
 	}
 
-	inputFile, err := os.Open(args[0])
+	sim := vm.NewVirtualMachine(memory.New(), *quietFlag)
+
+	if *deterministicFlag {
+		sim.SetDeterministic(*seedFlag)
+	}
+
+	if *sandboxFlag != "" {
+		fileSystem, err := vm.DirFS(*sandboxFlag)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to open sandbox: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		sim.SetFileSystem(fileSystem)
+	}
+
+	var err error
+	if *loadSnapshotFlag != "" {
+		err = loadSnapshot(sim, *loadSnapshotFlag)
+	} else {
+		err = loadProgram(sim, args)
+	}
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "failed to open input file: %v\n", err)
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 		return
 	}
-	defer inputFile.Close()
 
-	mem := memory.New()
-	sim := vm.NewVirtualMachine(mem, *quietFlag)
-	_, err = mem.LoadFromReader(0, inputFile)
+	var syscallLog *vm.SyscallLog
+	if *replayFlag != "" {
+		err = replaySyscalls(sim, *replayFlag)
+	} else if *recordFlag != "" {
+		syscallLog = sim.RecordSyscalls()
+	}
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "failed to load input file into memory: %v\n", err)
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 		return
 	}
 
-	mem.Alloc(stackBottom+1, stackSize)
-	sim.InitStack(stackBottom + stackSize)
-
 	if !*quietFlag {
 		sim.PrintState()
 	}
 
-	for !sim.Halted() {
-		sim.ExecuteInstruction()
-		if !*quietFlag {
-			sim.PrintState()
+	opts := vm.RunOptions{
+		MaxSteps: *maxStepsFlag,
+		Timeout:  *timeoutFlag,
+	}
+	if !*quietFlag {
+		opts.Step = sim.PrintState
+	}
+
+	var profiler *profile.Profiler
+	if *profileFlag != "" {
+		profiler = profile.New(sim.DebugInfo())
+		sim.AddHook(profiler)
+	}
+	var coverageProfile *coverage.Profile
+	if *coverageFlag != "" {
+		coverageProfile = coverage.New()
+		sim.AddHook(coverageProfile)
+	}
+
+	if *checkCallsFlag {
+		checker := callcheck.New(sim, sim.DebugInfo())
+		checker.Report = func(v *callcheck.Violation) {
+			printViolation(v, sim.DebugInfo())
+		}
+		sim.AddHook(checker)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *gdbFlag != "" {
+		err = serveDebugger(sim, *gdbFlag)
+		var debugErr *debuggerError
+		if errors.As(err, &debugErr) {
+			_, _ = fmt.Fprintf(os.Stderr, "debugger: %v\n", debugErr.Err)
+			os.Exit(1)
+			return
 		}
 	}
+
+	if err == nil {
+		// run the rest of the program after the debugger detached
+		err = sim.Run(ctx, opts)
+	}
+
+	if *saveSnapshotFlag != "" {
+		if err := saveSnapshot(sim, *saveSnapshotFlag); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to save snapshot: %v\n", err)
+		}
+	}
+
+	if profiler != nil {
+		if err := saveProfile(profiler, *profileFlag); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to save profile: %v\n", err)
+		}
+		_ = profiler.WriteReport(os.Stderr, *profileTopFlag)
+	}
+
+	if coverageProfile != nil {
+		if err := saveCoverage(coverageProfile, *coverageFlag); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to save coverage: %v\n", err)
+		}
+	}
+
+	if syscallLog != nil {
+		if err := saveSyscallLog(syscallLog, *recordFlag); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to save syscall log: %v\n", err)
+		}
+	}
+
+	switch {
+	case err == nil:
+		os.Exit(sim.ExitCode())
+	case errors.Is(err, vm.ErrBudgetExhausted):
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitCodeBudgetExhausted)
+	case errors.Is(err, context.DeadlineExceeded):
+		_, _ = fmt.Fprintf(os.Stderr, "error: timed out: %v\n", err)
+		os.Exit(exitCodeTimeout)
+	case errors.Is(err, gdb.ErrKilled):
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitCodeKilled)
+	case errors.Is(err, context.Canceled):
+		_, _ = fmt.Fprintf(os.Stderr, "error: interrupted: %v\n", err)
+		os.Exit(exitCodeInterrupted)
+	default:
+		printFault(err, sim.DebugInfo())
+		os.Exit(exitCodeFault)
+	}
+}
+
+func printFault(err error, info *debuginfo.Info) {
+	_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+
+	var fault *vm.Fault
+	if !errors.As(err, &fault) {
+		return
+	}
+
+	if location := describeLocation(info, fault.PC); location != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "\tat %s\n", location)
+	}
+	if len(fault.CallChain) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "call chain (innermost first):\n")
+		for _, callSite := range fault.CallChain {
+			if location := describeLocation(info, callSite); location != "" {
+				_, _ = fmt.Fprintf(os.Stderr, "\tcalled from 0x%08x (%s)\n", callSite, location)
+			} else {
+				_, _ = fmt.Fprintf(os.Stderr, "\tcalled from 0x%08x\n", callSite)
+			}
+		}
+	}
+}
+
+// printViolation reports a function that broke the calling convention
+func printViolation(v *callcheck.Violation, info *debuginfo.Info) {
+	_, _ = fmt.Fprintf(os.Stderr, "calling convention: %v\n", v)
+	if location := describeLocation(info, v.ReturnSite); location != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "\treturned at %s\n", location)
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "\treturned at 0x%08x\n", v.ReturnSite)
+	}
+	if location := describeLocation(info, v.CallSite); location != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "\tcalled from 0x%08x (%s)\n", v.CallSite, location)
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "\tcalled from 0x%08x\n", v.CallSite)
+	}
+}
+
+// describeLocation returns the source location and enclosing label of an
+// address, or an empty string without debug info
+func describeLocation(info *debuginfo.Info, address uint32) string {
+	if info == nil {
+		return ""
+	}
+
+	line, hasLine := info.LineFor(address)
+	symbol, hasSymbol := info.SymbolFor(address)
+	switch {
+	case hasLine && hasSymbol:
+		return fmt.Sprintf("%s in $%s", line, symbol.Name)
+	case hasLine:
+		return line.String()
+	case hasSymbol:
+		return fmt.Sprintf("$%s+%d", symbol.Name, address-symbol.Address)
+	default:
+		return ""
+	}
+}
+
+// debuggerError is a failure to communicate with the debugger, as opposed
+// to an error of the program that was being debugged
+type debuggerError struct {
+	Err error
+}
+
+func (d *debuggerError) Error() string {
+	return d.Err.Error()
+}
+
+func (d *debuggerError) Unwrap() error {
+	return d.Err
+}
+
+// serveDebugger waits for a GDB client to connect on address and lets it
+// control the program until it detaches. It returns the fault that stopped
+// the program, gdb.ErrKilled if the client killed it or a *debuggerError if
+// communicating with the client failed.
+func serveDebugger(sim *vm.VirtualMachine, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return &debuggerError{Err: err}
+	}
+	defer listener.Close()
+
+	_, _ = fmt.Fprintf(os.Stderr, "waiting for debugger on %s\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		return &debuggerError{Err: err}
+	}
+	defer conn.Close()
+
+	session := debug.NewSession(sim, *historyLimitFlag)
+	defer sim.DisableHistory()
+
+	err = gdb.NewServer(session).Serve(conn)
+	if errors.Is(err, gdb.ErrKilled) {
+		return err
+	} else if err != nil {
+		return &debuggerError{Err: err}
+	}
+	return session.Fault()
+}
+
+// loadProgram loads the executable named by the first argument and prepares
+// the heap, stack and program arguments
+func loadProgram(sim *vm.VirtualMachine, args []string) error {
+	// the program receives its own path as the first argument
+	programArgs := []string{args[0]}
+	if len(args) > 1 {
+		rest := args[1:]
+		if rest[0] == "--" {
+			rest = rest[1:]
+		}
+		programArgs = append(programArgs, rest...)
+	}
+
+	inputFile, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	exe, err := executable.Read(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	if *stackSizeFlag > vm.DefaultStackTop/2 {
+		return fmt.Errorf("invalid stack size %d: must be no larger than 0x%x", *stackSizeFlag, vm.DefaultStackTop/2)
+	}
+	return sim.LoadExecutable(exe, programArgs, envFlag, uint32(*stackSizeFlag))
+}
+
+// loadSnapshot restores the VM from a snapshot file
+func loadSnapshot(sim *vm.VirtualMachine, path string) error {
+	snapshotFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer snapshotFile.Close()
+
+	snapshot, err := vm.ReadSnapshot(bufio.NewReader(snapshotFile))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	err = sim.Restore(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	return nil
+}
+
+// saveSnapshot writes the state of the VM to a snapshot file
+func saveSnapshot(sim *vm.VirtualMachine, path string) error {
+	snapshot, err := sim.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	snapshotFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(snapshotFile)
+	if err := snapshot.MarshalTo(writer); err != nil {
+		_ = snapshotFile.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		_ = snapshotFile.Close()
+		return err
+	}
+	return snapshotFile.Close()
+}
+
+// replaySyscalls makes the VM replay the syscalls recorded in a file
+func replaySyscalls(sim *vm.VirtualMachine, path string) error {
+	logFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open syscall log: %w", err)
+	}
+	defer logFile.Close()
+
+	syscallLog, err := vm.ReadSyscallLog(bufio.NewReader(logFile))
+	if err != nil {
+		return fmt.Errorf("failed to read syscall log: %w", err)
+	}
+
+	sim.ReplaySyscalls(syscallLog)
+	return nil
+}
+
+// saveSyscallLog writes the recorded syscalls to a file
+func saveSyscallLog(syscallLog *vm.SyscallLog, path string) error {
+	logFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(logFile)
+	if err := syscallLog.MarshalTo(writer); err != nil {
+		_ = logFile.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		_ = logFile.Close()
+		return err
+	}
+	return logFile.Close()
+}
+
+// saveProfile writes the pprof profile of the run to a file
+func saveProfile(profiler *profile.Profiler, path string) error {
+	profileFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := profiler.WritePprof(profileFile); err != nil {
+		_ = profileFile.Close()
+		return err
+	}
+	return profileFile.Close()
+}
+
+// saveCoverage writes the coverage profile of the run to a file
+func saveCoverage(profile *coverage.Profile, path string) error {
+	coverageFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := profile.MarshalTo(coverageFile); err != nil {
+		_ = coverageFile.Close()
+		return err
+	}
+	return coverageFile.Close()
 }
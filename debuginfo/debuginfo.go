@@ -0,0 +1,89 @@
+// Package debuginfo maps addresses in a program to the assembly source they
+// were assembled from.
+package debuginfo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Line records that the word at Address was assembled from line Line of
+// File. Lines are numbered from 1.
+type Line struct {
+	Address uint32
+	File    string
+	Line    int
+}
+
+func (l Line) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Symbol records the address of a label
+type Symbol struct {
+	Name    string
+	Address uint32
+}
+
+// Info holds the line table and symbols of a program
+type Info struct {
+	// Lines is sorted by address
+	Lines []Line
+	// Symbols is sorted by address
+	Symbols []Symbol
+}
+
+// AddLine records the source line of the word at address
+func (i *Info) AddLine(address uint32, file string, line int) {
+	i.Lines = append(i.Lines, Line{Address: address, File: file, Line: line})
+}
+
+// AddSymbol records the address of a label
+func (i *Info) AddSymbol(name string, address uint32) {
+	i.Symbols = append(i.Symbols, Symbol{Name: name, Address: address})
+}
+
+// Sort orders the lines and symbols by address, which is required before
+// looking up addresses.
+func (i *Info) Sort() {
+	sort.SliceStable(i.Lines, func(a, b int) bool {
+		return i.Lines[a].Address < i.Lines[b].Address
+	})
+	sort.SliceStable(i.Symbols, func(a, b int) bool {
+		return i.Symbols[a].Address < i.Symbols[b].Address
+	})
+}
+
+// LineFor returns the source line of the word at address
+func (i *Info) LineFor(address uint32) (Line, bool) {
+	index := sort.Search(len(i.Lines), func(n int) bool {
+		return i.Lines[n].Address >= address
+	})
+	if index < len(i.Lines) && i.Lines[index].Address == address {
+		return i.Lines[index], true
+	}
+	return Line{}, false
+}
+
+// AddressesFor returns the addresses of the words assembled from a line of
+// file, in increasing order.
+func (i *Info) AddressesFor(file string, line int) []uint32 {
+	var addresses []uint32
+	for _, l := range i.Lines {
+		if l.File == file && l.Line == line {
+			addresses = append(addresses, l.Address)
+		}
+	}
+	return addresses
+}
+
+// SymbolFor returns the closest label at or before address
+func (i *Info) SymbolFor(address uint32) (Symbol, bool) {
+	index := sort.Search(len(i.Symbols), func(n int) bool {
+		return i.Symbols[n].Address > address
+	})
+	if index == 0 {
+		return Symbol{}, false
+	}
+	return i.Symbols[index-1], true
+}
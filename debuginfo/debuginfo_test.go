@@ -0,0 +1,41 @@
+package debuginfo
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInfo_MarshalRoundTrip(t *testing.T) {
+	info := new(Info)
+	info.AddLine(8, "std/strio.orange", 42)
+	info.AddLine(0, "main file.orange", 2)
+	info.AddLine(4, "main file.orange", 3)
+	info.AddSymbol("strLen", 8)
+	info.AddSymbol("main", 0)
+	info.Sort()
+
+	var buf bytes.Buffer
+	require.NoError(t, info.MarshalTo(&buf))
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, info, read)
+
+	line, ok := read.LineFor(8)
+	require.True(t, ok)
+	assert.Equal(t, "std/strio.orange:42", line.String())
+
+	_, ok = read.LineFor(12)
+	assert.False(t, ok)
+
+	assert.Equal(t, []uint32{4}, read.AddressesFor("main file.orange", 3))
+
+	symbol, ok := read.SymbolFor(16)
+	require.True(t, ok)
+	assert.Equal(t, "strLen", symbol.Name)
+	symbol, ok = read.SymbolFor(4)
+	require.True(t, ok)
+	assert.Equal(t, "main", symbol.Name)
+}
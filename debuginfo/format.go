@@ -0,0 +1,101 @@
+package debuginfo
+
+import (
+	"fmt"
+	"io"
+)
+
+// Marker starts the debug section of object files and executables
+const Marker = "debug"
+
+// MarshalTo writes the Info to the given io.Writer.
+//
+// The format is as follows:
+//
+// debug [# of files] [# of lines] [# of symbols]
+// - for each file, [quoted file name]
+// - for each line, [address] [file index] [line]
+// - for each symbol, [label name] [address]
+func (i *Info) MarshalTo(writer io.Writer) (err error) {
+	var files []string
+	fileIndex := make(map[string]int)
+	for _, l := range i.Lines {
+		if _, ok := fileIndex[l.File]; !ok {
+			fileIndex[l.File] = len(files)
+			files = append(files, l.File)
+		}
+	}
+
+	_, err = fmt.Fprintf(writer, "%s %d %d %d\n", Marker, len(files), len(i.Lines), len(i.Symbols))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		_, err = fmt.Fprintf(writer, "%q\n", file)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, l := range i.Lines {
+		_, err = fmt.Fprintf(writer, "%d %d %d\n", l.Address, fileIndex[l.File], l.Line)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, s := range i.Symbols {
+		_, err = fmt.Fprintf(writer, "%s %d\n", s.Name, s.Address)
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// Read reads an Info written by MarshalTo
+func Read(reader io.Reader) (*Info, error) {
+	var fileCount, lineCount, symbolCount int
+	_, err := fmt.Fscanf(reader, Marker+" %d %d %d\n", &fileCount, &lineCount, &symbolCount)
+	if err != nil {
+		return nil, fmt.Errorf("read debug info: %w", err)
+	}
+
+	files := make([]string, fileCount)
+	for n := range files {
+		_, err = fmt.Fscanf(reader, "%q\n", &files[n])
+		if err != nil {
+			return nil, fmt.Errorf("read debug info: %w", err)
+		}
+	}
+
+	info := &Info{
+		Lines:   make([]Line, lineCount),
+		Symbols: make([]Symbol, symbolCount),
+	}
+
+	for n := range info.Lines {
+		var file int
+		l := &info.Lines[n]
+		_, err = fmt.Fscanf(reader, "%d %d %d\n", &l.Address, &file, &l.Line)
+		if err != nil {
+			return nil, fmt.Errorf("read debug info: %w", err)
+		} else if file < 0 || file >= len(files) {
+			return nil, fmt.Errorf("read debug info: invalid file index %d", file)
+		}
+		l.File = files[file]
+	}
+
+	for n := range info.Symbols {
+		s := &info.Symbols[n]
+		_, err = fmt.Fscanf(reader, "%s %d\n", &s.Name, &s.Address)
+		if err != nil {
+			return nil, fmt.Errorf("read debug info: %w", err)
+		}
+	}
+
+	info.Sort()
+	return info, nil
+}
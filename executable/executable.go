@@ -0,0 +1,256 @@
+package executable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/memory"
+	"io"
+)
+
+const (
+	magic   = "orange-exe"
+	version = 1
+
+	// TextSection is the name of the section containing program code
+	TextSection = "text"
+)
+
+// Segment is a contiguous range of the program image that is loaded into
+// memory with a single set of permissions.
+type Segment struct {
+	Name    string
+	Address uint32
+	Perm    memory.Permission
+	Words   []arch.Instruction
+}
+
+// Size returns the size of the segment in bytes
+func (s *Segment) Size() int {
+	return len(s.Words) * 4
+}
+
+// File is an executable program image
+type File struct {
+	Segments []*Segment
+
+	// Debug is the optional debug info of the program
+	Debug *debuginfo.Info
+}
+
+// SectionPermission returns the permissions a section is loaded with. The
+// text section is read-execute and every other section is read-write.
+func SectionPermission(sectionName string) memory.Permission {
+	if sectionName == TextSection {
+		return memory.PermReadExecute
+	}
+	return memory.PermReadWrite
+}
+
+// AddSection appends a segment for the named section directly after the
+// previous segment. Empty sections are skipped.
+func (f *File) AddSection(name string, words []arch.Instruction) {
+	if len(words) == 0 {
+		return
+	}
+
+	address := uint32(0)
+	if len(f.Segments) > 0 {
+		last := f.Segments[len(f.Segments)-1]
+		address = last.Address + uint32(last.Size())
+	}
+
+	f.Segments = append(f.Segments, &Segment{
+		Name:    name,
+		Address: address,
+		Perm:    SectionPermission(name),
+		Words:   words,
+	})
+}
+
+// MarshalTo writes the File to the given io.Writer completely.
+//
+// The file format is as follows:
+//
+// orange-exe [version]
+// [# of segments]
+// - for each segment, [segment name] [address] [size] [permissions]
+// [raw segment data, in order]
+// [optional debug info, see debuginfo.Info.MarshalTo]
+func (f *File) MarshalTo(writer io.Writer) (err error) {
+	_, err = fmt.Fprintf(writer, "%s %d\n%d\n", magic, version, len(f.Segments))
+	if err != nil {
+		return
+	}
+
+	for _, seg := range f.Segments {
+		_, err = fmt.Fprintf(writer, "%s %d %d %s\n", seg.Name, seg.Address, seg.Size(), seg.Perm)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, seg := range f.Segments {
+		for _, w := range seg.Words {
+			err = binary.Write(writer, arch.ByteOrder, w)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if f.Debug != nil {
+		return f.Debug.MarshalTo(writer)
+	}
+	return nil
+}
+
+// Read reads an executable from the given io.Reader.
+//
+// Raw program images without a header are still accepted, in which case
+// the whole image is loaded at address zero with every permission. The
+// reader is consumed to its end, and the segment sizes of the header are
+// checked against the data that follows it.
+func Read(reader io.Reader) (*File, error) {
+	buffered := bufio.NewReader(reader)
+	peeked, _ := buffered.Peek(len(magic))
+	if !bytes.Equal(peeked, []byte(magic)) {
+		return readRawImage(buffered)
+	}
+
+	var fileVersion, segmentCount int
+	_, err := fmt.Fscanf(buffered, magic+" %d\n%d\n", &fileVersion, &segmentCount)
+	if err != nil {
+		return nil, err
+	} else if fileVersion != version {
+		return nil, fmt.Errorf("unsupported executable version %d", fileVersion)
+	}
+
+	if segmentCount < 0 {
+		return nil, fmt.Errorf("invalid segment count %d", segmentCount)
+	}
+
+	f := &File{}
+	var sizes []int
+	for i := 0; i < segmentCount; i++ {
+		seg := new(Segment)
+		var size int
+		var perm string
+		_, err = fmt.Fscanf(buffered, "%s %d %d %s\n", &seg.Name, &seg.Address, &size, &perm)
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 || size%4 != 0 {
+			return nil, fmt.Errorf("segment %q: invalid size %d", seg.Name, size)
+		}
+		if uint64(seg.Address)+uint64(size) > 1<<32 {
+			return nil, fmt.Errorf("segment %q: %d bytes at 0x%08x exceed the address space", seg.Name, size, seg.Address)
+		}
+
+		seg.Perm, err = parsePermission(perm)
+		if err != nil {
+			return nil, err
+		}
+
+		f.Segments = append(f.Segments, seg)
+		sizes = append(sizes, size)
+	}
+
+	// the sizes are checked before any words are allocated, so that
+	// malformed headers cannot exhaust memory
+	data, err := io.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	for i, seg := range f.Segments {
+		if sizes[i] > len(data)-offset {
+			return nil, fmt.Errorf("segment %q: %d bytes declared but only %d remain", seg.Name, sizes[i], len(data)-offset)
+		}
+		seg.Words = make([]arch.Instruction, sizes[i]/4)
+		err = binary.Read(bytes.NewReader(data[offset:offset+sizes[i]]), arch.ByteOrder, seg.Words)
+		if err != nil {
+			return nil, err
+		}
+		offset += sizes[i]
+	}
+
+	rest := bufio.NewReader(bytes.NewReader(data[offset:]))
+	peeked, _ = rest.Peek(len(debuginfo.Marker))
+	if bytes.Equal(peeked, []byte(debuginfo.Marker)) {
+		f.Debug, err = debuginfo.Read(rest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func readRawImage(reader io.Reader) (*File, error) {
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]arch.Instruction, len(raw)/4)
+	err = binary.Read(bytes.NewReader(raw), arch.ByteOrder, words)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Segments: []*Segment{{
+			Name:    "image",
+			Address: 0,
+			Perm:    memory.PermAll,
+			Words:   words,
+		}},
+	}, nil
+}
+
+func parsePermission(s string) (memory.Permission, error) {
+	if len(s) != 3 {
+		return 0, fmt.Errorf("invalid segment permissions %q", s)
+	}
+
+	var perm memory.Permission
+	for i, flag := range []memory.Permission{memory.PermRead, memory.PermWrite, memory.PermExecute} {
+		if s[i] == "rwx"[i] {
+			perm |= flag
+		} else if s[i] != '-' {
+			return 0, fmt.Errorf("invalid segment permissions %q", s)
+		}
+	}
+	return perm, nil
+}
+
+// Load maps every segment of the executable into memory with its permissions
+func (f *File) Load(mem *memory.Memory) error {
+	for _, seg := range f.Segments {
+		buf := new(bytes.Buffer)
+		if err := binary.Write(buf, arch.ByteOrder, seg.Words); err != nil {
+			return err
+		}
+
+		if _, err := mem.LoadFromReader(seg.Address, buf, seg.Perm); err != nil {
+			return fmt.Errorf("load segment %q: %w", seg.Name, err)
+		}
+	}
+	return nil
+}
+
+// End returns the first address after the highest segment
+func (f *File) End() uint32 {
+	end := uint32(0)
+	for _, seg := range f.Segments {
+		if segEnd := seg.Address + uint32(seg.Size()); segEnd > end {
+			end = segEnd
+		}
+	}
+	return end
+}
@@ -0,0 +1,72 @@
+package executable
+
+import (
+	"bytes"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestFile_MarshalRoundTrip(t *testing.T) {
+	f := new(File)
+	f.AddSection(TextSection, []arch.Instruction{0x11111111, 0x22222222})
+	f.AddSection("empty", nil)
+	f.AddSection("data", []arch.Instruction{0x33333333})
+	f.Debug = new(debuginfo.Info)
+	f.Debug.AddLine(4, "main.orange", 2)
+	f.Debug.AddSymbol("main", 0)
+
+	require.Len(t, f.Segments, 2)
+	assert.Equal(t, uint32(8), f.Segments[1].Address)
+	assert.Equal(t, memory.PermReadExecute, f.Segments[0].Perm)
+	assert.Equal(t, memory.PermReadWrite, f.Segments[1].Perm)
+	assert.Equal(t, uint32(12), f.End())
+
+	var buf bytes.Buffer
+	require.NoError(t, f.MarshalTo(&buf))
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, f, read)
+
+	mem := memory.New()
+	require.NoError(t, read.Load(mem))
+	word, err := mem.Fetch(4)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x22222222), word)
+	_, err = mem.Fetch(8)
+	assert.Error(t, err, "data is not executable")
+}
+
+func TestRead_RawImage(t *testing.T) {
+	read, err := Read(bytes.NewReader([]byte{1, 0, 0, 0, 2, 0, 0, 0}))
+	require.NoError(t, err)
+	require.Len(t, read.Segments, 1)
+	assert.Equal(t, []arch.Instruction{1, 2}, read.Segments[0].Words)
+	assert.Equal(t, memory.PermAll, read.Segments[0].Perm)
+}
+
+func TestRead_Malformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"negative count", "orange-exe 1\n-1\n", "invalid segment count -1"},
+		{"negative size", "orange-exe 1\n1\ntext 0 -4 r-x\n", `segment "text": invalid size -4`},
+		{"unaligned size", "orange-exe 1\n1\ntext 0 6 r-x\n", `segment "text": invalid size 6`},
+		{"huge size", "orange-exe 1\n1\ntext 8 4294967292 r-x\n", `segment "text": 4294967292 bytes at 0x00000008 exceed the address space`},
+		{"truncated", "orange-exe 1\n1\ntext 0 8 r-x\nabcd", `segment "text": 8 bytes declared but only 4 remain`},
+		{"permissions", "orange-exe 1\n1\ntext 0 0 rwz\n", `invalid segment permissions "rwz"`},
+		{"version", "orange-exe 2\n0\n", "unsupported executable version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.input))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -5,11 +5,11 @@ go 1.17
 require (
 	github.com/stretchr/testify v1.7.0
 	github.com/timtadh/lexmachine v0.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/timtadh/data-structures v0.5.3 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
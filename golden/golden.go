@@ -0,0 +1,249 @@
+// Package golden runs orange programs as end-to-end tests. A source file is
+// a test case if its comments contain directives that describe how to run it
+// and what it must produce:
+//
+//	; test: link ../std/strio.orange
+//	; test: args first second
+//	; test: env USER=orange
+//	; test: stdin "input\n"
+//	; test: stdout "expected output\n"
+//	; test: exit 0
+//	; test: register r1 42
+//	; test: max-steps 100000
+//
+// link names further source files linked with the test, relative to it.
+// stdin and stdout take Go string literals, and repeated directives append to
+// each other. Without a stdout directive the output is not checked, and
+// without an exit directive the program must exit with status 0.
+package golden
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/asm/lexer"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	directivePrefix = "test:"
+
+	// DefaultMaxSteps is the number of instructions a test may execute
+	// unless changed by a max-steps directive
+	DefaultMaxSteps = 10_000_000
+)
+
+// RegisterCheck is the expected value of a register after the program halted
+type RegisterCheck struct {
+	Register arch.RegisterValue
+	// Name is the register as written in the directive, like rsp
+	Name  string
+	Value uint64
+}
+
+// Case is a test read from the directives of a source file
+type Case struct {
+	// Path is the path of the source file
+	Path string
+	// Links holds the paths of the source files linked with it
+	Links []string
+
+	Args  []string
+	Env   []string
+	Stdin string
+
+	// Stdout is only checked if HasStdout is set
+	Stdout    string
+	HasStdout bool
+	ExitCode  int
+	Registers []RegisterCheck
+	MaxSteps  uint64
+}
+
+// Name returns the path of the case relative to the current directory, if
+// possible
+func (c *Case) Name() string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, c.Path); err == nil {
+			return rel
+		}
+	}
+	return c.Path
+}
+
+// ParseCase reads the directives of a source file. It returns nil if the file
+// has no directives.
+func ParseCase(path string, source []byte) (*Case, error) {
+	c := &Case{Path: path, MaxSteps: DefaultMaxSteps}
+	found := false
+
+	for i, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, ";") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, ";"))
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		found = true
+
+		directive := strings.TrimSpace(strings.TrimPrefix(line, directivePrefix))
+		if err := c.parseDirective(directive); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// parseDirective applies a directive without its prefix to the case
+func (c *Case) parseDirective(directive string) error {
+	fields := strings.Fields(directive)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty test directive")
+	}
+	name := fields[0]
+	rest := strings.TrimSpace(strings.TrimPrefix(directive, name))
+
+	switch name {
+	case "link":
+		if len(fields) < 2 {
+			return fmt.Errorf("link directive needs a file")
+		}
+		for _, link := range fields[1:] {
+			c.Links = append(c.Links, filepath.Join(filepath.Dir(c.Path), link))
+		}
+	case "args":
+		c.Args = append(c.Args, fields[1:]...)
+	case "env":
+		for _, env := range fields[1:] {
+			if !strings.Contains(env, "=") {
+				return fmt.Errorf("environment variable %q must be of the form NAME=value", env)
+			}
+			c.Env = append(c.Env, env)
+		}
+	case "stdin", "stdout":
+		text, err := strconv.Unquote(rest)
+		if err != nil {
+			return fmt.Errorf("%s directive needs a quoted string: %w", name, err)
+		}
+		if name == "stdin" {
+			c.Stdin += text
+		} else {
+			c.Stdout += text
+			c.HasStdout = true
+		}
+	case "exit":
+		code, err := strconv.Atoi(rest)
+		if err != nil {
+			return fmt.Errorf("invalid exit status %q", rest)
+		}
+		c.ExitCode = code
+	case "register":
+		if len(fields) != 3 {
+			return fmt.Errorf("register directive needs a register and a value")
+		}
+		check, err := parseRegisterCheck(fields[1], fields[2])
+		if err != nil {
+			return err
+		}
+		c.Registers = append(c.Registers, check)
+	case "max-steps":
+		steps, err := strconv.ParseUint(rest, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid step count %q", rest)
+		}
+		c.MaxSteps = steps
+	default:
+		return fmt.Errorf("unknown test directive %q", name)
+	}
+	return nil
+}
+
+// parseRegisterCheck parses a register name and a value, which may be
+// negative or hexadecimal
+func parseRegisterCheck(name string, value string) (RegisterCheck, error) {
+	register, err := asm.ParseRegister(&lexer.Token{Kind: lexer.REGISTER, Value: name})
+	if err != nil || !strings.HasPrefix(name, "r") || register > arch.ReturnRegister {
+		return RegisterCheck{}, fmt.Errorf("invalid register %q", name)
+	}
+
+	check := RegisterCheck{Register: register, Name: name}
+	if strings.HasPrefix(value, "-") {
+		signed, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return RegisterCheck{}, fmt.Errorf("invalid register value %q", value)
+		}
+		check.Value = uint64(signed)
+	} else {
+		check.Value, err = strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return RegisterCheck{}, fmt.Errorf("invalid register value %q", value)
+		}
+	}
+	return check, nil
+}
+
+// Discover returns the cases of the .orange files named by paths, searching
+// directories recursively, sorted by path. Files without directives are
+// skipped.
+func Discover(paths ...string) ([]*Case, error) {
+	var cases []*Case
+	addFile := func(path string) error {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		c, err := ParseCase(absolute, source)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			cases = append(cases, c)
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := addFile(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != ".orange" {
+				return nil
+			}
+			return addFile(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(cases, func(a, b int) bool {
+		return cases[a].Path < cases[b].Path
+	})
+	return cases, nil
+}
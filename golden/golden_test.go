@@ -0,0 +1,80 @@
+package golden
+
+import (
+	"context"
+	"github.com/dnsge/orange/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrograms(t *testing.T) {
+	Test(t, "../programs")
+}
+
+func TestParseCase(t *testing.T) {
+	c, err := ParseCase("/src/main.orange", []byte(`; test: link lib.orange
+; test: args a b
+; test: stdin "x\n"
+; test: stdout "one\n"
+; test: stdout "two\n"
+	;test: exit 3
+; test: register rsp 0x10
+; test: register r1 -1
+	HALT ; test: exit 4
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Case{
+		Path:      "/src/main.orange",
+		Links:     []string{"/src/lib.orange"},
+		Args:      []string{"a", "b"},
+		Stdin:     "x\n",
+		Stdout:    "one\ntwo\n",
+		HasStdout: true,
+		ExitCode:  3,
+		Registers: []RegisterCheck{
+			{Register: arch.StackRegister, Name: "rsp", Value: 0x10},
+			{Register: 1, Name: "r1", Value: ^uint64(0)},
+		},
+		MaxSteps: DefaultMaxSteps,
+	}, c)
+
+	c, err = ParseCase("plain.orange", []byte("\tHALT ; no directives\n"))
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	_, err = ParseCase("bad.orange", []byte("HALT\n; test: stdout unquoted\n"))
+	assert.EqualError(t, err, `bad.orange:2: stdout directive needs a quoted string: invalid syntax`)
+	_, err = ParseCase("bad.orange", []byte("; test: register r16 1\n"))
+	assert.EqualError(t, err, `bad.orange:1: invalid register "r16"`)
+}
+
+func TestCase_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exit.orange")
+	require.NoError(t, os.WriteFile(path, []byte(`; test: exit 0
+	MOVZ r1, #7
+	MOVZ r9, #6
+	SYSCALL
+`), 0644))
+
+	cases, err := Discover(path)
+	require.NoError(t, err)
+	require.Len(t, cases, 1)
+
+	r := cases[0].Run(context.Background())
+	assert.NoError(t, r.Err)
+	assert.Equal(t, 7, r.ExitCode)
+	assert.Equal(t, []string{"exit status 7, want 0"}, r.Failures)
+	assert.False(t, r.Passed())
+}
+
+func TestDiff(t *testing.T) {
+	assert.Equal(t, `  "a\n"
+- "b\n"
++ "B\n"
+  "c"`, Diff("a\nb\nc", "a\nB\nc"))
+	assert.Equal(t, `- "done\n"
++ "done"`, Diff("done\n", "done"))
+}
@@ -0,0 +1,219 @@
+package golden
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/linker"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"io"
+	"os"
+	"strings"
+)
+
+// Result is the outcome of running a case
+type Result struct {
+	Case *Case
+
+	Stdout   string
+	Stderr   string
+	ExitCode int
+
+	// Err is set if the case could not be built or did not halt
+	Err error
+	// Failures describes each expectation the run did not meet
+	Failures []string
+}
+
+// Passed returns whether the case was built and ran as expected
+func (r *Result) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// assemble assembles the source file at path for the target
+func assemble(path string, target asm.Target) ([]byte, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	var out bytes.Buffer
+	err = asm.Assemble(source, &out, &asm.Options{
+		Target:   target,
+		Debug:    true,
+		FileName: path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble %s: %w", path, err)
+	}
+	return out.Bytes(), nil
+}
+
+// Build assembles the case and links it with the files it names, returning
+// the executable
+func (c *Case) Build() (*executable.File, error) {
+	var image []byte
+	if len(c.Links) == 0 {
+		var err error
+		image, err = assemble(c.Path, asm.TargetExecutable)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var objects []io.Reader
+		for _, path := range append([]string{c.Path}, c.Links...) {
+			object, err := assemble(path, asm.TargetObjectFile)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, bytes.NewReader(object))
+		}
+
+		var out bytes.Buffer
+		if err := linker.Link(objects, &out); err != nil {
+			return nil, fmt.Errorf("failed to link: %w", err)
+		}
+		image = out.Bytes()
+	}
+
+	exe, err := executable.Read(bytes.NewReader(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read executable: %w", err)
+	}
+	return exe, nil
+}
+
+// load prepares a VM to run the executable like orangevm would
+func (c *Case) load(exe *executable.File, stdout io.Writer, stderr io.Writer) (*vm.VirtualMachine, error) {
+	sim := vm.NewVirtualMachine(memory.New(), true)
+	sim.SetStandardStreams(strings.NewReader(c.Stdin), stdout, stderr)
+
+	args := append([]string{c.Path}, c.Args...)
+	if err := sim.LoadExecutable(exe, args, c.Env, vm.DefaultStackSize); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// Run builds and runs the case, comparing the run against its expectations
+func (c *Case) Run(ctx context.Context) *Result {
+	r := &Result{Case: c}
+
+	exe, err := c.Build()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	var stdout, stderr bytes.Buffer
+	sim, err := c.load(exe, &stdout, &stderr)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	err = sim.Run(ctx, vm.RunOptions{MaxSteps: c.MaxSteps})
+	r.Stdout = stdout.String()
+	r.Stderr = stderr.String()
+	if err != nil {
+		r.Err = fmt.Errorf("program did not halt: %w", err)
+		return r
+	}
+
+	r.ExitCode = sim.ExitCode()
+	if r.ExitCode != c.ExitCode {
+		r.Failures = append(r.Failures, fmt.Sprintf("exit status %d, want %d", r.ExitCode, c.ExitCode))
+	}
+	if c.HasStdout && r.Stdout != c.Stdout {
+		r.Failures = append(r.Failures, "stdout differs:\n"+Diff(c.Stdout, r.Stdout))
+	}
+	for _, check := range c.Registers {
+		if value := sim.Register(check.Register); value != check.Value {
+			r.Failures = append(r.Failures, fmt.Sprintf("register %s = %d (0x%x), want %d (0x%x)",
+				check.Name, value, value, check.Value, check.Value))
+		}
+	}
+	return r
+}
+
+// Report writes the outcome of the run like "PASS name" or "FAIL name",
+// followed by why it failed
+func (r *Result) Report(w io.Writer) {
+	if r.Passed() {
+		_, _ = fmt.Fprintf(w, "PASS %s\n", r.Case.Name())
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "FAIL %s\n", r.Case.Name())
+	if r.Err != nil {
+		_, _ = fmt.Fprintf(w, "    %v\n", r.Err)
+	}
+	for _, failure := range r.Failures {
+		_, _ = fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(failure, "\n", "\n    "))
+	}
+	if r.Stderr != "" {
+		_, _ = fmt.Fprintf(w, "    stderr:\n    %s\n", strings.ReplaceAll(strings.TrimSuffix(r.Stderr, "\n"), "\n", "\n    "))
+	}
+}
+
+// Diff returns a line diff of want and got, with removed lines prefixed by
+// "-" and added lines by "+". Lines are quoted so that whitespace and a
+// missing final newline are visible.
+func Diff(want string, got string) string {
+	a := splitLines(want)
+	b := splitLines(got)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			_, _ = fmt.Fprintf(&out, "  %q\n", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			_, _ = fmt.Fprintf(&out, "- %q\n", a[i])
+			i++
+		default:
+			_, _ = fmt.Fprintf(&out, "+ %q\n", b[j])
+			j++
+		}
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// splitLines splits s after each newline, keeping the newlines
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		end := strings.IndexByte(s, '\n') + 1
+		if end == 0 {
+			end = len(s)
+		}
+		lines = append(lines, s[:end])
+		s = s[end:]
+	}
+	return lines
+}
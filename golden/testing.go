@@ -0,0 +1,34 @@
+package golden
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Test discovers the cases of paths and runs each as a subtest of t, so that
+// the programs of a repository are tested by go test
+func Test(t *testing.T, paths ...string) {
+	t.Helper()
+
+	cases, err := Discover(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no test cases in %s", strings.Join(paths, ", "))
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name(), func(t *testing.T) {
+			r := c.Run(context.Background())
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			for _, failure := range r.Failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package framing reads and writes JSON messages framed by a Content-Length
+// header, as used by the Debug Adapter Protocol and the Language Server
+// Protocol.
+package framing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxMessageSize is the largest message accepted from a client
+const maxMessageSize = 64 << 20
+
+var ErrInvalidHeader = fmt.Errorf("invalid message header")
+
+// Reader reads framed messages
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the content of the next message. At the end of the input,
+// io.EOF is returned.
+func (r *Reader) Read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.r.ReadString('\n')
+		if err == io.EOF && line == "" && length == -1 {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			// end of the header
+			break
+		}
+
+		name, value, found := cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("header line %q: %w", line, ErrInvalidHeader)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 || length > maxMessageSize {
+				return nil, fmt.Errorf("content length %q: %w", value, ErrInvalidHeader)
+			}
+		}
+	}
+
+	if length == -1 {
+		return nil, fmt.Errorf("missing Content-Length: %w", ErrInvalidHeader)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.r, content); err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+	return content, nil
+}
+
+// cut splits s around the first instance of sep
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Writer writes framed messages. It is safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write encodes message as JSON and writes it as a single message
+func (w *Writer) Write(message interface{}) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w.w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = w.w.Write(content)
+	return err
+}
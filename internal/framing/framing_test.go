@@ -0,0 +1,45 @@
+package framing
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Write(map[string]int{"seq": 1}))
+	require.NoError(t, w.Write("second"))
+	assert.Equal(t, "Content-Length: 9\r\n\r\n{\"seq\":1}Content-Length: 8\r\n\r\n\"second\"", buf.String())
+
+	r := NewReader(&buf)
+	content, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, `{"seq":1}`, string(content))
+
+	content, err = r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, `"second"`, string(content))
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRead_Headers(t *testing.T) {
+	r := NewReader(strings.NewReader("content-length: 2\r\nContent-Type: application/json\r\n\r\n{}"))
+	content, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+
+	r = NewReader(strings.NewReader("Content-Type: application/json\r\n\r\n{}"))
+	_, err = r.Read()
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	r = NewReader(strings.NewReader("Content-Length: 10\r\n\r\n{}"))
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,11 @@
+// Package orange holds documentation shared by the Orange tools.
+package orange
+
+import (
+	_ "embed"
+)
+
+// ISA is the contents of ISA.md, which documents the instruction set
+//
+//go:embed ISA.md
+var ISA string
@@ -1,15 +1,19 @@
 package linker
 
 import (
-	"encoding/binary"
 	"fmt"
 	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/executable"
 	"io"
 )
 
 type linkContext struct {
 	Symbols      map[string]*InputObjectFile
 	Instructions []arch.Instruction
+
+	sections     map[string][]*AssembledSection
+	sectionOrder []string
 }
 
 func Link(inputFiles []io.Reader, outputFile io.Writer) error {
@@ -33,6 +37,8 @@ func Link(inputFiles []io.Reader, outputFile io.Writer) error {
 	linkCtx := &linkContext{
 		Symbols:      collectedSymbols,
 		Instructions: instructions,
+		sections:     collectedSections,
+		sectionOrder: sectionOrder,
 	}
 
 	err = linkCtx.relocateAll(objectFiles)
@@ -40,10 +46,53 @@ func Link(inputFiles []io.Reader, outputFile io.Writer) error {
 		return err
 	}
 
-	err = linkCtx.writeInstructions(outputFile)
+	debug, err := collectDebugInfo(objectFiles)
+	if err != nil {
+		return err
+	}
+
+	err = linkCtx.writeExecutable(debug, outputFile)
 	return err
 }
 
+// collectDebugInfo relocates the line tables and symbols of the object files
+// into debug info for the executable. If no object file has a debug section,
+// nil is returned.
+func collectDebugInfo(objectFiles []*InputObjectFile) (*debuginfo.Info, error) {
+	hasDebug := false
+	for _, objFile := range objectFiles {
+		hasDebug = hasDebug || objFile.SourceFile != ""
+	}
+	if !hasDebug {
+		return nil, nil
+	}
+
+	info := new(debuginfo.Info)
+	for _, objFile := range objectFiles {
+		for _, entry := range objFile.LineTable {
+			address, err := objFile.GetSectionOffsetAbsoluteAddress(entry.SectionName, entry.SectionOffset)
+			if err != nil {
+				return nil, err
+			}
+			info.AddLine(uint32(address), objFile.SourceFile, entry.Line)
+		}
+
+		for _, symbol := range objFile.SymbolTable {
+			if !symbol.Resolved {
+				continue
+			}
+			address, err := objFile.GetSymbolAbsoluteAddress(symbol.LabelName)
+			if err != nil {
+				return nil, err
+			}
+			info.AddSymbol(symbol.LabelName, uint32(address))
+		}
+	}
+
+	info.Sort()
+	return info, nil
+}
+
 // collectSymbolTableEntries groups all the symbols from the input object
 // files' symbol tables. Unresolved symbols (e.g. requested by a file but
 // never defined) or duplicate symbols (e.g. two matching labels in different
@@ -153,12 +202,20 @@ func (l *linkContext) relocateAll(objectFiles []*InputObjectFile) error {
 	return nil
 }
 
-// writeInstructions writes each instruction to the output writer
-func (l *linkContext) writeInstructions(writer io.Writer) error {
-	for i := range l.Instructions {
-		if err := binary.Write(writer, arch.ByteOrder, l.Instructions[i]); err != nil {
-			return err
+// writeExecutable writes the linked instructions to the output writer as an
+// executable, with one segment per section name and optional debug info.
+func (l *linkContext) writeExecutable(debug *debuginfo.Info, writer io.Writer) error {
+	exe := &executable.File{Debug: debug}
+	address := 0
+	for _, sectionName := range l.sectionOrder {
+		size := 0
+		for _, section := range l.sections[sectionName] {
+			size += section.Size
 		}
+
+		exe.AddSection(sectionName, l.Instructions[address/4:(address+size)/4])
+		address += size
 	}
-	return nil
+
+	return exe.MarshalTo(writer)
 }
@@ -0,0 +1,28 @@
+package objfile
+
+import (
+	"fmt"
+	"io"
+)
+
+// LineTableEntry records the source line of the word at an offset within a
+// section, which the linker relocates into the executable's debug info.
+type LineTableEntry struct {
+	SectionName   string
+	SectionOffset int
+	Line          int
+}
+
+func (l *LineTableEntry) String() string {
+	return fmt.Sprintf("[%s : offset=%d, line=%d]", l.SectionName, l.SectionOffset, l.Line)
+}
+
+func (l *LineTableEntry) MarshalTo(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "%s %d %d\n", l.SectionName, l.SectionOffset, l.Line)
+	return err
+}
+
+func (l *LineTableEntry) UnmarshalFrom(reader io.Reader) error {
+	_, err := fmt.Fscanf(reader, "%s %d %d\n", &l.SectionName, &l.SectionOffset, &l.Line)
+	return err
+}
@@ -1,9 +1,12 @@
 package linker
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
 	"github.com/dnsge/orange/linker/objfile"
 	"io"
 )
@@ -21,9 +24,15 @@ type InputObjectFile struct {
 	Sections        []*AssembledSection
 	SymbolTable     []*objfile.SymbolTableEntry
 	RelocationTable []*objfile.RelocationTableEntry
+
+	// SourceFile and LineTable are only set if the object file has a debug
+	// section
+	SourceFile string
+	LineTable  []*objfile.LineTableEntry
 }
 
-func readObjectFile(inputFile io.Reader) (*InputObjectFile, error) {
+func readObjectFile(reader io.Reader) (*InputObjectFile, error) {
+	inputFile := bufio.NewReader(reader)
 	of := &InputObjectFile{
 		Sections:        nil,
 		SymbolTable:     nil,
@@ -83,9 +92,36 @@ func readObjectFile(inputFile io.Reader) (*InputObjectFile, error) {
 		}
 	}
 
+	peeked, _ := inputFile.Peek(len(debuginfo.Marker))
+	if bytes.Equal(peeked, []byte(debuginfo.Marker)) {
+		err = of.readDebugSection(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("read debug section: %w", err)
+		}
+	}
+
 	return of, nil
 }
 
+func (i *InputObjectFile) readDebugSection(inputFile io.Reader) error {
+	var lineCount int
+	_, err := fmt.Fscanf(inputFile, debuginfo.Marker+" %q %d\n", &i.SourceFile, &lineCount)
+	if err != nil {
+		return err
+	}
+
+	i.LineTable = make([]*objfile.LineTableEntry, lineCount)
+	for n := range i.LineTable {
+		entry := new(objfile.LineTableEntry)
+		err = entry.UnmarshalFrom(inputFile)
+		if err != nil {
+			return err
+		}
+		i.LineTable[n] = entry
+	}
+	return nil
+}
+
 func (i *InputObjectFile) GetSymbolAbsoluteAddress(symbolName string) (int, error) {
 	entry, ok := i.getSymbolEntryByName(symbolName)
 	if !ok {
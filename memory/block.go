@@ -9,13 +9,15 @@ import (
 type block struct {
 	startAddress uint32
 	endAddress   uint32
+	perm         Permission
 	data         []byte
 }
 
-func allocateBlock(startAddress uint32, size uint32) *block {
+func allocateBlock(startAddress uint32, size uint32, perm Permission) *block {
 	return &block{
 		startAddress: startAddress,
 		endAddress:   startAddress + size,
+		perm:         perm,
 		data:         make([]byte, size),
 	}
 }
@@ -24,6 +26,17 @@ func (b *block) Contains(address uint32) bool {
 	return address >= b.startAddress && address < b.endAddress
 }
 
+// ContainsRange returns whether [address, address+bytes) lies entirely within the block
+func (b *block) ContainsRange(address uint32, bytes uint32) bool {
+	return b.Contains(address) && uint64(address)+uint64(bytes) <= uint64(b.endAddress)
+}
+
+// Overlaps returns whether the block shares any address with [startAddress, startAddress+size)
+func (b *block) Overlaps(startAddress uint32, size uint32) bool {
+	end := uint64(startAddress) + uint64(size)
+	return uint64(startAddress) < uint64(b.endAddress) && end > uint64(b.startAddress)
+}
+
 func (b *block) Read(address uint32, size uint32) uint64 {
 	dataStart := address - b.startAddress
 	switch size {
@@ -2,38 +2,249 @@ package memory
 
 import "fmt"
 
+// Addressable describes a memory space that can be accessed by the VM.
+//
+// Data accesses go through Read and Write while instruction fetches go
+// through Fetch, allowing implementations to enforce separate permissions.
 type Addressable interface {
-	Read(address uint32, size uint32) uint64
-	Write(address uint32, size uint32, data uint64)
+	Read(address uint32, size uint32) (uint64, error)
+	Write(address uint32, size uint32, data uint64) error
+	Fetch(address uint32) (uint32, error)
+}
+
+var (
+	ErrOverlappingRegion = fmt.Errorf("region overlaps existing region")
+)
+
+// AccessError describes a memory access that was not permitted, either
+// because no region was mapped at the address or because the region's
+// permissions did not allow the access.
+type AccessError struct {
+	Address uint32
+	Kind    AccessKind
+	Mapped  bool
+	Perm    Permission
+}
+
+func (a *AccessError) Error() string {
+	if !a.Mapped {
+		return fmt.Sprintf("invalid %s at unmapped address 0x%08x", a.Kind, a.Address)
+	}
+	return fmt.Sprintf("invalid %s at address 0x%08x (region is %s)", a.Kind, a.Address, a.Perm)
 }
 
 type Memory struct {
 	Blocks []*block
+
+	// reserved describes address ranges that must stay unmapped, like
+	// stack guard regions
+	reserved []*block
 }
 
 func New() *Memory {
 	return &Memory{}
 }
 
-func (m *Memory) Alloc(startAddress uint32, size uint32) {
-	m.Blocks = append(m.Blocks, allocateBlock(startAddress, size))
+// Alloc maps a new zeroed region of size bytes at startAddress with the
+// given permissions. The region must not overlap any existing region.
+func (m *Memory) Alloc(startAddress uint32, size uint32, perm Permission) error {
+	if err := m.checkOverlap(startAddress, size); err != nil {
+		return err
+	}
+	m.Blocks = append(m.Blocks, allocateBlock(startAddress, size, perm))
+	return nil
 }
 
-func (m *Memory) Read(address uint32, size uint32) uint64 {
+// Reserve marks [startAddress, startAddress+size) as permanently unmapped.
+// Accesses within the range fault and no region may later be mapped over it.
+func (m *Memory) Reserve(startAddress uint32, size uint32) error {
+	if err := m.checkOverlap(startAddress, size); err != nil {
+		return err
+	}
+	m.reserved = append(m.reserved, &block{
+		startAddress: startAddress,
+		endAddress:   startAddress + size,
+		perm:         PermNone,
+	})
+	return nil
+}
+
+func (m *Memory) checkOverlap(startAddress uint32, size uint32) error {
+	if m.firstOverlapping(startAddress, size) != nil {
+		return fmt.Errorf("map 0x%08x-0x%08x: %w", startAddress, uint64(startAddress)+uint64(size), ErrOverlappingRegion)
+	}
+	return nil
+}
+
+// access returns the block that allows an access of kind for the bytes
+// in [address, address+bytes).
+func (m *Memory) access(address uint32, bytes uint32, kind AccessKind) (*block, error) {
 	for _, b := range m.Blocks {
-		if b.Contains(address) {
-			return b.Read(address, size)
+		if !b.Contains(address) {
+			continue
 		}
+
+		if !b.perm.Allows(kind) {
+			return nil, &AccessError{
+				Address: address,
+				Kind:    kind,
+				Mapped:  true,
+				Perm:    b.perm,
+			}
+		} else if !b.ContainsRange(address, bytes) {
+			// access runs off the end of the region
+			return nil, &AccessError{
+				Address: b.endAddress,
+				Kind:    kind,
+				Mapped:  false,
+			}
+		}
+		return b, nil
+	}
+	return nil, &AccessError{
+		Address: address,
+		Kind:    kind,
+		Mapped:  false,
+	}
+}
+
+func (m *Memory) Read(address uint32, size uint32) (uint64, error) {
+	b, err := m.access(address, size/8, AccessRead)
+	if err != nil {
+		return 0, err
+	}
+	return b.Read(address, size), nil
+}
+
+func (m *Memory) Write(address uint32, size uint32, data uint64) error {
+	b, err := m.access(address, size/8, AccessWrite)
+	if err != nil {
+		return err
+	}
+	b.Write(address, size, data)
+	return nil
+}
+
+// Fetch reads the instruction word at address, requiring execute permission
+func (m *Memory) Fetch(address uint32) (uint32, error) {
+	b, err := m.access(address, 4, AccessFetch)
+	if err != nil {
+		return 0, err
 	}
-	panic(fmt.Sprintf("invalid read at address 0x%08x", address))
+	return uint32(b.Read(address, 32)), nil
 }
 
-func (m *Memory) Write(address uint32, size uint32, data uint64) {
+// Peek reads memory like Read but ignores the permissions of the region,
+// so that debuggers can inspect any mapped address.
+func (m *Memory) Peek(address uint32, size uint32) (uint64, error) {
 	for _, b := range m.Blocks {
-		if b.Contains(address) {
-			b.Write(address, size, data)
-			return
+		if b.ContainsRange(address, size/8) {
+			return b.Read(address, size), nil
+		}
+	}
+	return 0, &AccessError{
+		Address: address,
+		Kind:    AccessRead,
+		Mapped:  false,
+	}
+}
+
+// Mapper is implemented by memory spaces whose regions can be created,
+// resized and removed while a program runs.
+type Mapper interface {
+	Alloc(startAddress uint32, size uint32, perm Permission) error
+	Resize(startAddress uint32, size uint32) error
+	Free(startAddress uint32) error
+	FindFree(size uint32, low uint32, high uint32) (uint32, bool)
+}
+
+var (
+	ErrNoSuchRegion = fmt.Errorf("no region starts at address")
+)
+
+// regionAt returns the index of the block starting at startAddress
+func (m *Memory) regionAt(startAddress uint32) (int, bool) {
+	for i, b := range m.Blocks {
+		if b.startAddress == startAddress {
+			return i, true
 		}
 	}
-	panic(fmt.Sprintf("invalid write at address 0x%08x", address))
+	return 0, false
+}
+
+// Resize grows or shrinks the region starting at startAddress to size
+// bytes. Grown memory is zeroed and must not overlap another region.
+func (m *Memory) Resize(startAddress uint32, size uint32) error {
+	i, ok := m.regionAt(startAddress)
+	if !ok {
+		return fmt.Errorf("resize 0x%08x: %w", startAddress, ErrNoSuchRegion)
+	}
+
+	b := m.Blocks[i]
+	oldSize := b.endAddress - b.startAddress
+	if size > oldSize {
+		if uint64(startAddress)+uint64(size) > 1<<32 {
+			return fmt.Errorf("resize 0x%08x: %w", startAddress, ErrOverlappingRegion)
+		}
+		if err := m.checkOverlap(b.endAddress, size-oldSize); err != nil {
+			return err
+		}
+		b.data = append(b.data, make([]byte, size-oldSize)...)
+	} else {
+		b.data = b.data[:size]
+	}
+
+	b.endAddress = startAddress + size
+	return nil
+}
+
+// Free unmaps the region starting at startAddress
+func (m *Memory) Free(startAddress uint32) error {
+	i, ok := m.regionAt(startAddress)
+	if !ok {
+		return fmt.Errorf("free 0x%08x: %w", startAddress, ErrNoSuchRegion)
+	}
+
+	m.Blocks = append(m.Blocks[:i], m.Blocks[i+1:]...)
+	return nil
+}
+
+// FindFree returns the lowest address in [low, high) where size bytes can
+// be mapped without overlapping any region. Candidate addresses are
+// aligned to PageSize.
+func (m *Memory) FindFree(size uint32, low uint32, high uint32) (uint32, bool) {
+	candidate := AlignUp(uint64(low), PageSize)
+	for candidate+uint64(size) <= uint64(high) {
+		conflict := m.firstOverlapping(uint32(candidate), size)
+		if conflict == nil {
+			return uint32(candidate), true
+		}
+		conflictSize := conflict.endAddress - conflict.startAddress
+		candidate = AlignUp(uint64(conflict.startAddress)+uint64(conflictSize), PageSize)
+	}
+	return 0, false
+}
+
+func (m *Memory) firstOverlapping(startAddress uint32, size uint32) *block {
+	for _, blocks := range [][]*block{m.Blocks, m.reserved} {
+		for _, b := range blocks {
+			if b.Overlaps(startAddress, size) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	// PageSize is the granularity that dynamically mapped regions are aligned to
+	PageSize = 0x1000
+)
+
+// AlignUp rounds address up to the next multiple of alignment
+func AlignUp(address uint64, alignment uint64) uint64 {
+	if rem := address % alignment; rem != 0 {
+		return address + alignment - rem
+	}
+	return address
 }
@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMemory_Permissions(t *testing.T) {
+	mem := New()
+	require.NoError(t, mem.Alloc(0x0, 0x10, PermReadExecute))
+	require.NoError(t, mem.Alloc(0x10, 0x10, PermReadWrite))
+
+	_, err := mem.Fetch(0x4)
+	assert.NoError(t, err)
+	_, err = mem.Read(0x8, 64)
+	assert.NoError(t, err)
+	assert.NoError(t, mem.Write(0x10, 64, 0x1122334455667788))
+	val, err := mem.Read(0x14, 32)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x11223344), val)
+
+	err = mem.Write(0x4, 32, 1)
+	var accessErr *AccessError
+	require.True(t, errors.As(err, &accessErr))
+	assert.Equal(t, AccessError{Address: 0x4, Kind: AccessWrite, Mapped: true, Perm: PermReadExecute}, *accessErr)
+	assert.EqualError(t, err, "invalid write at address 0x00000004 (region is r-x)")
+
+	_, err = mem.Fetch(0x10)
+	assert.EqualError(t, err, "invalid instruction fetch at address 0x00000010 (region is rw-)")
+
+	_, err = mem.Read(0x20, 8)
+	assert.EqualError(t, err, "invalid read at unmapped address 0x00000020")
+
+	// accesses may not run off the end of a region
+	_, err = mem.Read(0x1c, 64)
+	require.True(t, errors.As(err, &accessErr))
+	assert.Equal(t, AccessError{Address: 0x20, Kind: AccessRead, Mapped: false}, *accessErr)
+
+	// Peek ignores permissions but not mappings
+	_, err = mem.Peek(0x10, 64)
+	assert.NoError(t, err)
+	require.NoError(t, mem.Alloc(0x20, 0x10, PermNone))
+	_, err = mem.Read(0x20, 8)
+	assert.Error(t, err)
+	_, err = mem.Peek(0x20, 8)
+	assert.NoError(t, err)
+}
+
+func TestMemory_Reserve(t *testing.T) {
+	mem := New()
+	require.NoError(t, mem.Alloc(0x1000, 0x1000, PermReadWrite))
+	require.NoError(t, mem.Reserve(0x2000, 0x1000))
+
+	assert.ErrorIs(t, mem.Reserve(0x1800, 0x100), ErrOverlappingRegion)
+	assert.ErrorIs(t, mem.Alloc(0x2800, 0x1000, PermRead), ErrOverlappingRegion)
+	assert.EqualError(t, mem.Alloc(0x2fff, 2, PermRead), "map 0x00002fff-0x00003001: region overlaps existing region")
+	assert.NoError(t, mem.Alloc(0x3000, 0x1000, PermRead))
+
+	_, err := mem.Read(0x2000, 8)
+	assert.EqualError(t, err, "invalid read at unmapped address 0x00002000")
+}
+
+func TestMemory_FindFree(t *testing.T) {
+	mem := New()
+	require.NoError(t, mem.Alloc(0x1000, 0x1000, PermReadWrite))
+	require.NoError(t, mem.Reserve(0x2000, 0x800))
+	require.NoError(t, mem.Alloc(0x4000, 0x1000, PermReadWrite))
+
+	// candidates are page aligned and skip reserved ranges
+	address, ok := mem.FindFree(0x1000, 0x1000, 0x10000)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x3000), address)
+
+	address, ok = mem.FindFree(0x2000, 0x1001, 0x10000)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x5000), address)
+
+	_, ok = mem.FindFree(0x2000, 0x1000, 0x6000)
+	assert.False(t, ok)
+
+	address, ok = mem.FindFree(0x1000, 0xffff0000, 0xffffffff)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0xffff0000), address)
+	_, ok = mem.FindFree(0x1000, 0xfffff001, 0xffffffff)
+	assert.False(t, ok, "no page fits below the end of the address space")
+}
+
+func TestMemory_ResizeFree(t *testing.T) {
+	mem := New()
+	require.NoError(t, mem.Alloc(0x1000, 0x1000, PermReadWrite))
+	require.NoError(t, mem.Alloc(0x3000, 0x1000, PermReadWrite))
+	require.NoError(t, mem.Write(0x1ff8, 64, 42))
+
+	require.NoError(t, mem.Resize(0x1000, 0x2000))
+	val, err := mem.Read(0x1ff8, 64)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), val)
+	val, err = mem.Read(0x2ff8, 64)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), val, "grown memory is zeroed")
+
+	assert.ErrorIs(t, mem.Resize(0x1000, 0x2001), ErrOverlappingRegion)
+	assert.ErrorIs(t, mem.Resize(0x3000, 0xfffff000), ErrOverlappingRegion)
+	assert.ErrorIs(t, mem.Resize(0x2000, 0x10), ErrNoSuchRegion)
+
+	require.NoError(t, mem.Resize(0x1000, 0x10))
+	_, err = mem.Read(0x1010, 8)
+	assert.Error(t, err)
+
+	require.NoError(t, mem.Free(0x3000))
+	assert.ErrorIs(t, mem.Free(0x3000), ErrNoSuchRegion)
+	assert.NoError(t, mem.Alloc(0x3000, 0x1000, PermRead))
+}
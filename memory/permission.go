@@ -0,0 +1,66 @@
+package memory
+
+// Permission describes which kinds of access are allowed on a region of memory
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermExecute
+
+	PermNone        Permission = 0
+	PermReadWrite              = PermRead | PermWrite
+	PermReadExecute            = PermRead | PermExecute
+	PermAll                    = PermRead | PermWrite | PermExecute
+)
+
+// Allows returns whether the permission allows the given kind of access
+func (p Permission) Allows(kind AccessKind) bool {
+	switch kind {
+	case AccessRead:
+		return p&PermRead != 0
+	case AccessWrite:
+		return p&PermWrite != 0
+	case AccessFetch:
+		return p&PermExecute != 0
+	default:
+		return false
+	}
+}
+
+// String returns the permission in the familiar "rwx" notation
+func (p Permission) String() string {
+	res := []byte("---")
+	if p&PermRead != 0 {
+		res[0] = 'r'
+	}
+	if p&PermWrite != 0 {
+		res[1] = 'w'
+	}
+	if p&PermExecute != 0 {
+		res[2] = 'x'
+	}
+	return string(res)
+}
+
+// AccessKind describes the reason memory is being accessed
+type AccessKind uint8
+
+const (
+	AccessRead AccessKind = iota
+	AccessWrite
+	AccessFetch
+)
+
+func (a AccessKind) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessFetch:
+		return "instruction fetch"
+	default:
+		return "access"
+	}
+}
@@ -0,0 +1,76 @@
+package memory
+
+import "fmt"
+
+// Region describes a range of memory, used to save and restore the contents
+// of a memory space.
+type Region struct {
+	Start uint32
+	Size  uint32
+	Perm  Permission
+
+	// Reserved is set for ranges that must stay unmapped, which have no Data
+	Reserved bool
+	Data     []byte
+}
+
+// Snapshotter is implemented by memory spaces whose regions can be saved
+// and restored.
+type Snapshotter interface {
+	Regions() []Region
+	RestoreRegions(regions []Region) error
+}
+
+// Regions returns a copy of every mapped and reserved region
+func (m *Memory) Regions() []Region {
+	regions := make([]Region, 0, len(m.Blocks)+len(m.reserved))
+	for _, b := range m.Blocks {
+		data := make([]byte, len(b.data))
+		copy(data, b.data)
+		regions = append(regions, Region{
+			Start: b.startAddress,
+			Size:  b.endAddress - b.startAddress,
+			Perm:  b.perm,
+			Data:  data,
+		})
+	}
+	for _, b := range m.reserved {
+		regions = append(regions, Region{
+			Start:    b.startAddress,
+			Size:     b.endAddress - b.startAddress,
+			Perm:     PermNone,
+			Reserved: true,
+		})
+	}
+	return regions
+}
+
+// RestoreRegions replaces the contents of the memory with the given
+// regions. On error, the memory is left unchanged.
+func (m *Memory) RestoreRegions(regions []Region) error {
+	restored := New()
+	for _, region := range regions {
+		if uint64(region.Start)+uint64(region.Size) > 1<<32 {
+			return fmt.Errorf("region 0x%08x of size %d exceeds address space", region.Start, region.Size)
+		}
+
+		if region.Reserved {
+			if err := restored.Reserve(region.Start, region.Size); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(region.Data) != int(region.Size) {
+			return fmt.Errorf("region 0x%08x has %d bytes of data, expected %d", region.Start, len(region.Data), region.Size)
+		}
+		if err := restored.Alloc(region.Start, region.Size, region.Perm); err != nil {
+			return err
+		}
+		b := restored.Blocks[len(restored.Blocks)-1]
+		copy(b.data, region.Data)
+	}
+
+	*m = *restored
+	return nil
+}
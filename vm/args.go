@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/memory"
+)
+
+// Registers that hold the program arguments when the program starts
+const (
+	ArgCountRegister    arch.RegisterValue = 1
+	ArgVectorRegister   arch.RegisterValue = 2
+	EnvironmentRegister arch.RegisterValue = 3
+)
+
+// InitArguments copies the program arguments and environment onto the
+// stack, which must already be initialized with InitStack. Starting at the
+// stack pointer, the stack then contains:
+//   - argc
+//   - argv[0], ..., argv[argc-1], 0
+//   - envp[0], ..., envp[n-1], 0
+//   - the null-terminated strings pointed to by argv and envp
+//
+// argc, argv and envp are also placed in r1, r2 and r3. Environment entries
+// are strings of the form "NAME=value".
+func (v *VirtualMachine) InitArguments(args []string, env []string) error {
+	stringsSize := uint64(0)
+	for _, s := range args {
+		stringsSize += uint64(len(s)) + 1
+	}
+	for _, s := range env {
+		stringsSize += uint64(len(s)) + 1
+	}
+	stringsSize = memory.AlignUp(stringsSize, 8)
+
+	// argc, both vectors and their terminating null pointers
+	vectorsSize := uint64(len(args)+len(env)+3) * 8
+
+	top := uint64(v.stack.Top)
+	if v.stack.Size() == 0 {
+		return fmt.Errorf("stack is not initialized")
+	} else if stringsSize+vectorsSize > uint64(v.stack.Size()) {
+		return fmt.Errorf("arguments (%d bytes) do not fit on the stack", stringsSize+vectorsSize)
+	}
+
+	stringAddress := uint32(top - stringsSize)
+	sp := uint32(top - stringsSize - vectorsSize)
+	argv := sp + 8
+	envp := argv + uint32(len(args)+1)*8
+
+	vectorAddress := sp
+	writeWord := func(val uint64) error {
+		err := v.memory.Write(vectorAddress, 64, val)
+		vectorAddress += 8
+		return err
+	}
+	writeString := func(s string) error {
+		if err := writeWord(uint64(stringAddress)); err != nil {
+			return err
+		}
+		for i := 0; i < len(s); i++ {
+			if err := v.memory.Write(stringAddress, 8, uint64(s[i])); err != nil {
+				return err
+			}
+			stringAddress++
+		}
+		err := v.memory.Write(stringAddress, 8, 0)
+		stringAddress++
+		return err
+	}
+
+	if err := writeWord(uint64(len(args))); err != nil {
+		return err
+	}
+	for _, vector := range [][]string{args, env} {
+		for _, s := range vector {
+			if err := writeString(s); err != nil {
+				return err
+			}
+		}
+		if err := writeWord(0); err != nil {
+			return err
+		}
+	}
+
+	v.registers.Set(arch.StackRegister, uint64(sp))
+	v.registers.Set(ArgCountRegister, uint64(len(args)))
+	v.registers.Set(ArgVectorRegister, uint64(argv))
+	v.registers.Set(EnvironmentRegister, uint64(envp))
+	return nil
+}
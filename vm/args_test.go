@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestInitArguments(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+	require.NoError(t, v.InitArguments([]string{"prog", "x"}, []string{"A=1"}))
+
+	// 11 bytes of strings padded to 16, below argc and the two vectors
+	const sp = testStackTop - 16 - 6*8
+	const stringsStart = testStackTop - 16
+	assert.Equal(t, uint64(sp), v.Register(arch.StackRegister))
+	assert.Equal(t, uint64(2), v.Register(ArgCountRegister))
+	assert.Equal(t, uint64(sp+8), v.Register(ArgVectorRegister))
+	assert.Equal(t, uint64(sp+32), v.Register(EnvironmentRegister))
+
+	words := []uint64{2, stringsStart, stringsStart + 5, 0, stringsStart + 7, 0}
+	for i, want := range words {
+		got, err := v.memory.Read(uint32(sp+i*8), 64)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "word %d", i)
+	}
+
+	for address, want := range map[uint32]string{stringsStart: "prog", stringsStart + 5: "x", stringsStart + 7: "A=1"} {
+		got, err := v.readString(address)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestInitArguments_Empty(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+	require.NoError(t, v.InitArguments(nil, nil))
+
+	const sp = testStackTop - 3*8
+	assert.Equal(t, uint64(sp), v.Register(arch.StackRegister))
+	assert.Equal(t, uint64(0), v.Register(ArgCountRegister))
+	assert.Equal(t, uint64(sp+8), v.Register(ArgVectorRegister))
+	assert.Equal(t, uint64(sp+16), v.Register(EnvironmentRegister))
+}
+
+func TestInitArguments_Errors(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+	assert.EqualError(t, v.InitArguments([]string{strings.Repeat("a", testStackSize)}, nil),
+		"arguments (4136 bytes) do not fit on the stack")
+
+	v = NewVirtualMachine(memory.New(), true)
+	assert.EqualError(t, v.InitArguments(nil, nil), "stack is not initialized")
+}
@@ -0,0 +1,238 @@
+// Package callcheck checks that the functions of a program running in a
+// vm.VirtualMachine follow the calling convention of ISA.md: a function must
+// restore the callee-saved registers r10-r13 before returning, and may only
+// change the stack pointer by the values it returns on the stack.
+package callcheck
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
+	"github.com/dnsge/orange/vm"
+)
+
+const (
+	// maxCallDepth limits the number of calls tracked. Calls nested deeper
+	// are not checked.
+	maxCallDepth = 4096
+
+	// returnSize is the size of a value returned on the stack
+	returnSize = 8
+)
+
+// calleeSaved holds the registers a function must restore before returning
+var calleeSaved = [...]arch.RegisterValue{10, 11, 12, 13}
+
+// Kind is a kind of violation of the calling convention
+type Kind int
+
+const (
+	// CalleeSaved is a callee-saved register that was not restored
+	CalleeSaved Kind = iota
+	// StackUnderflow is a return with the stack pointer above where it was
+	// at the call, after popping values of the caller
+	StackUnderflow
+	// StackMisaligned is a return that moved the stack pointer by a number
+	// of bytes that are not a whole number of returned values
+	StackMisaligned
+	// ReturnCount is a return with a different number of values on the
+	// stack than the previous returns of the function
+	ReturnCount
+)
+
+func (k Kind) String() string {
+	switch k {
+	case CalleeSaved:
+		return "callee-saved register not restored"
+	case StackUnderflow:
+		return "stack underflow"
+	case StackMisaligned:
+		return "misaligned stack"
+	case ReturnCount:
+		return "inconsistent return count"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Violation is a return from a function that broke the calling convention
+type Violation struct {
+	Kind Kind
+	// Function is the label of the called function, like $strLen, or its
+	// address without debug info
+	Function string
+	// CallSite is the address of the BL or BLR instruction, and ReturnSite
+	// the address of the BREG instruction that returned
+	CallSite   uint32
+	ReturnSite uint32
+
+	// Register is the callee-saved register that was not restored
+	Register arch.RegisterValue
+	// Before and After are the values of the register, or of the stack
+	// pointer for the stack kinds, at the call and after the return
+	Before uint64
+	After  uint64
+	// Returns is the number of values the function returned before, for
+	// ReturnCount
+	Returns int
+}
+
+func (v *Violation) Error() string {
+	switch v.Kind {
+	case CalleeSaved:
+		return fmt.Sprintf("%s did not restore callee-saved register r%d: 0x%x at the call, 0x%x after the return",
+			v.Function, v.Register, v.Before, v.After)
+	case StackUnderflow:
+		return fmt.Sprintf("%s popped %d bytes of its caller's stack: sp was 0x%x at the call, 0x%x after the return",
+			v.Function, v.After-v.Before, v.Before, v.After)
+	case StackMisaligned:
+		return fmt.Sprintf("%s moved the stack pointer by %d bytes, which is not a multiple of %d: sp was 0x%x at the call, 0x%x after the return",
+			v.Function, v.Before-v.After, returnSize, v.Before, v.After)
+	case ReturnCount:
+		return fmt.Sprintf("%s returned %d values on the stack, but %d when it returned before",
+			v.Function, (v.Before-v.After)/returnSize, v.Returns)
+	default:
+		return fmt.Sprintf("%s: %v", v.Function, v.Kind)
+	}
+}
+
+// frame is a call tracked by the checker
+type frame struct {
+	entry         uint32
+	callSite      uint32
+	returnAddress uint32
+	sp            uint64
+	saved         [len(calleeSaved)]uint64
+}
+
+// Checker is a vm.Hook that tracks the calls made with BL or BLR and checks
+// the registers once they return to the instruction after the call
+type Checker struct {
+	machine *vm.VirtualMachine
+	info    *debuginfo.Info
+
+	frames []frame
+	// untracked is the number of calls made beyond maxCallDepth that have
+	// not returned yet
+	untracked int
+	// returns maps function entries to the number of values they returned
+	// first
+	returns map[uint32]int
+
+	// Violations holds the violations found so far
+	Violations []*Violation
+	// Report is called with each violation as it is found, if it is set
+	Report func(v *Violation)
+}
+
+// New returns a checker for the calls made by machine, which names functions
+// by the symbols of info. info may be nil.
+func New(machine *vm.VirtualMachine, info *debuginfo.Info) *Checker {
+	return &Checker{
+		machine: machine,
+		info:    info,
+		returns: make(map[uint32]int),
+	}
+}
+
+// Executed tracks calls and checks returns
+func (c *Checker) Executed(pc uint32, instruction arch.Instruction, next uint32) {
+	switch arch.GetOpcode(instruction) {
+	case arch.BL, arch.BLR:
+		if len(c.frames) >= maxCallDepth {
+			c.untracked++
+			return
+		}
+		f := frame{
+			entry:         next,
+			callSite:      pc,
+			returnAddress: pc + 4,
+			sp:            c.machine.Register(arch.StackRegister),
+		}
+		for i, register := range calleeSaved {
+			f.saved[i] = c.machine.Register(register)
+		}
+		c.frames = append(c.frames, f)
+	case arch.BREG:
+		if c.untracked > 0 {
+			c.untracked--
+			return
+		}
+		// returns may skip frames, like a longjmp
+		for i := len(c.frames) - 1; i >= 0; i-- {
+			if c.frames[i].returnAddress == next {
+				c.check(&c.frames[i], pc)
+				c.frames = c.frames[:i]
+				return
+			}
+		}
+	}
+}
+
+// check checks the registers after the call of f returned at pc
+func (c *Checker) check(f *frame, pc uint32) {
+	violation := func(kind Kind) *Violation {
+		return &Violation{
+			Kind:       kind,
+			Function:   c.symbol(f.entry),
+			CallSite:   f.callSite,
+			ReturnSite: pc,
+		}
+	}
+
+	for i, register := range calleeSaved {
+		if value := c.machine.Register(register); value != f.saved[i] {
+			v := violation(CalleeSaved)
+			v.Register = register
+			v.Before = f.saved[i]
+			v.After = value
+			c.report(v)
+		}
+	}
+
+	sp := c.machine.Register(arch.StackRegister)
+	var kind Kind
+	switch {
+	case sp > f.sp:
+		kind = StackUnderflow
+	case (f.sp-sp)%returnSize != 0:
+		kind = StackMisaligned
+	default:
+		returns := int((f.sp - sp) / returnSize)
+		previous, ok := c.returns[f.entry]
+		if !ok {
+			c.returns[f.entry] = returns
+			return
+		}
+		if returns == previous {
+			return
+		}
+		kind = ReturnCount
+	}
+
+	v := violation(kind)
+	v.Before = f.sp
+	v.After = sp
+	v.Returns = c.returns[f.entry]
+	c.report(v)
+}
+
+func (c *Checker) report(v *Violation) {
+	c.Violations = append(c.Violations, v)
+	if c.Report != nil {
+		c.Report(v)
+	}
+}
+
+// symbol returns the name of the function at the address
+func (c *Checker) symbol(address uint32) string {
+	if c.info != nil {
+		if symbol, ok := c.info.SymbolFor(address); ok {
+			if symbol.Address == address {
+				return "$" + symbol.Name
+			}
+			return fmt.Sprintf("$%s+%d", symbol.Name, address-symbol.Address)
+		}
+	}
+	return fmt.Sprintf("0x%08x", address)
+}
@@ -0,0 +1,94 @@
+package callcheck
+
+import (
+	"bytes"
+	"context"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const program = `$main:
+	MOVZ r10, #1
+	BL $good
+	POP r1
+	BL $clobber
+	PUSH r0
+	BL $pop
+	MOVZ r1, #0
+	BL $maybe
+	MOVZ r1, #1
+	BL $maybe
+	POP r1
+	HALT
+
+$good:
+	PUSH r10
+	MOVZ r10, #2
+	PUSH r10
+	LDREG r10, [rsp, #8]
+	POP r1
+	ADDI rsp, #8
+	PUSH r1
+	BREG rrp
+
+$clobber:
+	MOVZ r10, #3
+	BREG rrp
+
+$pop:
+	ADDI rsp, #8
+	BREG rrp
+
+$maybe:
+	CMPI r1, #0
+	B.EQ $_maybe.done
+	PUSH r1
+$_maybe.done:
+	BREG rrp
+`
+
+func TestChecker(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(program), &out, &asm.Options{
+		Target: asm.TargetExecutable,
+		Debug:  true,
+	}))
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+	sim := vm.NewVirtualMachine(mem, true)
+	stack, err := vm.MapStack(mem, 0x80000000, 0x1000)
+	require.NoError(t, err)
+	sim.InitStack(stack)
+
+	c := New(sim, exe.Debug)
+	var reported []*Violation
+	c.Report = func(v *Violation) {
+		reported = append(reported, v)
+	}
+	sim.AddHook(c)
+	require.NoError(t, sim.Run(context.Background(), vm.RunOptions{MaxSteps: 1000}))
+
+	var messages []string
+	for _, v := range c.Violations {
+		messages = append(messages, v.Error())
+	}
+	assert.Equal(t, []string{
+		"$clobber did not restore callee-saved register r10: 0x1 at the call, 0x3 after the return",
+		"$pop popped 8 bytes of its caller's stack: sp was 0x7ffffff8 at the call, 0x80000000 after the return",
+		"$maybe returned 1 values on the stack, but 0 when it returned before",
+	}, messages)
+	assert.Equal(t, c.Violations, reported)
+
+	assert.Equal(t, CalleeSaved, c.Violations[0].Kind)
+	assert.Equal(t, uint32(0x0c), c.Violations[0].CallSite)
+	assert.Equal(t, ReturnCount, c.Violations[2].Kind)
+}
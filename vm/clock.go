@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// DeterministicStepDuration is the time that passes for every executed
+	// instruction in deterministic mode
+	DeterministicStepDuration = time.Nanosecond
+)
+
+// Clock is the source of time for the clock_gettime and nanosleep syscalls.
+//
+// Sleep waits for d to pass, returning ctx.Err() if ctx is done first.
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// hostClock uses the real clock of the host
+type hostClock struct{}
+
+func (hostClock) Now() time.Time {
+	return time.Now()
+}
+
+func (hostClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stepClock derives the time from the number of instructions executed by
+// the VM, starting at the Unix epoch. Sleeping advances the clock without
+// blocking.
+type stepClock struct {
+	v     *VirtualMachine
+	slept time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	elapsed := time.Duration(c.v.steps)*DeterministicStepDuration + c.slept
+	return time.Unix(0, 0).Add(elapsed)
+}
+
+func (c *stepClock) Sleep(_ context.Context, d time.Duration) error {
+	// saturate instead of wrapping around so the clock never goes back
+	if d > math.MaxInt64-c.slept {
+		c.slept = math.MaxInt64
+	} else {
+		c.slept += d
+	}
+	return nil
+}
+
+// SetClock sets the Clock used by the time syscalls. The monotonic clock
+// seen by programs starts at zero when the clock is set.
+func (v *VirtualMachine) SetClock(clock Clock) {
+	v.clock = clock
+	v.clockStart = clock.Now()
+}
+
+// SetRandom sets the source of the getrandom syscall
+func (v *VirtualMachine) SetRandom(random io.Reader) {
+	v.random = random
+}
+
+// seededRandom generates the random bytes of a deterministic VM with
+// SplitMix64. Its state is a single number, so snapshots can save it.
+type seededRandom struct {
+	state uint64
+}
+
+func (r *seededRandom) Read(p []byte) (int, error) {
+	for i := 0; i < len(p); i += 8 {
+		r.state += 0x9e3779b97f4a7c15
+		z := r.state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		z ^= z >> 31
+
+		var word [8]byte
+		binary.LittleEndian.PutUint64(word[:], z)
+		copy(p[i:], word[:])
+	}
+	return len(p), nil
+}
+
+// SetDeterministic makes the time and random syscalls reproducible. The
+// clock advances by DeterministicStepDuration per executed instruction and
+// random bytes are generated from seed.
+func (v *VirtualMachine) SetDeterministic(seed int64) {
+	v.SetClock(&stepClock{v: v})
+	v.SetRandom(&seededRandom{state: uint64(seed)})
+}
+
+// Steps returns the number of instructions executed so far
+func (v *VirtualMachine) Steps() uint64 {
+	return v.steps
+}
+
+func (v *VirtualMachine) initHostServices() {
+	v.SetClock(hostClock{})
+	v.SetRandom(rand.Reader)
+}
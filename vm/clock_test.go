@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"context"
+	"github.com/dnsge/orange/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+// sleepProgram sleeps for ten seconds and halts
+const sleepProgram = `
+	SUBI rsp, rsp, #16
+	MOVZ r1, #10
+	STREG r1, [rsp]
+	MOV r1, rsp
+	MOVZ r9, #13
+	SYSCALL
+	HALT
+`
+
+// readTimespec reads the duration written by clock_gettime to address
+func readTimespec(t *testing.T, v *VirtualMachine, address uint32) time.Duration {
+	t.Helper()
+
+	seconds, err := v.memory.Read(address, 64)
+	require.NoError(t, err)
+	nanoseconds, err := v.memory.Read(address+8, 64)
+	require.NoError(t, err)
+	return time.Duration(seconds)*time.Second + time.Duration(nanoseconds)
+}
+
+func TestClock_Deterministic(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+	v.SetDeterministic(1)
+	const tp = testStackTop - 16
+
+	_, errno := callSyscall(t, v, SyscallClockGettime, 0, tp)
+	require.Equal(t, uint64(0), errno)
+	assert.Equal(t, time.Duration(0), readTimespec(t, v, tp))
+
+	v.steps = 1500
+	require.NoError(t, v.writeTimespec(tp, 2*time.Second+5))
+	start := time.Now()
+	_, errno = callSyscall(t, v, SyscallNanosleep, tp)
+	require.Equal(t, uint64(0), errno)
+	assert.Less(t, time.Since(start), time.Second, "sleeping does not block")
+
+	for _, clockID := range []uint64{0, 1} {
+		_, errno = callSyscall(t, v, SyscallClockGettime, clockID, tp)
+		require.Equal(t, uint64(0), errno)
+		assert.Equal(t, 2*time.Second+1505*time.Nanosecond, readTimespec(t, v, tp))
+	}
+
+	// sleeps must be valid timespecs
+	require.NoError(t, v.writeTimespec(tp, 0))
+	require.NoError(t, v.memory.Write(tp+8, 64, uint64(time.Second)))
+	_, errno = callSyscall(t, v, SyscallNanosleep, tp)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+}
+
+func TestNanosleep_Overflow(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+	v.SetDeterministic(1)
+	const tp = testStackTop - 16
+	const maxSeconds = math.MaxInt64 / uint64(time.Second)
+
+	// one nanosecond more than the longest duration is rejected
+	require.NoError(t, v.memory.Write(tp, 64, maxSeconds))
+	require.NoError(t, v.memory.Write(tp+8, 64, math.MaxInt64%uint64(time.Second)+1))
+	_, errno := callSyscall(t, v, SyscallNanosleep, tp)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	assert.Equal(t, time.Duration(0), v.clock.(*stepClock).slept)
+
+	require.NoError(t, v.memory.Write(tp+8, 64, math.MaxInt64%uint64(time.Second)))
+	_, errno = callSyscall(t, v, SyscallNanosleep, tp)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, time.Duration(math.MaxInt64), v.clock.(*stepClock).slept)
+
+	// further sleeps do not wrap the clock around
+	require.NoError(t, v.writeTimespec(tp, time.Second))
+	_, errno = callSyscall(t, v, SyscallNanosleep, tp)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, time.Duration(math.MaxInt64), v.clock.(*stepClock).slept)
+}
+
+func TestGetrandom_Deterministic(t *testing.T) {
+	const buf = testStackTop - 512
+	random := func(seed int64, nBytes uint64) []byte {
+		v := newTestVM(t, "\tHALT\n")
+		v.SetDeterministic(seed)
+		written, errno := callSyscall(t, v, SyscallGetrandom, buf, nBytes)
+		require.Equal(t, uint64(0), errno)
+
+		bytes := make([]byte, written)
+		for i := range bytes {
+			b, err := v.memory.Read(buf+uint32(i), 8)
+			require.NoError(t, err)
+			bytes[i] = byte(b)
+		}
+		return bytes
+	}
+
+	assert.Equal(t, random(1, 32), random(1, 32))
+	assert.NotEqual(t, random(1, 32), random(2, 32))
+	assert.Len(t, random(1, 1000), maxRandomLength)
+}
+
+func TestNanosleep_Interrupted(t *testing.T) {
+	v := newTestVM(t, sleepProgram)
+	start := time.Now()
+	err := v.Run(context.Background(), RunOptions{Timeout: 20 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, v.Halted())
+	assert.Equal(t, uint64(arch.ENO_Interrupted), v.Register(arch.SyscallErrorRegister))
+
+	// the program sees the error once resumed
+	require.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 1}))
+	assert.True(t, v.Halted())
+
+	v = newTestVM(t, sleepProgram)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	assert.ErrorIs(t, v.Run(ctx, RunOptions{}), context.Canceled)
+	assert.Equal(t, uint64(arch.ENO_Interrupted), v.Register(arch.SyscallErrorRegister))
+}
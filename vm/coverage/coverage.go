@@ -0,0 +1,175 @@
+// Package coverage records which instructions of a program running in a
+// vm.VirtualMachine are executed and which way its conditional branches go,
+// and maps them back to source lines with the debug info of the program.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	magic   = "orange-coverage"
+	version = 1
+)
+
+// ErrInvalidProfile is returned by Read for data that is not a coverage
+// profile
+var ErrInvalidProfile = fmt.Errorf("invalid coverage profile")
+
+// Branch counts the outcomes of a conditional branch
+type Branch struct {
+	Taken    uint64
+	NotTaken uint64
+}
+
+// Profile is a vm.Hook that counts the executions of every instruction and
+// the outcomes of conditional branches like B.EQ
+type Profile struct {
+	// Counts maps addresses to the number of times their instruction was
+	// executed
+	Counts map[uint32]uint64
+	// Branches maps the addresses of executed conditional branches to their
+	// outcomes
+	Branches map[uint32]*Branch
+}
+
+// New returns an empty profile
+func New() *Profile {
+	return &Profile{
+		Counts:   make(map[uint32]uint64),
+		Branches: make(map[uint32]*Branch),
+	}
+}
+
+// IsConditionalBranch returns whether the opcode is a BI-type branch that is
+// only taken if its condition holds
+func IsConditionalBranch(opcode arch.Opcode) bool {
+	switch opcode {
+	case arch.B_EQ, arch.B_NEQ, arch.B_LT, arch.B_LE, arch.B_GT, arch.B_GE:
+		return true
+	default:
+		return false
+	}
+}
+
+// Executed counts the instruction at pc, recording whether conditional
+// branches went anywhere but the following instruction
+func (p *Profile) Executed(pc uint32, instruction arch.Instruction, next uint32) {
+	p.Counts[pc]++
+	if !IsConditionalBranch(arch.GetOpcode(instruction)) {
+		return
+	}
+
+	b := p.branch(pc)
+	if next != pc+4 {
+		b.Taken++
+	} else {
+		b.NotTaken++
+	}
+}
+
+func (p *Profile) branch(address uint32) *Branch {
+	b, ok := p.Branches[address]
+	if !ok {
+		b = &Branch{}
+		p.Branches[address] = b
+	}
+	return b
+}
+
+// Merge adds the counts of other to the profile, e.g. to combine the runs of
+// a test suite
+func (p *Profile) Merge(other *Profile) {
+	for address, count := range other.Counts {
+		p.Counts[address] += count
+	}
+	for address, ob := range other.Branches {
+		b := p.branch(address)
+		b.Taken += ob.Taken
+		b.NotTaken += ob.NotTaken
+	}
+}
+
+func sortedAddresses(counts map[uint32]uint64) []uint32 {
+	addresses := make([]uint32, 0, len(counts))
+	for address := range counts {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(a, b int) bool {
+		return addresses[a] < addresses[b]
+	})
+	return addresses
+}
+
+// MarshalTo writes the profile to the given io.Writer.
+//
+// The format is text, one record per line:
+//
+// orange-coverage [version]
+// - for each executed instruction, pc [address] [count]
+// - for each executed conditional branch, branch [address] [taken] [not taken]
+func (p *Profile) MarshalTo(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	_, _ = fmt.Fprintf(w, "%s %d\n", magic, version)
+	for _, address := range sortedAddresses(p.Counts) {
+		_, _ = fmt.Fprintf(w, "pc 0x%08x %d\n", address, p.Counts[address])
+		if b, ok := p.Branches[address]; ok {
+			_, _ = fmt.Fprintf(w, "branch 0x%08x %d %d\n", address, b.Taken, b.NotTaken)
+		}
+	}
+	return w.Flush()
+}
+
+// Read reads a profile written by MarshalTo
+func Read(reader io.Reader) (*Profile, error) {
+	scanner := bufio.NewScanner(reader)
+	var header string
+	if scanner.Scan() {
+		header = scanner.Text()
+	}
+	if header != fmt.Sprintf("%s %d", magic, version) {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing coverage header: %w", ErrInvalidProfile)
+	}
+
+	p := New()
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var address uint32
+		var err error
+		switch {
+		case fields[0] == "pc" && len(fields) == 3:
+			var count uint64
+			if _, err = fmt.Sscanf(fields[1]+" "+fields[2], "0x%x %d", &address, &count); err == nil {
+				p.Counts[address] += count
+			}
+		case fields[0] == "branch" && len(fields) == 4:
+			var taken, notTaken uint64
+			if _, err = fmt.Sscanf(strings.Join(fields[1:], " "), "0x%x %d %d", &address, &taken, &notTaken); err == nil {
+				b := p.branch(address)
+				b.Taken += taken
+				b.NotTaken += notTaken
+			}
+		default:
+			err = fmt.Errorf("unknown record %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v: %w", line, err, ErrInvalidProfile)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,103 @@
+package coverage
+
+import (
+	"bytes"
+	"context"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const branchProgram = `$main:
+	MOVZ r1, #2
+$_loop:
+	SUBI r1, #1
+	CMPI r1, #0
+	B.NEQ $_loop
+	CMPI r1, #5
+	B.EQ $_never
+	HALT
+$_never:
+	HALT
+`
+
+func runProgram(t *testing.T, source string) (*Profile, *executable.File) {
+	var out bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(source), &out, &asm.Options{
+		Target:   asm.TargetExecutable,
+		Debug:    true,
+		FileName: "branch.orange",
+	}))
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+	sim := vm.NewVirtualMachine(mem, true)
+
+	p := New()
+	sim.AddHook(p)
+	require.NoError(t, sim.Run(context.Background(), vm.RunOptions{}))
+	return p, exe
+}
+
+func TestProfile_Branches(t *testing.T) {
+	p, _ := runProgram(t, branchProgram)
+	assert.Equal(t, map[uint32]*Branch{
+		0x0c: {Taken: 1, NotTaken: 1},
+		0x14: {Taken: 0, NotTaken: 1},
+	}, p.Branches)
+	assert.Equal(t, uint64(2), p.Counts[0x04])
+	assert.NotContains(t, p.Counts, uint32(0x1c))
+}
+
+func TestProfile_MarshalTo(t *testing.T) {
+	p, _ := runProgram(t, branchProgram)
+
+	var out bytes.Buffer
+	require.NoError(t, p.MarshalTo(&out))
+	read, err := Read(&out)
+	require.NoError(t, err)
+	assert.Equal(t, p, read)
+
+	_, err = Read(strings.NewReader("not coverage\n"))
+	assert.ErrorIs(t, err, ErrInvalidProfile)
+}
+
+func TestReport_WriteLCOV(t *testing.T) {
+	p, exe := runProgram(t, branchProgram)
+	report, err := NewReport(p, exe)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteLCOV(&out))
+	assert.Equal(t, `TN:
+SF:branch.orange
+FN:2,main
+FNDA:1,main
+FNF:1
+FNH:1
+BRDA:6,0,0,1
+BRDA:6,0,1,1
+BRDA:8,0,0,0
+BRDA:8,0,1,1
+BRF:4
+BRH:3
+DA:2,1
+DA:4,2
+DA:5,2
+DA:6,2
+DA:7,1
+DA:8,1
+DA:9,1
+DA:11,0
+LF:8
+LH:7
+end_of_record
+`, out.String())
+}
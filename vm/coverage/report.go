@@ -0,0 +1,242 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/executable"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrNoDebugInfo is returned by NewReport for executables without debug info
+var ErrNoDebugInfo = fmt.Errorf("executable has no debug info")
+
+// BranchCount is the outcome of a conditional branch of a line
+type BranchCount struct {
+	Address uint32
+	Branch
+}
+
+// Line is the coverage of the instructions assembled from a source line
+type Line struct {
+	Number int
+	// Instructions is the number of instructions of the line, and Executed
+	// how many of them were executed
+	Instructions int
+	Executed     int
+	// Hits is the most executions of any instruction of the line
+	Hits     uint64
+	Branches []BranchCount
+}
+
+// Function is the coverage of the entry of a public label
+type Function struct {
+	Name string
+	Line int
+	Hits uint64
+}
+
+// File is the coverage of a source file
+type File struct {
+	Name string
+	// Lines holds the lines with instructions, in order
+	Lines     []*Line
+	Functions []Function
+}
+
+// Report is the coverage of the source files of an executable
+type Report struct {
+	// Files is sorted by name
+	Files []*File
+}
+
+// wordAt returns the word of the executable at the address
+func wordAt(exe *executable.File, address uint32) (arch.Instruction, bool) {
+	for _, seg := range exe.Segments {
+		if address >= seg.Address && address < seg.Address+uint32(seg.Size()) {
+			return seg.Words[(address-seg.Address)/4], true
+		}
+	}
+	return 0, false
+}
+
+// NewReport maps the profile of a run of the executable to source lines,
+// which requires its debug info
+func NewReport(p *Profile, exe *executable.File) (*Report, error) {
+	info := exe.Debug
+	if info == nil {
+		return nil, ErrNoDebugInfo
+	}
+
+	files := make(map[string]*File)
+	lines := make(map[string]map[int]*Line)
+	for _, l := range info.Lines {
+		f, ok := files[l.File]
+		if !ok {
+			f = &File{Name: l.File}
+			files[l.File] = f
+			lines[l.File] = make(map[int]*Line)
+		}
+		line, ok := lines[l.File][l.Line]
+		if !ok {
+			line = &Line{Number: l.Line}
+			lines[l.File][l.Line] = line
+			f.Lines = append(f.Lines, line)
+		}
+
+		hits := p.Counts[l.Address]
+		line.Instructions++
+		if hits > 0 {
+			line.Executed++
+		}
+		if hits > line.Hits {
+			line.Hits = hits
+		}
+		if word, ok := wordAt(exe, l.Address); ok && IsConditionalBranch(arch.GetOpcode(word)) {
+			count := BranchCount{Address: l.Address}
+			if b, ok := p.Branches[l.Address]; ok {
+				count.Branch = *b
+			}
+			line.Branches = append(line.Branches, count)
+		}
+	}
+
+	for _, symbol := range info.Symbols {
+		if strings.HasPrefix(symbol.Name, "_") {
+			// private labels are jump targets within functions
+			continue
+		}
+		if l, ok := info.LineFor(symbol.Address); ok {
+			files[l.File].Functions = append(files[l.File].Functions, Function{
+				Name: symbol.Name,
+				Line: l.Line,
+				Hits: p.Counts[symbol.Address],
+			})
+		}
+	}
+
+	r := &Report{}
+	for _, f := range files {
+		sort.Slice(f.Lines, func(a, b int) bool {
+			return f.Lines[a].Number < f.Lines[b].Number
+		})
+		r.Files = append(r.Files, f)
+	}
+	sort.Slice(r.Files, func(a, b int) bool {
+		return r.Files[a].Name < r.Files[b].Name
+	})
+	return r, nil
+}
+
+// Summary returns the number of lines with instructions and branch
+// directions of the file, and how many of them were covered
+func (f *File) Summary() (lines int, linesHit int, branches int, branchesHit int) {
+	for _, line := range f.Lines {
+		lines++
+		if line.Hits > 0 {
+			linesHit++
+		}
+		for _, b := range line.Branches {
+			branches += 2
+			if b.Taken > 0 {
+				branchesHit++
+			}
+			if b.NotTaken > 0 {
+				branchesHit++
+			}
+		}
+	}
+	return
+}
+
+// WriteLCOV writes the report in the LCOV tracefile format, which tools like
+// genhtml turn into HTML
+func (r *Report) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.Files {
+		_, _ = fmt.Fprintln(bw, "TN:")
+		_, _ = fmt.Fprintf(bw, "SF:%s\n", f.Name)
+
+		functionsHit := 0
+		for _, fn := range f.Functions {
+			_, _ = fmt.Fprintf(bw, "FN:%d,%s\n", fn.Line, fn.Name)
+		}
+		for _, fn := range f.Functions {
+			_, _ = fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Hits, fn.Name)
+			if fn.Hits > 0 {
+				functionsHit++
+			}
+		}
+		_, _ = fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(f.Functions), functionsHit)
+
+		for _, line := range f.Lines {
+			for n, b := range line.Branches {
+				// the block of a branch is its number within the line
+				if line.Hits == 0 {
+					_, _ = fmt.Fprintf(bw, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", line.Number, n, line.Number, n)
+				} else {
+					_, _ = fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\nBRDA:%d,%d,1,%d\n", line.Number, n, b.Taken, line.Number, n, b.NotTaken)
+				}
+			}
+		}
+		lines, linesHit, branches, branchesHit := f.Summary()
+		_, _ = fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branches, branchesHit)
+
+		for _, line := range f.Lines {
+			_, _ = fmt.Fprintf(bw, "DA:%d,%d\n", line.Number, line.Hits)
+		}
+		_, _ = fmt.Fprintf(bw, "LF:%d\nLH:%d\n", lines, linesHit)
+		_, _ = fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// WriteAnnotated writes the source of the file with the executions of each
+// line in the margin like gcov: "-" for lines without instructions and
+// "#####" for lines that were never executed. Conditional branches are
+// followed by how often they were taken.
+func (f *File) WriteAnnotated(w io.Writer, source []byte) error {
+	byNumber := make(map[int]*Line, len(f.Lines))
+	for _, line := range f.Lines {
+		byNumber[line.Number] = line
+	}
+
+	bw := bufio.NewWriter(w)
+	lines, linesHit, branches, branchesHit := f.Summary()
+	_, _ = fmt.Fprintf(bw, "%9s:%5d:Source:%s\n", "-", 0, f.Name)
+	_, _ = fmt.Fprintf(bw, "%9s:%5d:Lines executed:%s of %d\n", "-", 0, percent(linesHit, lines), lines)
+	_, _ = fmt.Fprintf(bw, "%9s:%5d:Branches taken:%s of %d\n", "-", 0, percent(branchesHit, branches), branches)
+
+	sourceLines := strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+	for i, text := range sourceLines {
+		text = strings.TrimRight(text, "\r")
+		line, ok := byNumber[i+1]
+		margin := "-"
+		if ok && line.Hits == 0 {
+			margin = "#####"
+		} else if ok {
+			margin = fmt.Sprint(line.Hits)
+		}
+		_, _ = fmt.Fprintf(bw, "%9s:%5d:%s\n", margin, i+1, text)
+
+		if ok {
+			for _, b := range line.Branches {
+				if line.Hits == 0 {
+					_, _ = fmt.Fprintf(bw, "branch 0x%08x never executed\n", b.Address)
+				} else {
+					_, _ = fmt.Fprintf(bw, "branch 0x%08x taken %d, not taken %d\n", b.Address, b.Taken, b.NotTaken)
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func percent(hit int, total int) string {
+	if total == 0 {
+		return "100.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(hit)*100/float64(total))
+}
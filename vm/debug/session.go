@@ -0,0 +1,257 @@
+// Package debug implements debugging of programs running in a
+// vm.VirtualMachine, with breakpoints, watchpoints and reverse execution.
+package debug
+
+import (
+	"context"
+	"errors"
+	"github.com/dnsge/orange/vm"
+)
+
+const (
+	// DefaultHistoryLimit is the number of instructions that can be
+	// reversed by default
+	DefaultHistoryLimit = 100_000
+
+	// cancelCheckInterval is the number of instructions executed between
+	// checks for cancellation while continuing
+	cancelCheckInterval = 1024
+)
+
+// StopReason describes why execution stopped
+type StopReason uint8
+
+const (
+	// StopStep is reported after a single step
+	StopStep StopReason = iota
+	// StopBreakpoint is reported when reaching a breakpoint
+	StopBreakpoint
+	// StopWatchpoint is reported when the value of a watchpoint changed
+	StopWatchpoint
+	// StopHalted is reported when the program halted
+	StopHalted
+	// StopFault is reported when the program faulted
+	StopFault
+	// StopHistoryStart is reported when reverse execution reached the
+	// oldest recorded instruction
+	StopHistoryStart
+	// StopInterrupted is reported when the context was cancelled
+	StopInterrupted
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopHalted:
+		return "halted"
+	case StopFault:
+		return "fault"
+	case StopHistoryStart:
+		return "start of history"
+	case StopInterrupted:
+		return "interrupted"
+	default:
+		return "unknown"
+	}
+}
+
+// Watchpoint watches Size bits of memory at Address for changes
+type Watchpoint struct {
+	Address uint32
+	Size    uint32
+}
+
+// Stop describes where and why execution stopped
+type Stop struct {
+	Reason StopReason
+	PC     uint32
+
+	// Watchpoint is the watchpoint that triggered a StopWatchpoint, with
+	// the value before and after the change in execution order
+	Watchpoint *Watchpoint
+	OldValue   uint64
+	NewValue   uint64
+
+	// Fault is the error that stopped the program for StopFault
+	Fault error
+}
+
+// Session controls the execution of a VirtualMachine for a debugger
+type Session struct {
+	VM *vm.VirtualMachine
+
+	breakpoints map[uint32]bool
+	watchpoints []*Watchpoint
+	fault       error
+}
+
+// NewSession starts debugging v, recording up to historyLimit instructions
+// for reverse execution.
+func NewSession(v *vm.VirtualMachine, historyLimit int) *Session {
+	v.EnableHistory(historyLimit)
+	return &Session{
+		VM:          v,
+		breakpoints: make(map[uint32]bool),
+	}
+}
+
+// Fault returns the fault that stopped the program, if any
+func (s *Session) Fault() error {
+	return s.fault
+}
+
+func (s *Session) AddBreakpoint(address uint32) {
+	s.breakpoints[address] = true
+}
+
+func (s *Session) RemoveBreakpoint(address uint32) {
+	delete(s.breakpoints, address)
+}
+
+func (s *Session) HasBreakpoint(address uint32) bool {
+	return s.breakpoints[address]
+}
+
+// ClearBreakpoints removes every breakpoint
+func (s *Session) ClearBreakpoints() {
+	s.breakpoints = make(map[uint32]bool)
+}
+
+// AddWatchpoint stops execution whenever the size bits of memory at address
+// change, in either direction.
+func (s *Session) AddWatchpoint(address uint32, size uint32) *Watchpoint {
+	w := &Watchpoint{Address: address, Size: size}
+	s.watchpoints = append(s.watchpoints, w)
+	return w
+}
+
+func (s *Session) RemoveWatchpoint(address uint32, size uint32) {
+	for i, w := range s.watchpoints {
+		if w.Address == address && w.Size == size {
+			s.watchpoints = append(s.watchpoints[:i], s.watchpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// watchValues reads the current value of every watchpoint. Unreadable
+// watchpoints have the value zero.
+func (s *Session) watchValues() []uint64 {
+	values := make([]uint64, len(s.watchpoints))
+	for i, w := range s.watchpoints {
+		values[i], _ = s.VM.Peek(w.Address, w.Size)
+	}
+	return values
+}
+
+// changedWatchpoint compares the watchpoints against their previous values
+func (s *Session) changedWatchpoint(before []uint64) (*Stop, bool) {
+	after := s.watchValues()
+	for i, w := range s.watchpoints {
+		if before[i] != after[i] {
+			return &Stop{
+				Reason:     StopWatchpoint,
+				PC:         s.VM.PC(),
+				Watchpoint: w,
+				OldValue:   before[i],
+				NewValue:   after[i],
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// Step executes a single instruction
+func (s *Session) Step() *Stop {
+	if stop, done := s.stopIfFinished(); done {
+		return stop
+	}
+
+	before := s.watchValues()
+	if err := s.VM.ExecuteInstruction(); err != nil {
+		s.fault = err
+		return &Stop{Reason: StopFault, PC: s.VM.PC(), Fault: err}
+	}
+
+	if stop, ok := s.changedWatchpoint(before); ok {
+		return stop
+	}
+	if s.VM.Halted() {
+		return &Stop{Reason: StopHalted, PC: s.VM.PC()}
+	}
+	return &Stop{Reason: StopStep, PC: s.VM.PC()}
+}
+
+// Continue executes instructions until reaching a breakpoint, a watchpoint
+// changes, the program stops or ctx is cancelled.
+func (s *Session) Continue(ctx context.Context) *Stop {
+	for executed := 0; ; executed++ {
+		if executed%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return &Stop{Reason: StopInterrupted, PC: s.VM.PC()}
+		}
+
+		stop := s.Step()
+		if stop.Reason != StopStep {
+			return stop
+		}
+		if s.breakpoints[stop.PC] {
+			stop.Reason = StopBreakpoint
+			return stop
+		}
+	}
+}
+
+// ReverseStep undoes the most recently executed instruction
+func (s *Session) ReverseStep() *Stop {
+	before := s.watchValues()
+	if err := s.VM.StepBack(); err != nil {
+		if errors.Is(err, vm.ErrNoHistory) {
+			return &Stop{Reason: StopHistoryStart, PC: s.VM.PC()}
+		}
+		return &Stop{Reason: StopFault, PC: s.VM.PC(), Fault: err}
+	}
+	s.fault = nil
+
+	if stop, ok := s.changedWatchpoint(before); ok {
+		// report the change in execution order
+		stop.OldValue, stop.NewValue = stop.NewValue, stop.OldValue
+		return stop
+	}
+	return &Stop{Reason: StopStep, PC: s.VM.PC()}
+}
+
+// ReverseContinue undoes instructions until reaching a breakpoint, a
+// watchpoint changes, the start of the history is reached or ctx is
+// cancelled.
+func (s *Session) ReverseContinue(ctx context.Context) *Stop {
+	for executed := 0; ; executed++ {
+		if executed%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return &Stop{Reason: StopInterrupted, PC: s.VM.PC()}
+		}
+
+		stop := s.ReverseStep()
+		if stop.Reason != StopStep {
+			return stop
+		}
+		if s.breakpoints[stop.PC] {
+			stop.Reason = StopBreakpoint
+			return stop
+		}
+	}
+}
+
+// stopIfFinished reports programs that cannot execute any further
+func (s *Session) stopIfFinished() (*Stop, bool) {
+	if !s.VM.Halted() {
+		return nil, false
+	}
+	if s.fault != nil {
+		return &Stop{Reason: StopFault, PC: s.VM.PC(), Fault: s.fault}, true
+	}
+	return &Stop{Reason: StopHalted, PC: s.VM.PC()}, true
+}
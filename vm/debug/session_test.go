@@ -0,0 +1,100 @@
+package debug
+
+import (
+	"bytes"
+	"context"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const counterProgram = `
+	MOVZ r1, #0
+	ADR r2, $counter
+$loop:
+	ADDI r1, #1
+	STREG r1, [r2]
+	CMPI r1, #3
+	B.NEQ $loop
+	HALT
+
+.section data
+
+$counter:	.fill #0
+`
+
+func loadProgram(t *testing.T, source string) *vm.VirtualMachine {
+	var out bytes.Buffer
+	require.NoError(t, asm.AssembleExecutable(strings.NewReader(source), &out))
+
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+	return vm.NewVirtualMachine(mem, true)
+}
+
+func TestSession_ReverseContinueToWatchpoint(t *testing.T) {
+	session := NewSession(loadProgram(t, counterProgram), 0)
+	ctx := context.Background()
+
+	stop := session.Continue(ctx)
+	require.Equal(t, StopHalted, stop.Reason)
+
+	counter := uint32(session.VM.Register(2))
+	value, err := session.VM.Peek(counter, 64)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), value)
+
+	session.AddWatchpoint(counter, 64)
+	for expected := uint64(3); expected > 0; expected-- {
+		stop = session.ReverseContinue(ctx)
+		require.Equal(t, StopWatchpoint, stop.Reason)
+		assert.Equal(t, expected-1, stop.OldValue)
+		assert.Equal(t, expected, stop.NewValue)
+
+		value, err = session.VM.Peek(counter, 64)
+		require.NoError(t, err)
+		assert.Equal(t, expected-1, value)
+	}
+
+	stop = session.ReverseContinue(ctx)
+	assert.Equal(t, StopHistoryStart, stop.Reason)
+	assert.Equal(t, uint32(0), stop.PC)
+	assert.Equal(t, uint64(0), session.VM.Register(1))
+	assert.False(t, session.VM.Halted())
+
+	stop = session.Continue(ctx)
+	require.Equal(t, StopWatchpoint, stop.Reason)
+	assert.Equal(t, uint64(0), stop.OldValue)
+	assert.Equal(t, uint64(1), stop.NewValue)
+}
+
+func TestSession_Breakpoints(t *testing.T) {
+	session := NewSession(loadProgram(t, counterProgram), 0)
+	ctx := context.Background()
+
+	// the STREG instruction in the loop
+	session.AddBreakpoint(12)
+
+	for i := 0; i < 3; i++ {
+		stop := session.Continue(ctx)
+		require.Equal(t, StopBreakpoint, stop.Reason)
+		assert.Equal(t, uint32(12), stop.PC)
+		assert.Equal(t, uint64(i+1), session.VM.Register(1))
+	}
+	assert.Equal(t, StopHalted, session.Continue(ctx).Reason)
+
+	stop := session.ReverseContinue(ctx)
+	require.Equal(t, StopBreakpoint, stop.Reason)
+	assert.Equal(t, uint64(3), session.VM.Register(1))
+
+	session.RemoveBreakpoint(12)
+	assert.Equal(t, StopHistoryStart, session.ReverseContinue(ctx).Reason)
+}
@@ -1,44 +1,56 @@
 package vm
 
-import "github.com/dnsge/orange/arch"
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+)
 
-func (v *VirtualMachine) fetchNextInstruction() arch.Instruction {
-	i := v.memory.Read(v.programCounter, 32) // read word for instruction
-	return arch.Instruction(i)
+func (v *VirtualMachine) fetchNextInstruction() (arch.Instruction, error) {
+	i, err := v.memory.Fetch(v.programCounter) // read word for instruction
+	if err != nil {
+		return 0, err
+	}
+	return arch.Instruction(i), nil
 }
 
-func (v *VirtualMachine) executeInstruction(instruction arch.Instruction) {
+func (v *VirtualMachine) executeInstruction(instruction arch.Instruction) error {
 	opcode := arch.GetOpcode(instruction)
 	iType := arch.GetInstructionType(opcode)
 
+	var err error
 	switch iType {
 	case arch.IType_A:
 		i := arch.DecodeATypeInstruction(instruction, opcode)
-		v.executeATypeInstruction(i)
+		err = v.executeATypeInstruction(i)
 	case arch.IType_AI:
 		i := arch.DecodeATypeImmInstruction(instruction, opcode)
-		v.executeATypeImmInstruction(i)
+		err = v.executeATypeImmInstruction(i)
 	case arch.IType_M:
 		i := arch.DecodeMTypeInstruction(instruction, opcode)
-		v.executeMTypeInstruction(i)
+		err = v.executeMTypeInstruction(i)
 	case arch.IType_E:
 		i := arch.DecodeETypeInstruction(instruction, opcode)
 		v.executeETypeInstruction(i)
 	case arch.IType_BI:
 		i := arch.DecodeBTypeImmInstruction(instruction, opcode)
-		v.executeBTypeImmInstruction(i)
+		err = v.executeBTypeImmInstruction(i)
 	case arch.IType_B:
 		i := arch.DecodeBTypeInstruction(instruction, opcode)
-		v.executeBTypeInstruction(i)
+		err = v.executeBTypeInstruction(i)
 	case arch.IType_R:
 		i := arch.DecodeRTypeInstruction(instruction, opcode)
-		v.executeRTypeInstruction(i)
+		err = v.executeRTypeInstruction(i)
 	case arch.IType_O:
 		i := arch.DecodeOTypeInstruction(instruction, opcode)
-		v.executeOTypeInstruction(i)
+		err = v.executeOTypeInstruction(i)
 	default:
-		panic("invalid instruction type")
+		err = fmt.Errorf("invalid instruction 0x%08x", instruction)
+	}
+
+	if err != nil {
+		return err
 	}
 
 	v.programCounter += 4 // advance by word
+	return nil
 }
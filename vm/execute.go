@@ -6,7 +6,7 @@ import (
 	"math"
 )
 
-func (v *VirtualMachine) executeATypeInstruction(instruction arch.ATypeInstruction) {
+func (v *VirtualMachine) executeATypeInstruction(instruction arch.ATypeInstruction) error {
 	aVal := v.registers.Get(instruction.RegA)
 	bVal := v.registers.Get(instruction.RegB)
 
@@ -23,13 +23,14 @@ func (v *VirtualMachine) executeATypeInstruction(instruction arch.ATypeInstructi
 	case arch.XOR:
 		res = v.alu.XOR(aVal, bVal)
 	default:
-		panic("invalid A-Type opcode")
+		return fmt.Errorf("invalid A-Type opcode %s", instruction.Opcode)
 	}
 
 	v.registers.Set(instruction.RegDest, res)
+	return nil
 }
 
-func (v *VirtualMachine) executeATypeImmInstruction(instruction arch.ATypeImmInstruction) {
+func (v *VirtualMachine) executeATypeImmInstruction(instruction arch.ATypeImmInstruction) error {
 	aVal := v.registers.Get(instruction.RegA)
 	bVal := uint64(instruction.Immediate)
 
@@ -44,43 +45,59 @@ func (v *VirtualMachine) executeATypeImmInstruction(instruction arch.ATypeImmIns
 	case arch.LSR:
 		res = v.alu.LSR(aVal, bVal)
 	default:
-		panic("invalid AImm-Type opcode")
+		return fmt.Errorf("invalid AImm-Type opcode %s", instruction.Opcode)
 	}
 
 	v.registers.Set(instruction.RegDest, res)
+	return nil
 }
 
-func (v *VirtualMachine) executeMTypeInstruction(instruction arch.MTypeInstruction) {
+func (v *VirtualMachine) executeMTypeInstruction(instruction arch.MTypeInstruction) error {
 	baseReg := v.registers.Get(instruction.RegB)
 	offset := uint64(instruction.Immediate)
 
 	targetAddress := baseReg + offset
-	if targetAddress > math.MaxInt32 {
-		panic(fmt.Sprintf("invalid computed memory address %d", targetAddress))
+	if targetAddress > math.MaxUint32 {
+		return &Fault{
+			Kind:    FaultSegmentation,
+			PC:      v.programCounter,
+			Address: uint32(targetAddress),
+			Err:     fmt.Errorf("invalid computed memory address 0x%x", targetAddress),
+		}
 	}
 
 	switch instruction.Opcode {
 	case arch.LDREG:
-		v.registers.Set(instruction.RegA, v.memory.Read(uint32(targetAddress), 64))
+		return v.load(instruction.RegA, uint32(targetAddress), 64)
 	case arch.LDWORD:
-		v.registers.Set(instruction.RegA, v.memory.Read(uint32(targetAddress), 32))
+		return v.load(instruction.RegA, uint32(targetAddress), 32)
 	case arch.LDHWRD:
-		v.registers.Set(instruction.RegA, v.memory.Read(uint32(targetAddress), 16))
+		return v.load(instruction.RegA, uint32(targetAddress), 16)
 	case arch.LDBYTE:
-		v.registers.Set(instruction.RegA, v.memory.Read(uint32(targetAddress), 8))
+		return v.load(instruction.RegA, uint32(targetAddress), 8)
 	case arch.STREG:
-		v.memory.Write(uint32(targetAddress), 64, v.registers.Get(instruction.RegA))
+		return v.memory.Write(uint32(targetAddress), 64, v.registers.Get(instruction.RegA))
 	case arch.STWORD:
-		v.memory.Write(uint32(targetAddress), 32, v.registers.Get(instruction.RegA))
+		return v.memory.Write(uint32(targetAddress), 32, v.registers.Get(instruction.RegA))
 	case arch.STHWRD:
-		v.memory.Write(uint32(targetAddress), 16, v.registers.Get(instruction.RegA))
+		return v.memory.Write(uint32(targetAddress), 16, v.registers.Get(instruction.RegA))
 	case arch.STBYTE:
-		v.memory.Write(uint32(targetAddress), 8, v.registers.Get(instruction.RegA))
+		return v.memory.Write(uint32(targetAddress), 8, v.registers.Get(instruction.RegA))
 	default:
-		panic("invalid M-Type opcode")
+		return fmt.Errorf("invalid M-Type opcode %s", instruction.Opcode)
 	}
 }
 
+// load reads size bits from memory at address into the register
+func (v *VirtualMachine) load(reg arch.RegisterValue, address uint32, size uint32) error {
+	val, err := v.memory.Read(address, size)
+	if err != nil {
+		return err
+	}
+	v.registers.Set(reg, val)
+	return nil
+}
+
 func (v *VirtualMachine) executeETypeInstruction(instruction arch.ETypeInstruction) {
 	switch instruction.Opcode {
 	case arch.MOVZ:
@@ -92,7 +109,7 @@ func (v *VirtualMachine) executeETypeInstruction(instruction arch.ETypeInstructi
 	}
 }
 
-func (v *VirtualMachine) executeBTypeInstruction(instruction arch.BTypeInstruction) {
+func (v *VirtualMachine) executeBTypeInstruction(instruction arch.BTypeInstruction) error {
 	destAddress := v.registers.Get(instruction.RegA)
 	switch instruction.Opcode {
 	case arch.BREG:
@@ -102,11 +119,12 @@ func (v *VirtualMachine) executeBTypeInstruction(instruction arch.BTypeInstructi
 		v.registers.Set(arch.ReturnRegister, uint64(nextPC))
 		v.programCounter = uint32(destAddress) - 4
 	default:
-		panic("invalid B-Type opcode")
+		return fmt.Errorf("invalid B-Type opcode %s", instruction.Opcode)
 	}
+	return nil
 }
 
-func (v *VirtualMachine) executeBTypeImmInstruction(instruction arch.BTypeImmInstruction) {
+func (v *VirtualMachine) executeBTypeImmInstruction(instruction arch.BTypeImmInstruction) error {
 	doBranch := false
 	doLink := false
 
@@ -129,7 +147,7 @@ func (v *VirtualMachine) executeBTypeImmInstruction(instruction arch.BTypeImmIns
 	case arch.B_GE:
 		doBranch = v.alu.GreaterThanEqual()
 	default:
-		panic("invalid BImm-Type opcode")
+		return fmt.Errorf("invalid BImm-Type opcode %s", instruction.Opcode)
 	}
 
 	if doLink {
@@ -140,32 +158,43 @@ func (v *VirtualMachine) executeBTypeImmInstruction(instruction arch.BTypeImmIns
 	if doBranch {
 		v.programCounter += (uint32(instruction.Offset) - 1) * 4
 	}
+	return nil
 }
 
-func (v *VirtualMachine) executeRTypeInstruction(instruction arch.RTypeInstruction) {
+func (v *VirtualMachine) executeRTypeInstruction(instruction arch.RTypeInstruction) error {
 	switch instruction.Opcode {
 	case arch.PUSH: // store register then decrement stack pointer
 		val := v.registers.Get(instruction.RegA)
 		sp := v.registers.Get(arch.StackRegister)
 		sp -= 8
+		if err := v.memory.Write(uint32(sp), 64, val); err != nil {
+			return err
+		}
 		v.registers.Set(arch.StackRegister, sp) // decrement by 8 bytes = 1 register
-		v.memory.Write(uint32(sp), 64, val)
 	case arch.POP:
 		sp := v.registers.Get(arch.StackRegister)
+		val, err := v.memory.Read(uint32(sp), 64)
+		if err != nil {
+			return err
+		}
 		v.registers.Set(arch.StackRegister, sp+8) // increment by 8 bytes = 1 register
-		v.registers.Set(instruction.RegA, v.memory.Read(uint32(sp), 64))
+		v.registers.Set(instruction.RegA, val)
 	default:
-		panic("invalid R-Type opcode")
+		return fmt.Errorf("invalid R-Type opcode %s", instruction.Opcode)
 	}
+	return nil
 }
 
-func (v *VirtualMachine) executeOTypeInstruction(instruction arch.OTypeInstruction) {
+func (v *VirtualMachine) executeOTypeInstruction(instruction arch.OTypeInstruction) error {
 	switch instruction.Opcode {
 	case arch.SYSCALL:
-		v.executeSyscall()
+		if err := v.executeSyscall(); err != nil {
+			return &syscallError{err: err}
+		}
 	case arch.NOOP:
-		return
+		return nil
 	case arch.HALT:
 		v.Halt()
 	}
+	return nil
 }
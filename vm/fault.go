@@ -0,0 +1,127 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/memory"
+)
+
+type FaultKind uint8
+
+const (
+	// FaultSegmentation is raised when accessing an address that is not mapped
+	FaultSegmentation FaultKind = iota
+	// FaultProtection is raised when the permissions of a mapped region do
+	// not allow the access, e.g. writing to code or executing the stack
+	FaultProtection
+	// FaultIllegalInstruction is raised when an instruction cannot be decoded
+	FaultIllegalInstruction
+	// FaultStackOverflow is raised when the stack grows into the guard
+	// region below it
+	FaultStackOverflow
+	// FaultStackUnderflow is raised when popping or reading past the top
+	// of the stack into the guard region above it
+	FaultStackUnderflow
+	// FaultSyscall is raised when a syscall cannot be completed, e.g. when a
+	// replayed syscall diverges from the recording
+	FaultSyscall
+)
+
+func (f FaultKind) String() string {
+	switch f {
+	case FaultSegmentation:
+		return "segmentation fault"
+	case FaultProtection:
+		return "protection fault"
+	case FaultIllegalInstruction:
+		return "illegal instruction"
+	case FaultStackOverflow:
+		return "stack overflow"
+	case FaultStackUnderflow:
+		return "stack underflow"
+	case FaultSyscall:
+		return "syscall failure"
+	default:
+		return "fault"
+	}
+}
+
+// Fault describes an error that stopped the VM while executing the
+// instruction at PC.
+type Fault struct {
+	Kind    FaultKind
+	PC      uint32
+	Address uint32
+	Err     error
+
+	// StackDepth is the number of bytes in use on the stack, and CallChain
+	// contains the addresses of the calls leading to PC, innermost first.
+	// Both are only set for stack overflow and underflow faults.
+	StackDepth int64
+	CallChain  []uint32
+}
+
+func (f *Fault) Error() string {
+	if f.Kind == FaultStackOverflow || f.Kind == FaultStackUnderflow {
+		return fmt.Sprintf("%s at pc 0x%08x (address 0x%08x, stack depth %d bytes)", f.Kind, f.PC, f.Address, f.StackDepth)
+	}
+	return fmt.Sprintf("%s at pc 0x%08x (address 0x%08x): %v", f.Kind, f.PC, f.Address, f.Err)
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// syscallError is an error that prevented a syscall from completing, as
+// opposed to one reported to the program through the error register
+type syscallError struct {
+	err error
+}
+
+func (e *syscallError) Error() string {
+	return e.err.Error()
+}
+
+func (e *syscallError) Unwrap() error {
+	return e.err
+}
+
+// faultFor converts an error raised while executing the instruction at pc
+// into a Fault.
+func faultFor(pc uint32, err error) *Fault {
+	var fault *Fault
+	if errors.As(err, &fault) {
+		return fault
+	}
+
+	var sysErr *syscallError
+	if errors.As(err, &sysErr) {
+		return &Fault{
+			Kind:    FaultSyscall,
+			PC:      pc,
+			Address: pc,
+			Err:     sysErr.err,
+		}
+	}
+
+	var accessErr *memory.AccessError
+	if errors.As(err, &accessErr) {
+		kind := FaultProtection
+		if !accessErr.Mapped {
+			kind = FaultSegmentation
+		}
+		return &Fault{
+			Kind:    kind,
+			PC:      pc,
+			Address: accessErr.Address,
+			Err:     err,
+		}
+	}
+
+	return &Fault{
+		Kind:    FaultIllegalInstruction,
+		PC:      pc,
+		Address: pc,
+		Err:     err,
+	}
+}
@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// runFault runs the program and returns the fault that stopped it
+func runFault(t *testing.T, source string) *Fault {
+	t.Helper()
+
+	v := newTestVM(t, source)
+	err := v.Run(context.Background(), RunOptions{MaxSteps: 10000})
+	var fault *Fault
+	require.True(t, errors.As(err, &fault), "expected a fault, got %v", err)
+	assert.True(t, v.Halted())
+	return fault
+}
+
+func TestFault_Protection(t *testing.T) {
+	// writing to code
+	fault := runFault(t, `
+	ADR r1, $target
+	STREG r1, [r1]
+$target:
+	HALT
+`)
+	assert.Equal(t, FaultProtection, fault.Kind)
+	assert.Equal(t, uint32(0x4), fault.PC)
+	assert.Equal(t, uint32(0x8), fault.Address)
+	var accessErr *memory.AccessError
+	require.True(t, errors.As(fault, &accessErr))
+	assert.Equal(t, memory.AccessWrite, accessErr.Kind)
+	assert.Equal(t, "protection fault at pc 0x00000004 (address 0x00000008): invalid write at address 0x00000008 (region is r-x)", fault.Error())
+
+	// executing data
+	fault = runFault(t, `
+	ADR r1, $data
+	BREG r1
+.section data
+$data:
+	.fill #0
+`)
+	assert.Equal(t, FaultProtection, fault.Kind)
+	assert.Equal(t, uint32(0x8), fault.PC)
+	assert.Equal(t, uint32(0x8), fault.Address)
+}
+
+func TestFault_Segmentation(t *testing.T) {
+	fault := runFault(t, `
+	MOVZ r1, #28672
+	LSL r1, #16
+	LDREG r2, [r1]
+`)
+	assert.Equal(t, FaultSegmentation, fault.Kind)
+	assert.Equal(t, uint32(0x8), fault.PC)
+	assert.Equal(t, uint32(0x70000000), fault.Address)
+
+	// running off the end of the program
+	fault = runFault(t, "\tNOOP\n")
+	assert.Equal(t, FaultSegmentation, fault.Kind)
+	assert.Equal(t, uint32(0x4), fault.PC)
+}
+
+func TestFault_IllegalInstruction(t *testing.T) {
+	fault := runFault(t, `
+	NOOP
+	.fill #838860800
+`)
+	assert.Equal(t, FaultIllegalInstruction, fault.Kind)
+	assert.Equal(t, uint32(0x4), fault.PC)
+	assert.EqualError(t, fault, "illegal instruction at pc 0x00000004 (address 0x00000004): invalid instruction 0x32000000")
+}
+
+func TestFault_Syscall(t *testing.T) {
+	v := newTestVM(t, `
+	MOVZ r9, #14
+	SYSCALL
+	HALT
+`)
+	v.ReplaySyscalls(&SyscallLog{})
+	err := v.Run(context.Background(), RunOptions{MaxSteps: 10})
+	var fault *Fault
+	require.True(t, errors.As(err, &fault), "expected a fault, got %v", err)
+	assert.Equal(t, FaultSyscall, fault.Kind)
+	assert.Equal(t, uint32(0x4), fault.PC)
+	assert.ErrorIs(t, fault, ErrReplayDiverged)
+	assert.EqualError(t, fault, "syscall failure at pc 0x00000004 (address 0x00000004): syscall 14 made after end of recording: replay diverged from recording")
+}
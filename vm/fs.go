@@ -0,0 +1,520 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Flags accepted by the open syscall, which may be combined with a bitwise OR
+const (
+	OpenReadOnly  = 0x0
+	OpenWriteOnly = 0x1
+	OpenReadWrite = 0x2
+	OpenCreate    = 0x40
+	OpenExclusive = 0x80
+	OpenTruncate  = 0x200
+	OpenAppend    = 0x400
+
+	openAccessMask = 0x3
+
+	// maxPathLength is the longest path, including the null terminator, that
+	// is read from memory
+	maxPathLength = 4096
+
+	// statModeDirectory is set in the mode reported by fstat for directories
+	statModeDirectory = 0x4000
+)
+
+var (
+	ErrNoFileSystem = fmt.Errorf("no file system available")
+	ErrPathEscape   = fmt.Errorf("path escapes file system root")
+)
+
+// FileSystem is the interface through which programs access files. It is
+// modelled after fs.FS, but files can also be created, written and removed.
+//
+// Names are slash-separated paths relative to the root of the FileSystem.
+type FileSystem interface {
+	OpenFile(name string, flags int) (File, error)
+	Remove(name string) error
+}
+
+// File is a file opened through a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// fileDescriptor is an entry in the VM's table of open files
+type fileDescriptor struct {
+	rw    io.ReadWriter
+	name  string // name within the FileSystem, empty for standard streams
+	flags int
+
+	// pending receives the result of a read from a standard stream that
+	// was interrupted, and unread holds the data it returned that did not
+	// fit into the buffer of the read that received it
+	pending chan streamRead
+	unread  []byte
+}
+
+// streamRead is the result of a read from a standard stream
+type streamRead struct {
+	data []byte
+	err  error
+}
+
+// standardFileDescriptors returns a table containing only the standard
+// input, output and error streams
+func (v *VirtualMachine) standardFileDescriptors() map[int]*fileDescriptor {
+	return map[int]*fileDescriptor{
+		0: {rw: v.stdio[0], flags: OpenReadOnly},
+		1: {rw: v.stdio[1], flags: OpenWriteOnly},
+		2: {rw: v.stdio[2], flags: OpenWriteOnly},
+	}
+}
+
+// SetStandardStreams replaces the standard input, output and error streams
+// of the program, which are those of the host process by default.
+func (v *VirtualMachine) SetStandardStreams(stdin io.Reader, stdout io.Writer, stderr io.Writer) {
+	v.stdio = [3]io.ReadWriter{
+		readOnlyStream{stdin},
+		writeOnlyStream{stdout},
+		writeOnlyStream{stderr},
+	}
+	for fd, desc := range v.standardFileDescriptors() {
+		if open, ok := v.fds[fd]; ok && open.name == "" {
+			v.fds[fd] = desc
+		}
+	}
+}
+
+// readOnlyStream is a standard input stream that cannot be written
+type readOnlyStream struct {
+	io.Reader
+}
+
+func (readOnlyStream) Write([]byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+// writeOnlyStream is a standard output stream that cannot be read
+type writeOnlyStream struct {
+	io.Writer
+}
+
+func (writeOnlyStream) Read([]byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+// SetFileSystem sets the FileSystem that the file syscalls operate on.
+// Without one, opening or removing files fails with a permission error.
+func (v *VirtualMachine) SetFileSystem(fileSystem FileSystem) {
+	v.fileSystem = fileSystem
+}
+
+// dirFS is a FileSystem backed by a host directory
+type dirFS struct {
+	root string
+}
+
+// DirFS returns a FileSystem sandboxed to the host directory dir. Paths
+// cannot refer to files outside the directory, including through symlinks.
+func DirFS(dir string) (FileSystem, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("sandbox %s is not a directory", dir)
+	}
+
+	return &dirFS{root: root}, nil
+}
+
+// maxSymlinks is the number of symlinks that resolve follows before giving up
+const maxSymlinks = 40
+
+// resolve returns the host path for name, making sure that neither the
+// path itself nor any symlink along it leaves the root directory. Symlinks
+// are resolved one component at a time, so the returned path contains none,
+// except for the final component when follow is false.
+func (d *dirFS) resolve(name string, follow bool) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("invalid path %q: %w", name, fs.ErrInvalid)
+	}
+
+	// cleaning a rooted path removes any leading ".." elements
+	components := strings.Split(path.Clean("/"+name), "/")
+	resolved := d.root
+	links := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			// only symlink targets can still contain ".."
+			if resolved == d.root {
+				return "", fmt.Errorf("%s: %w", name, ErrPathEscape)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, component)
+		info, err := os.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			// the file might be about to be created, but only the final
+			// component can be missing: joining the rest lexically would
+			// skip over any symlinks reached through ".."
+			for _, rest := range components {
+				if rest != "" && rest != "." {
+					return "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+				}
+			}
+			return next, nil
+		} else if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 || (!follow && len(components) == 0) {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links: %w", name, fs.ErrInvalid)
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			target = filepath.Clean(target)
+			if !d.contains(target) {
+				return "", fmt.Errorf("%s: %w", name, ErrPathEscape)
+			}
+			rel, err := filepath.Rel(d.root, target)
+			if err != nil {
+				return "", err
+			}
+			resolved, target = d.root, rel
+		}
+		components = append(strings.Split(filepath.ToSlash(target), "/"), components...)
+	}
+
+	return resolved, nil
+}
+
+func (d *dirFS) contains(hostPath string) bool {
+	rel, err := filepath.Rel(d.root, hostPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (d *dirFS) OpenFile(name string, flags int) (File, error) {
+	hostPath, err := d.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openResolved(hostPath, hostOpenFlags(flags))
+	if errors.Is(err, ErrPathEscape) {
+		return nil, fmt.Errorf("%s: %w", name, ErrPathEscape)
+	}
+	return file, err
+}
+
+// openResolved opens a path returned by resolve. A symlink may have replaced
+// the file since it was resolved, so files are never created or truncated
+// through a symlink and the opened file must be the one at hostPath.
+func openResolved(hostPath string, flags int) (*os.File, error) {
+	truncate := flags&os.O_TRUNC != 0
+	flags &^= os.O_TRUNC
+
+	if flags&os.O_CREATE != 0 {
+		// O_EXCL refuses to follow a symlink, even a dangling one
+		file, err := os.OpenFile(hostPath, flags|os.O_EXCL, 0644)
+		if err == nil || flags&os.O_EXCL != 0 || !errors.Is(err, fs.ErrExist) {
+			return file, err
+		}
+		flags &^= os.O_CREATE
+	}
+
+	file, err := os.OpenFile(hostPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	opened, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	current, err := os.Lstat(hostPath)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if current.Mode()&fs.ModeSymlink != 0 || !os.SameFile(opened, current) {
+		_ = file.Close()
+		return nil, ErrPathEscape
+	}
+
+	if truncate {
+		if err := file.Truncate(0); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (d *dirFS) Remove(name string) error {
+	// like unlink, removing a symlink removes the link rather than its target
+	hostPath, err := d.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	if hostPath == d.root {
+		return fmt.Errorf("remove %s: %w", name, fs.ErrPermission)
+	}
+	return os.Remove(hostPath)
+}
+
+// hostOpenFlags converts open syscall flags to os.OpenFile flags
+func hostOpenFlags(flags int) int {
+	var res int
+	switch flags & openAccessMask {
+	case OpenWriteOnly:
+		res = os.O_WRONLY
+	case OpenReadWrite:
+		res = os.O_RDWR
+	default:
+		res = os.O_RDONLY
+	}
+
+	if flags&OpenCreate != 0 {
+		res |= os.O_CREATE
+	}
+	if flags&OpenExclusive != 0 {
+		res |= os.O_EXCL
+	}
+	if flags&OpenTruncate != 0 {
+		res |= os.O_TRUNC
+	}
+	if flags&OpenAppend != 0 {
+		res |= os.O_APPEND
+	}
+	return res
+}
+
+// readString reads a null-terminated string from memory
+func (v *VirtualMachine) readString(address uint32) (string, error) {
+	var builder strings.Builder
+	for i := uint32(0); i < maxPathLength; i++ {
+		b, err := v.memory.Read(address+i, 8)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return builder.String(), nil
+		}
+		builder.WriteByte(byte(b))
+	}
+	return "", fmt.Errorf("string at 0x%08x is too long: %w", address, ErrInvalidArgument)
+}
+
+// allocateFileDescriptor returns the lowest unused file descriptor
+func (v *VirtualMachine) allocateFileDescriptor() int {
+	fd := 0
+	for {
+		if _, ok := v.fds[fd]; !ok {
+			return fd
+		}
+		fd++
+	}
+}
+
+// syscallOpen opens a file
+//
+// Semantics: open(const char *path, int flags)
+// path:  register 1
+// flags: register 2
+//
+// Opens the file at path within the file system and returns a new file
+// descriptor for it.
+func (v *VirtualMachine) syscallOpen() error {
+	fd, err := v.syscallOpenExecute(uint32(v.registers.Get(1)), int(v.registers.Get(2)))
+	if err != nil {
+		return err
+	}
+	v.setSyscallResult(uint64(fd))
+	return nil
+}
+
+func (v *VirtualMachine) syscallOpenExecute(pathPtr uint32, flags int) (int, error) {
+	name, err := v.readString(pathPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	if v.fileSystem == nil {
+		return 0, fmt.Errorf("open %s: %w", name, ErrNoFileSystem)
+	}
+
+	file, err := v.fileSystem.OpenFile(name, flags)
+	if err != nil {
+		return 0, err
+	}
+
+	fd := v.allocateFileDescriptor()
+	v.fds[fd] = &fileDescriptor{
+		rw:    file,
+		name:  name,
+		flags: flags,
+	}
+	return fd, nil
+}
+
+// syscallClose closes a file descriptor
+//
+// Semantics: close(int fileD)
+// fileD: register 1
+func (v *VirtualMachine) syscallClose() error {
+	fileD := int(v.registers.Get(1))
+	desc, ok := v.fds[fileD]
+	if !ok {
+		return ErrInvalidFileDescriptor
+	}
+
+	delete(v.fds, fileD)
+	if desc.name != "" {
+		// only close files opened by the program, not the standard streams
+		if closer, ok := desc.rw.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	v.setSyscallResult(0)
+	return nil
+}
+
+// syscallLseek moves the offset of a file descriptor
+//
+// Semantics: lseek(int fileD, off_t offset, int whence)
+// fileD:  register 1
+// offset: register 2
+// whence: register 3 (0 = start, 1 = current, 2 = end)
+//
+// Returns the resulting offset from the start of the file.
+func (v *VirtualMachine) syscallLseek() error {
+	fileD := int(v.registers.Get(1))
+	offset := int64(v.registers.Get(2))
+	whence := int(v.registers.Get(3))
+
+	desc, ok := v.fds[fileD]
+	if !ok {
+		return ErrInvalidFileDescriptor
+	}
+
+	seeker, ok := desc.rw.(io.Seeker)
+	if !ok || whence < io.SeekStart || whence > io.SeekEnd {
+		return fmt.Errorf("lseek fd %d: %w", fileD, ErrInvalidArgument)
+	}
+
+	res, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return err
+	}
+	v.setSyscallResult(uint64(res))
+	return nil
+}
+
+// syscallFstat describes an open file
+//
+// Semantics: fstat(int fileD, struct stat *buf)
+// fileD: register 1
+// buf:   register 2
+//
+// Writes the following 24 byte structure to buf:
+//   - offset 0:  size in bytes
+//   - offset 8:  mode (permission bits, 0x4000 set for directories)
+//   - offset 16: modification time in seconds since the Unix epoch
+func (v *VirtualMachine) syscallFstat() error {
+	fileD := int(v.registers.Get(1))
+	bufPtr := uint32(v.registers.Get(2))
+
+	desc, ok := v.fds[fileD]
+	if !ok {
+		return ErrInvalidFileDescriptor
+	}
+
+	stater, ok := desc.rw.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		return fmt.Errorf("fstat fd %d: %w", fileD, ErrInvalidArgument)
+	}
+
+	info, err := stater.Stat()
+	if err != nil {
+		return err
+	}
+
+	mode := uint64(info.Mode().Perm())
+	if info.IsDir() {
+		mode |= statModeDirectory
+	}
+
+	fields := []uint64{uint64(info.Size()), mode, uint64(info.ModTime().Unix())}
+	for i, field := range fields {
+		if err := v.memory.Write(bufPtr+uint32(i*8), 64, field); err != nil {
+			return err
+		}
+	}
+	v.setSyscallResult(0)
+	return nil
+}
+
+// syscallUnlink removes a file
+//
+// Semantics: unlink(const char *path)
+// path: register 1
+func (v *VirtualMachine) syscallUnlink() error {
+	name, err := v.readString(uint32(v.registers.Get(1)))
+	if err != nil {
+		return err
+	}
+
+	if v.fileSystem == nil {
+		return fmt.Errorf("unlink %s: %w", name, ErrNoFileSystem)
+	}
+
+	if err := v.fileSystem.Remove(name); err != nil {
+		return err
+	}
+	v.setSyscallResult(0)
+	return nil
+}
@@ -0,0 +1,168 @@
+package vm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newSandbox returns a dirFS over a temporary directory containing a.txt and
+// sub/b.txt, next to a directory outside of it containing secret.txt
+func newSandbox(t *testing.T) (*dirFS, string) {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0755))
+	require.NoError(t, os.Mkdir(outside, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("b"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+
+	fileSystem, err := DirFS(root)
+	require.NoError(t, err)
+	return fileSystem.(*dirFS), outside
+}
+
+// symlink creates a symlink at name within the sandbox, skipping the test on
+// hosts that do not support them
+func symlink(t *testing.T, d *dirFS, target string, name string) {
+	t.Helper()
+
+	if err := os.Symlink(target, filepath.Join(d.root, name)); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
+
+func readFile(t *testing.T, d *dirFS, name string) string {
+	t.Helper()
+
+	file, err := d.OpenFile(name, OpenReadOnly)
+	require.NoError(t, err)
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestDirFS_Paths(t *testing.T) {
+	d, _ := newSandbox(t)
+
+	// paths are rooted at the sandbox, so ".." cannot climb above it
+	assert.Equal(t, "a", readFile(t, d, "a.txt"))
+	assert.Equal(t, "a", readFile(t, d, "/a.txt"))
+	assert.Equal(t, "a", readFile(t, d, "../a.txt"))
+	assert.Equal(t, "a", readFile(t, d, "sub/../../a.txt"))
+	assert.Equal(t, "b", readFile(t, d, "./sub/b.txt"))
+
+	_, err := d.OpenFile("", OpenReadOnly)
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = d.OpenFile("a.txt\x00", OpenReadOnly)
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = d.OpenFile("missing.txt", OpenReadOnly)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.ErrorIs(t, d.Remove("/"), fs.ErrPermission)
+	assert.ErrorIs(t, d.Remove(".."), fs.ErrPermission)
+}
+
+func TestDirFS_Symlinks(t *testing.T) {
+	d, outside := newSandbox(t)
+	symlink(t, d, "sub/b.txt", "relative")
+	symlink(t, d, filepath.Join(d.root, "a.txt"), "absolute")
+	symlink(t, d, "../a.txt", "sub/parent")
+	symlink(t, d, "sub", "dir")
+	symlink(t, d, filepath.Join(outside, "secret.txt"), "secret")
+	symlink(t, d, "../outside", "escape")
+	symlink(t, d, "loop", "loop")
+
+	assert.Equal(t, "b", readFile(t, d, "relative"))
+	assert.Equal(t, "a", readFile(t, d, "absolute"))
+	assert.Equal(t, "a", readFile(t, d, "sub/parent"))
+	assert.Equal(t, "b", readFile(t, d, "dir/b.txt"))
+
+	for _, name := range []string{"secret", "escape/secret.txt", "escape/new.txt"} {
+		_, err := d.OpenFile(name, OpenReadWrite|OpenCreate|OpenTruncate)
+		assert.ErrorIs(t, err, ErrPathEscape, name)
+	}
+	assert.ErrorIs(t, d.Remove("escape/secret.txt"), ErrPathEscape)
+	contents, err := os.ReadFile(filepath.Join(outside, "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(contents))
+	_, err = os.Stat(filepath.Join(outside, "new.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = d.OpenFile("loop", OpenReadOnly)
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+
+	// removing a symlink removes the link, not its target
+	require.NoError(t, d.Remove("secret"))
+	require.NoError(t, d.Remove("relative"))
+	_, err = os.Lstat(filepath.Join(d.root, "secret"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.FileExists(t, filepath.Join(outside, "secret.txt"))
+	assert.FileExists(t, filepath.Join(d.root, "sub", "b.txt"))
+}
+
+func TestDirFS_DanglingSymlinks(t *testing.T) {
+	d, outside := newSandbox(t)
+	symlink(t, d, "sub/new.txt", "inside")
+	symlink(t, d, filepath.Join(outside, "new.txt"), "outside")
+
+	// creating through a dangling symlink creates its target
+	file, err := d.OpenFile("inside", OpenWriteOnly|OpenCreate)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.FileExists(t, filepath.Join(d.root, "sub", "new.txt"))
+
+	_, err = d.OpenFile("outside", OpenWriteOnly|OpenCreate)
+	assert.ErrorIs(t, err, ErrPathEscape)
+	_, err = os.Stat(filepath.Join(outside, "new.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDirFS_MissingComponents(t *testing.T) {
+	d, outside := newSandbox(t)
+	symlink(t, d, "../outside", "escape")
+	symlink(t, d, "missing/../escape/new.txt", "link")
+
+	// a missing directory cannot be climbed out of into a symlink
+	for _, name := range []string{"link", "missing/new.txt"} {
+		_, err := d.OpenFile(name, OpenWriteOnly|OpenCreate)
+		assert.ErrorIs(t, err, fs.ErrNotExist, name)
+	}
+	_, err := os.Stat(filepath.Join(outside, "new.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestOpenResolved(t *testing.T) {
+	d, outside := newSandbox(t)
+
+	// symlinks that appear after resolving are not followed
+	symlink(t, d, filepath.Join(outside, "secret.txt"), "swapped")
+	_, err := openResolved(filepath.Join(d.root, "swapped"), os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	assert.ErrorIs(t, err, ErrPathEscape)
+	contents, err := os.ReadFile(filepath.Join(outside, "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(contents))
+
+	symlink(t, d, filepath.Join(outside, "new.txt"), "dangling")
+	_, err = openResolved(filepath.Join(d.root, "dangling"), os.O_WRONLY|os.O_CREATE)
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(outside, "new.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// regular files are still truncated
+	file, err := openResolved(filepath.Join(d.root, "a.txt"), os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	require.NoError(t, err)
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+	require.NoError(t, file.Close())
+}
@@ -0,0 +1,120 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// interruptByte is sent by the client to stop a running program
+	interruptByte = 0x03
+)
+
+// eventKind is the kind of data received from the client
+type eventKind uint8
+
+const (
+	eventPacket eventKind = iota
+	// eventInvalidPacket is a packet with an invalid checksum
+	eventInvalidPacket
+	eventInterrupt
+	eventError
+)
+
+// event is a packet or interrupt received from the client
+type event struct {
+	kind   eventKind
+	packet string
+	err    error
+}
+
+// packetReader splits the data sent by the client into packets and
+// interrupts. Acknowledgements are discarded.
+type packetReader struct {
+	r *bufio.Reader
+}
+
+func newPacketReader(r io.Reader) *packetReader {
+	return &packetReader{r: bufio.NewReader(r)}
+}
+
+// next reads the next packet or interrupt
+func (p *packetReader) next() event {
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return event{kind: eventError, err: err}
+		}
+
+		switch b {
+		case interruptByte:
+			return event{kind: eventInterrupt}
+		case '$':
+			return p.readPacket()
+		default:
+			// acknowledgements and stray bytes between packets
+			continue
+		}
+	}
+}
+
+func (p *packetReader) readPacket() event {
+	var data []byte
+	var sum uint8
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return event{kind: eventError, err: err}
+		}
+
+		if b == '#' {
+			break
+		}
+		sum += b
+		if b == '}' {
+			// escaped byte
+			escaped, err := p.r.ReadByte()
+			if err != nil {
+				return event{kind: eventError, err: err}
+			}
+			sum += escaped
+			b = escaped ^ 0x20
+		}
+		data = append(data, b)
+	}
+
+	var checksum [2]byte
+	if _, err := io.ReadFull(p.r, checksum[:]); err != nil {
+		return event{kind: eventError, err: err}
+	}
+	expected, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+	if err != nil || uint8(expected) != sum {
+		return event{kind: eventInvalidPacket}
+	}
+	return event{kind: eventPacket, packet: string(data)}
+}
+
+// writePacket writes data as a packet, escaping special characters
+func writePacket(w io.Writer, data string) error {
+	buf := make([]byte, 0, len(data)+4)
+	buf = append(buf, '$')
+
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch b {
+		case '$', '#', '}', '*':
+			buf = append(buf, '}')
+			sum += '}'
+			b ^= 0x20
+		}
+		buf = append(buf, b)
+		sum += b
+	}
+
+	buf = append(buf, []byte(fmt.Sprintf("#%02x", sum))...)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,505 @@
+// Package gdb implements a stub for the GDB Remote Serial Protocol, which
+// allows debugging programs running in the VM with GDB and other debuggers
+// that speak the protocol.
+package gdb
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/vm"
+	"github.com/dnsge/orange/vm/debug"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// pcRegister is the number of the program counter in the target
+	// description, following the 16 general purpose registers
+	pcRegister   = arch.RegisterCount
+	numRegisters = arch.RegisterCount + 1
+
+	// maxPacketSize is the largest packet accepted from the client
+	maxPacketSize = 0x4000
+	// maxMemoryRead is the largest memory read, which fits in a packet as hex
+	maxMemoryRead = maxPacketSize / 2
+
+	// signals reported in stop replies
+	signalInterrupt = 2
+	signalIllegal   = 4
+	signalTrap      = 5
+	signalSegv      = 11
+	signalSys       = 12
+)
+
+// ErrKilled is returned by Serve when the client killed the program
+var ErrKilled = fmt.Errorf("program killed by debugger")
+
+// Server debugs the program of a debug.Session over the GDB Remote Serial
+// Protocol.
+type Server struct {
+	session *debug.Session
+
+	conn     io.Writer
+	events   chan event
+	noAck    bool
+	lastStop string
+}
+
+// NewServer creates a Server for the session
+func NewServer(session *debug.Session) *Server {
+	return &Server{
+		session:  session,
+		lastStop: fmt.Sprintf("S%02x", signalTrap),
+	}
+}
+
+// Serve handles a single client connection until the client detaches,
+// kills the program or disconnects. It returns nil if the client detached
+// or disconnected, ErrKilled if the program was killed and any other error
+// if communication failed.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	s.conn = conn
+	s.events = make(chan event, 16)
+	go s.readEvents(conn)
+
+	for {
+		e := <-s.events
+		switch e.kind {
+		case eventError:
+			if errors.Is(e.err, io.EOF) {
+				return nil
+			}
+			return e.err
+		case eventInterrupt:
+			// the program is not running, so there is nothing to stop
+			continue
+		case eventInvalidPacket:
+			// ask the client to send the packet again
+			if err := s.acknowledge('-'); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.acknowledge('+'); err != nil {
+			return err
+		}
+
+		reply, err := s.handle(e.packet)
+		if errors.Is(err, errDetach) {
+			return writePacket(conn, "OK")
+		} else if err != nil {
+			return err
+		}
+
+		if err := writePacket(conn, reply); err != nil {
+			return err
+		}
+		if e.packet == "QStartNoAckMode" {
+			s.noAck = true
+		}
+	}
+}
+
+// errDetach is returned by handle when the client detaches
+var errDetach = fmt.Errorf("detach")
+
+// errInvalidPacket is the reply to malformed packets
+const errInvalidPacket = "E01"
+
+// readEvents reads packets and interrupts from the client until the
+// connection fails
+func (s *Server) readEvents(r io.Reader) {
+	reader := newPacketReader(r)
+	for {
+		e := reader.next()
+		s.events <- e
+		if e.kind == eventError {
+			return
+		}
+	}
+}
+
+// acknowledge sends an acknowledgement unless the client disabled them
+func (s *Server) acknowledge(ack byte) error {
+	if s.noAck {
+		return nil
+	}
+	_, err := s.conn.Write([]byte{ack})
+	return err
+}
+
+// handle executes a packet and returns the reply
+func (s *Server) handle(packet string) (string, error) {
+	switch {
+	case packet == "?":
+		return s.lastStop, nil
+	case packet == "g":
+		return s.readRegisters(), nil
+	case strings.HasPrefix(packet, "G"):
+		return s.writeRegisters(packet[1:]), nil
+	case strings.HasPrefix(packet, "p"):
+		return s.readRegister(packet[1:]), nil
+	case strings.HasPrefix(packet, "P"):
+		return s.writeRegister(packet[1:]), nil
+	case strings.HasPrefix(packet, "m"):
+		return s.readMemory(packet[1:]), nil
+	case strings.HasPrefix(packet, "M"):
+		return s.writeMemory(packet[1:]), nil
+	case strings.HasPrefix(packet, "Z"):
+		return s.setBreakpoint(packet[1:], true), nil
+	case strings.HasPrefix(packet, "z"):
+		return s.setBreakpoint(packet[1:], false), nil
+	case strings.HasPrefix(packet, "s"):
+		if !s.resumeAt(packet[1:]) {
+			return errInvalidPacket, nil
+		}
+		return s.stopped(s.session.Step()), nil
+	case strings.HasPrefix(packet, "c"):
+		if !s.resumeAt(packet[1:]) {
+			return errInvalidPacket, nil
+		}
+		return s.run(s.session.Continue)
+	case packet == "bs":
+		return s.stopped(s.session.ReverseStep()), nil
+	case packet == "bc":
+		return s.run(s.session.ReverseContinue)
+	case packet == "k":
+		return "", ErrKilled
+	case packet == "D" || strings.HasPrefix(packet, "D;"):
+		return "", errDetach
+	default:
+		return s.handleQuery(packet), nil
+	}
+}
+
+// handleQuery answers general queries and other packets that do not affect
+// the program
+func (s *Server) handleQuery(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+;ReverseStep+;ReverseContinue+", maxPacketSize)
+	case strings.HasPrefix(packet, "qXfer:features:read:"):
+		return readTargetDescription(strings.TrimPrefix(packet, "qXfer:features:read:"))
+	case packet == "QStartNoAckMode":
+		return "OK"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(packet, "qSymbol"):
+		return "OK"
+	case strings.HasPrefix(packet, "H"):
+		// there is only a single thread
+		return "OK"
+	default:
+		// unsupported packets get an empty reply
+		return ""
+	}
+}
+
+// resumeAt handles the optional address of step and continue packets
+func (s *Server) resumeAt(address string) bool {
+	if address == "" {
+		return true
+	}
+	pc, err := strconv.ParseUint(address, 16, 32)
+	if err != nil {
+		return false
+	}
+	s.session.VM.SetPC(uint32(pc))
+	return true
+}
+
+// run executes the program until it stops, stopping early if the client
+// sends an interrupt
+func (s *Server) run(resume func(ctx context.Context) *debug.Stop) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan *debug.Stop, 1)
+	go func() {
+		done <- resume(ctx)
+	}()
+
+	var connErr error
+	for {
+		select {
+		case stop := <-done:
+			if connErr != nil {
+				return "", connErr
+			}
+			return s.stopped(stop), nil
+		case e := <-s.events:
+			switch e.kind {
+			case eventInterrupt:
+				cancel()
+			case eventError:
+				connErr = e.err
+				if errors.Is(connErr, io.EOF) {
+					connErr = io.ErrUnexpectedEOF
+				}
+				cancel()
+			}
+			// other packets are not allowed while the program runs
+		}
+	}
+}
+
+// stopped returns the stop reply for a stop and remembers it for "?"
+func (s *Server) stopped(stop *debug.Stop) string {
+	var reply string
+	switch stop.Reason {
+	case debug.StopHalted:
+		reply = fmt.Sprintf("W%02x", uint8(s.session.VM.ExitCode()))
+	case debug.StopFault:
+		reply = fmt.Sprintf("S%02x", faultSignal(stop.Fault))
+	case debug.StopWatchpoint:
+		reply = fmt.Sprintf("T%02xwatch:%x;", signalTrap, stop.Watchpoint.Address)
+	case debug.StopHistoryStart:
+		reply = fmt.Sprintf("T%02xreplaylog:begin;", signalTrap)
+	case debug.StopInterrupted:
+		reply = fmt.Sprintf("S%02x", signalInterrupt)
+	default:
+		reply = fmt.Sprintf("S%02x", signalTrap)
+	}
+	s.lastStop = reply
+	return reply
+}
+
+// faultSignal returns the signal reported for a fault
+func faultSignal(err error) int {
+	var fault *vm.Fault
+	if !errors.As(err, &fault) {
+		return signalSegv
+	}
+
+	switch fault.Kind {
+	case vm.FaultIllegalInstruction:
+		return signalIllegal
+	case vm.FaultSyscall:
+		return signalSys
+	default:
+		return signalSegv
+	}
+}
+
+// registerSize returns the size of a register in bytes
+func registerSize(n int) int {
+	if n == pcRegister {
+		return 4
+	}
+	return 8
+}
+
+func (s *Server) getRegister(n int) uint64 {
+	if n == pcRegister {
+		return uint64(s.session.VM.PC())
+	}
+	return s.session.VM.Register(arch.RegisterValue(n))
+}
+
+func (s *Server) setRegister(n int, val uint64) {
+	if n == pcRegister {
+		s.session.VM.SetPC(uint32(val))
+		return
+	}
+	s.session.VM.SetRegister(arch.RegisterValue(n), val)
+}
+
+// encodeRegister encodes the value of a register as target-endian hex
+func encodeRegister(n int, val uint64) string {
+	var buf [8]byte
+	arch.ByteOrder.PutUint64(buf[:], val)
+	return hex.EncodeToString(buf[:registerSize(n)])
+}
+
+func decodeRegister(n int, data string) (uint64, bool) {
+	raw, err := hex.DecodeString(data)
+	if err != nil || len(raw) != registerSize(n) {
+		return 0, false
+	}
+	var buf [8]byte
+	copy(buf[:], raw)
+	return arch.ByteOrder.Uint64(buf[:]), true
+}
+
+func (s *Server) readRegisters() string {
+	var builder strings.Builder
+	for n := 0; n < numRegisters; n++ {
+		builder.WriteString(encodeRegister(n, s.getRegister(n)))
+	}
+	return builder.String()
+}
+
+func (s *Server) writeRegisters(data string) string {
+	values := make([]uint64, numRegisters)
+	for n := range values {
+		size := registerSize(n) * 2
+		if len(data) < size {
+			return errInvalidPacket
+		}
+		val, ok := decodeRegister(n, data[:size])
+		if !ok {
+			return errInvalidPacket
+		}
+		values[n] = val
+		data = data[size:]
+	}
+
+	for n, val := range values {
+		s.setRegister(n, val)
+	}
+	return "OK"
+}
+
+// parseRegisterNumber parses a hex register number
+func parseRegisterNumber(data string) (int, bool) {
+	n, err := strconv.ParseUint(data, 16, 8)
+	if err != nil || n >= numRegisters {
+		return 0, false
+	}
+	return int(n), true
+}
+
+func (s *Server) readRegister(data string) string {
+	n, ok := parseRegisterNumber(data)
+	if !ok {
+		return errInvalidPacket
+	}
+	return encodeRegister(n, s.getRegister(n))
+}
+
+func (s *Server) writeRegister(data string) string {
+	parts := strings.SplitN(data, "=", 2)
+	if len(parts) != 2 {
+		return errInvalidPacket
+	}
+	n, ok := parseRegisterNumber(parts[0])
+	if !ok {
+		return errInvalidPacket
+	}
+	val, ok := decodeRegister(n, parts[1])
+	if !ok {
+		return errInvalidPacket
+	}
+	s.setRegister(n, val)
+	return "OK"
+}
+
+// parseAddressLength parses the "addr,length" arguments of memory packets
+func parseAddressLength(data string) (uint32, uint32, bool) {
+	parts := strings.SplitN(data, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	address, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(address), uint32(length), true
+}
+
+// errMemory is the reply to memory accesses at unmapped addresses
+const errMemory = "E14"
+
+func (s *Server) readMemory(data string) string {
+	address, length, ok := parseAddressLength(data)
+	if !ok {
+		return errInvalidPacket
+	}
+	if length > maxMemoryRead {
+		length = maxMemoryRead
+	}
+
+	buf := make([]byte, 0, length)
+	for i := uint32(0); i < length; i++ {
+		b, err := s.session.VM.Peek(address+i, 8)
+		if err != nil {
+			break
+		}
+		buf = append(buf, byte(b))
+	}
+
+	if len(buf) == 0 && length > 0 {
+		return errMemory
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (s *Server) writeMemory(data string) string {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return errInvalidPacket
+	}
+	address, length, ok := parseAddressLength(parts[0])
+	if !ok {
+		return errInvalidPacket
+	}
+	raw, err := hex.DecodeString(parts[1])
+	if err != nil || uint32(len(raw)) != length {
+		return errInvalidPacket
+	}
+
+	mem := s.session.VM.Memory()
+	for i, b := range raw {
+		if err := mem.Write(address+uint32(i), 8, uint64(b)); err != nil {
+			return errMemory
+		}
+	}
+	return "OK"
+}
+
+// setBreakpoint handles the "type,addr,kind" arguments of Z and z packets
+func (s *Server) setBreakpoint(data string, insert bool) string {
+	parts := strings.SplitN(data, ",", 3)
+	if len(parts) != 3 {
+		return errInvalidPacket
+	}
+	address, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return errInvalidPacket
+	}
+	kind, err := strconv.ParseUint(parts[2], 16, 32)
+	if err != nil {
+		return errInvalidPacket
+	}
+
+	switch parts[0] {
+	case "0", "1":
+		// software and hardware breakpoints behave the same
+		if insert {
+			s.session.AddBreakpoint(uint32(address))
+		} else {
+			s.session.RemoveBreakpoint(uint32(address))
+		}
+		return "OK"
+	case "2":
+		// write watchpoint, where kind is the length in bytes
+		switch kind {
+		case 1, 2, 4, 8:
+		default:
+			return errInvalidPacket
+		}
+		if insert {
+			s.session.AddWatchpoint(uint32(address), uint32(kind)*8)
+		} else {
+			s.session.RemoveWatchpoint(uint32(address), uint32(kind)*8)
+		}
+		return "OK"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,182 @@
+package gdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"github.com/dnsge/orange/vm/debug"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+)
+
+const counterProgram = `
+	MOVZ r1, #0
+	ADR r2, $counter
+$loop:
+	ADDI r1, #1
+	STREG r1, [r2]
+	CMPI r1, #3
+	B.NEQ $loop
+	HALT
+
+.section data
+
+$counter:	.fill #0
+`
+
+const infiniteProgram = `
+$loop:
+	B $loop
+`
+
+func loadSession(t *testing.T, source string) *debug.Session {
+	var out bytes.Buffer
+	require.NoError(t, asm.AssembleExecutable(strings.NewReader(source), &out))
+
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+	return debug.NewSession(vm.NewVirtualMachine(mem, true), 0)
+}
+
+// client is a scripted GDB client
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *packetReader
+	served chan error
+}
+
+func startServer(t *testing.T, session *debug.Session) *client {
+	serverConn, clientConn := net.Pipe()
+	c := &client{
+		t:      t,
+		conn:   clientConn,
+		reader: newPacketReader(clientConn),
+		served: make(chan error, 1),
+	}
+	go func() {
+		c.served <- NewServer(session).Serve(serverConn)
+	}()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+	return c
+}
+
+// request sends a packet and returns the reply
+func (c *client) request(packet string) string {
+	require.NoError(c.t, writePacket(c.conn, packet))
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	e := c.reader.next()
+	require.Equal(c.t, eventPacket, e.kind, "reply error: %v", e.err)
+	_, err := c.conn.Write([]byte{'+'})
+	require.NoError(c.t, err)
+	return e.packet
+}
+
+func TestServer_Queries(t *testing.T) {
+	c := startServer(t, loadSession(t, counterProgram))
+
+	assert.Contains(t, c.request("qSupported:swbreak+"), "qXfer:features:read+")
+	assert.Equal(t, "S05", c.request("?"))
+
+	xml := c.request("qXfer:features:read:target.xml:0,3fff")
+	require.True(t, strings.HasPrefix(xml, "l"))
+	assert.Contains(t, xml, `<reg name="rsp" bitsize="64" type="data_ptr"`)
+	assert.Contains(t, xml, `<reg name="rrp" bitsize="64"`)
+	assert.Contains(t, xml, `<reg name="pc" bitsize="32"`)
+
+	assert.Equal(t, "m<?xm", c.request("qXfer:features:read:target.xml:0,4"))
+	assert.Equal(t, "", c.request("vMustReplyEmpty"))
+	assert.Equal(t, "OK", c.request("QStartNoAckMode"))
+
+	// without acknowledgements
+	require.NoError(t, writePacket(c.conn, "D"))
+	e := c.reader.next()
+	require.Equal(t, eventPacket, e.kind)
+	assert.Equal(t, "OK", e.packet)
+	assert.NoError(t, <-c.served)
+}
+
+func TestServer_BreakpointsAndMemory(t *testing.T) {
+	c := startServer(t, loadSession(t, counterProgram))
+
+	// break at the STREG instruction in the loop
+	assert.Equal(t, "OK", c.request("Z0,c,4"))
+	assert.Equal(t, "S05", c.request("c"))
+	assert.Equal(t, "0c000000", c.request("p10"))
+	assert.Equal(t, "0100000000000000", c.request("p1"))
+
+	registers := c.request("g")
+	assert.Len(t, registers, 16*16+8)
+	assert.Equal(t, "0c000000", registers[16*16:])
+
+	address, ok := decodeRegister(2, c.request("p2"))
+	require.True(t, ok)
+
+	c.request("s")
+	assert.Equal(t, "0100000000000000", c.request(fmt.Sprintf("m%x,8", address)))
+
+	// the program exits once the counter reaches 3
+	assert.Equal(t, "OK", c.request(fmt.Sprintf("M%x,1:02", address)))
+	assert.Equal(t, "OK", c.request("P1=0200000000000000"))
+	assert.Equal(t, "OK", c.request("z0,c,4"))
+	assert.Equal(t, "W00", c.request("c"))
+
+	assert.Equal(t, "E14", c.request("mffffff00,4"))
+	assert.Equal(t, "OK", c.request("D"))
+	assert.NoError(t, <-c.served)
+}
+
+func TestServer_WatchpointAndReverse(t *testing.T) {
+	c := startServer(t, loadSession(t, counterProgram))
+
+	c.request("s")
+	c.request("s")
+	address, ok := decodeRegister(2, c.request("p2"))
+	require.True(t, ok)
+	counter := fmt.Sprintf("%x", address)
+
+	assert.Equal(t, "OK", c.request(fmt.Sprintf("Z2,%s,8", counter)))
+	assert.Equal(t, fmt.Sprintf("T05watch:%s;", counter), c.request("c"))
+	assert.Equal(t, "0100000000000000", c.request("p1"))
+
+	assert.Equal(t, "OK", c.request(fmt.Sprintf("z2,%s,8", counter)))
+	assert.Equal(t, "W00", c.request("c"))
+	assert.Equal(t, "W00", c.request("?"))
+
+	assert.Equal(t, "S05", c.request("bs"))
+	assert.Equal(t, "T05replaylog:begin;", c.request("bc"))
+	assert.Equal(t, "00000000", c.request("p10"))
+
+	require.NoError(t, writePacket(c.conn, "k"))
+	ack, err := c.reader.r.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte('+'), ack)
+	assert.ErrorIs(t, <-c.served, ErrKilled)
+}
+
+func TestServer_Interrupt(t *testing.T) {
+	c := startServer(t, loadSession(t, infiniteProgram))
+
+	require.NoError(t, writePacket(c.conn, "c"))
+	_, err := c.conn.Write([]byte{interruptByte})
+	require.NoError(t, err)
+	assert.Equal(t, "S02", c.reply())
+
+	assert.Equal(t, "OK", c.request("D"))
+	assert.NoError(t, <-c.served)
+}
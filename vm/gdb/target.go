@@ -0,0 +1,69 @@
+package gdb
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"strconv"
+	"strings"
+)
+
+// targetDescription describes the registers of the VM in the order of the
+// g packet: the 16 general purpose registers followed by the program counter
+var targetDescription = buildTargetDescription()
+
+func buildTargetDescription() string {
+	var builder strings.Builder
+	builder.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.orange.core">
+`)
+	for n := arch.RegisterValue(0); n < arch.RegisterCount; n++ {
+		name := fmt.Sprintf("r%d", n)
+		typ := "int64"
+		switch n {
+		case arch.StackRegister:
+			name, typ = "rsp", "data_ptr"
+		case arch.ReturnRegister:
+			name, typ = "rrp", "code_ptr"
+		}
+		fmt.Fprintf(&builder, "    <reg name=\"%s\" bitsize=\"64\" type=\"%s\" regnum=\"%d\"/>\n", name, typ, n)
+	}
+	fmt.Fprintf(&builder, "    <reg name=\"pc\" bitsize=\"32\" type=\"code_ptr\" regnum=\"%d\"/>\n", pcRegister)
+	builder.WriteString("  </feature>\n</target>\n")
+	return builder.String()
+}
+
+// readTargetDescription answers a qXfer:features:read request with the
+// arguments "annex:offset,length"
+func readTargetDescription(args string) string {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return errInvalidPacket
+	} else if parts[0] != "target.xml" {
+		// unknown annex
+		return "E00"
+	}
+
+	bounds := strings.SplitN(parts[1], ",", 2)
+	if len(bounds) != 2 {
+		return errInvalidPacket
+	}
+	offset, err := strconv.ParseUint(bounds[0], 16, 32)
+	if err != nil {
+		return errInvalidPacket
+	}
+	length, err := strconv.ParseUint(bounds[1], 16, 32)
+	if err != nil {
+		return errInvalidPacket
+	}
+
+	if offset >= uint64(len(targetDescription)) {
+		return "l"
+	}
+	end := offset + length
+	if end >= uint64(len(targetDescription)) {
+		return "l" + targetDescription[offset:]
+	}
+	return "m" + targetDescription[offset:end]
+}
@@ -0,0 +1,187 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/memory"
+	"math"
+)
+
+const (
+	// MmapBase is the lowest address used for anonymous mappings. The heap
+	// grows upwards from the end of the program towards it.
+	MmapBase = 0x40000000
+)
+
+// heap tracks the program break and anonymous mappings of a program
+type heap struct {
+	start uint32
+	brk   uint32
+
+	// mappings maps the start address of each anonymous mapping to its size
+	mappings map[uint32]uint32
+}
+
+// InitHeap maps an empty heap at start, which must be above the program
+// image. The heap can then be grown with the brk and sbrk syscalls.
+func (v *VirtualMachine) InitHeap(start uint32) error {
+	mapper, ok := v.memory.mapper()
+	if !ok {
+		return errNoMapping
+	} else if start >= MmapBase {
+		return fmt.Errorf("heap start 0x%08x is above mmap base", start)
+	}
+
+	if err := mapper.Alloc(start, 0, memory.PermReadWrite); err != nil {
+		return err
+	}
+
+	v.heap.start = start
+	v.heap.brk = start
+	return nil
+}
+
+func (v *VirtualMachine) mapper() (memory.Mapper, error) {
+	mapper, ok := v.memory.mapper()
+	if !ok || v.heap.start == 0 {
+		return nil, ErrOutOfMemory
+	}
+	return mapper, nil
+}
+
+// setBreak moves the program break to address
+func (v *VirtualMachine) setBreak(address uint64) error {
+	mapper, err := v.mapper()
+	if err != nil {
+		return err
+	}
+
+	if address < uint64(v.heap.start) {
+		return fmt.Errorf("brk 0x%x below heap start: %w", address, ErrInvalidArgument)
+	} else if address > MmapBase {
+		return fmt.Errorf("brk 0x%x: %w", address, ErrOutOfMemory)
+	}
+
+	if err := mapper.Resize(v.heap.start, uint32(address)-v.heap.start); err != nil {
+		return fmt.Errorf("brk 0x%x: %v: %w", address, err, ErrOutOfMemory)
+	}
+
+	v.heap.brk = uint32(address)
+	return nil
+}
+
+// syscallBrk sets the program break
+//
+// Semantics: brk(void *addr)
+// addr: register 1
+//
+// Moves the end of the heap to addr and returns the new program break. If
+// addr is zero, the current program break is returned unchanged.
+func (v *VirtualMachine) syscallBrk() error {
+	address := v.registers.Get(1)
+	if address != 0 {
+		if err := v.setBreak(address); err != nil {
+			return err
+		}
+	}
+	v.setSyscallResult(uint64(v.heap.brk))
+	return nil
+}
+
+// syscallSbrk grows or shrinks the heap
+//
+// Semantics: sbrk(intptr_t increment)
+// increment: register 1
+//
+// Moves the program break by increment bytes and returns the previous
+// program break, which is the start of the newly allocated memory.
+func (v *VirtualMachine) syscallSbrk() error {
+	increment := int64(v.registers.Get(1))
+	previous := v.heap.brk
+
+	newBreak := int64(previous) + increment
+	if newBreak < 0 || newBreak > math.MaxUint32 {
+		return fmt.Errorf("sbrk %d: %w", increment, ErrInvalidArgument)
+	}
+
+	if err := v.setBreak(uint64(newBreak)); err != nil {
+		return err
+	}
+	v.setSyscallResult(uint64(previous))
+	return nil
+}
+
+// syscallMmap maps a new anonymous region of memory
+//
+// Semantics: mmap(void *addr, size_t length, int prot)
+// addr:   register 1 (hint, currently ignored)
+// length: register 2
+// prot:   register 3 (1 = read, 2 = write, 4 = execute)
+//
+// Maps at least length zeroed bytes and returns the address of the region.
+func (v *VirtualMachine) syscallMmap() error {
+	length := v.registers.Get(2)
+	prot := memory.Permission(v.registers.Get(3)) & memory.PermAll
+
+	if length == 0 || length > math.MaxUint32-memory.PageSize {
+		return fmt.Errorf("mmap length %d: %w", length, ErrInvalidArgument)
+	}
+
+	mapper, err := v.mapper()
+	if err != nil {
+		return err
+	}
+
+	size := roundUpToPage(uint32(length))
+	address, ok := mapper.FindFree(size, MmapBase, math.MaxUint32)
+	if !ok {
+		return fmt.Errorf("mmap length %d: %w", length, ErrOutOfMemory)
+	}
+
+	if err := mapper.Alloc(address, size, prot); err != nil {
+		return fmt.Errorf("mmap: %v: %w", err, ErrOutOfMemory)
+	}
+
+	if v.heap.mappings == nil {
+		v.heap.mappings = make(map[uint32]uint32)
+	}
+	v.heap.mappings[address] = size
+	v.setSyscallResult(uint64(address))
+	return nil
+}
+
+// syscallMunmap removes a region created by mmap
+//
+// Semantics: munmap(void *addr, size_t length)
+// addr:   register 1
+// length: register 2
+//
+// The address and length must describe an entire region returned by mmap.
+func (v *VirtualMachine) syscallMunmap() error {
+	address := v.registers.Get(1)
+	length := v.registers.Get(2)
+
+	size, ok := v.heap.mappings[uint32(address)]
+	if !ok || address > math.MaxUint32 || length > math.MaxUint32 || roundUpToPage(uint32(length)) != size {
+		return fmt.Errorf("munmap 0x%x length %d: %w", address, length, ErrInvalidArgument)
+	}
+
+	mapper, err := v.mapper()
+	if err != nil {
+		return err
+	}
+
+	if err := mapper.Free(uint32(address)); err != nil {
+		return fmt.Errorf("munmap: %v: %w", err, ErrInvalidArgument)
+	}
+
+	delete(v.heap.mappings, uint32(address))
+	v.setSyscallResult(0)
+	return nil
+}
+
+func roundUpToPage(size uint32) uint32 {
+	if rem := size % memory.PageSize; rem != 0 {
+		return size + memory.PageSize - rem
+	}
+	return size
+}
@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"github.com/dnsge/orange/arch"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+const heapStart = 0x1000
+
+func TestHeap_Brk(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+
+	brk, errno := callSyscall(t, v, SyscallBrk, 0)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(heapStart), brk)
+
+	brk, errno = callSyscall(t, v, SyscallBrk, heapStart+0x10)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(heapStart+0x10), brk)
+	assert.NoError(t, v.memory.Write(heapStart+0x8, 64, 1))
+	assert.Error(t, v.memory.Write(heapStart+0x10, 8, 1))
+
+	_, errno = callSyscall(t, v, SyscallBrk, heapStart-8)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	_, errno = callSyscall(t, v, SyscallBrk, MmapBase+1)
+	assert.Equal(t, uint64(arch.ENO_OutOfMemory), errno)
+
+	// failed calls leave the break where it was
+	brk, _ = callSyscall(t, v, SyscallBrk, 0)
+	assert.Equal(t, uint64(heapStart+0x10), brk)
+}
+
+func TestHeap_Sbrk(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+
+	previous, errno := callSyscall(t, v, SyscallSbrk, 0x20)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(heapStart), previous)
+
+	previous, errno = callSyscall(t, v, SyscallSbrk, uint64(0xffffffffffffffff-0xf)) // -16
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(heapStart+0x20), previous)
+	assert.Error(t, v.memory.Write(heapStart+0x10, 8, 1), "memory above the break is unmapped")
+
+	_, errno = callSyscall(t, v, SyscallSbrk, uint64(0xffffffffffff0000))
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	_, errno = callSyscall(t, v, SyscallSbrk, math.MaxUint32)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	_, errno = callSyscall(t, v, SyscallSbrk, MmapBase)
+	assert.Equal(t, uint64(arch.ENO_OutOfMemory), errno)
+}
+
+func TestHeap_Mmap(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+
+	_, errno := callSyscall(t, v, SyscallMmap, 0, 0, 3)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	_, errno = callSyscall(t, v, SyscallMmap, 0, math.MaxUint32, 3)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+
+	first, errno := callSyscall(t, v, SyscallMmap, 0, 1, 3)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(MmapBase), first)
+	assert.NoError(t, v.memory.Write(MmapBase+0xff8, 64, 1), "lengths are rounded up to pages")
+
+	readOnly, errno := callSyscall(t, v, SyscallMmap, 0, 0x1001, 1)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(MmapBase+0x1000), readOnly)
+	_, err := v.memory.Read(uint32(readOnly)+0x1ff8, 64)
+	assert.NoError(t, err)
+	assert.Error(t, v.memory.Write(uint32(readOnly), 8, 1))
+
+	// munmap needs the whole region
+	_, errno = callSyscall(t, v, SyscallMunmap, readOnly, 0x1000)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+	_, errno = callSyscall(t, v, SyscallMunmap, readOnly+0x1000, 0x1000)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+
+	_, errno = callSyscall(t, v, SyscallMunmap, first, 1)
+	assert.Equal(t, uint64(0), errno)
+	_, err = v.memory.Read(MmapBase, 8)
+	assert.Error(t, err)
+	_, errno = callSyscall(t, v, SyscallMunmap, first, 1)
+	assert.Equal(t, uint64(arch.ENO_InvalidArgument), errno)
+
+	// freed regions are reused
+	again, errno := callSyscall(t, v, SyscallMmap, 0, 0x1000, 3)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, first, again)
+}
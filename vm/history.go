@@ -0,0 +1,215 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/memory"
+)
+
+// ErrNoHistory is returned by StepBack when there is no recorded
+// instruction to undo
+var ErrNoHistory = fmt.Errorf("no execution history")
+
+// undoEntry holds the state needed to undo a single instruction
+type undoEntry struct {
+	pc        uint32
+	registers registerFile
+	flags     aluFlags
+	halted    bool
+	exitCode  int
+
+	// writes holds the previous contents of memory written by the
+	// instruction, in the order they were written
+	writes []memoryWrite
+	// regions and heap hold the memory layout before the instruction, and
+	// are only set if the instruction changed the mapped regions or heap.
+	// Only the first remapIndex writes happened before the regions were saved.
+	regions    []memory.Region
+	remapIndex int
+	heap       *heap
+}
+
+// history is an undo log of the most recently executed instructions. The
+// entries form a ring buffer of length entries starting at head, which grows
+// until it holds limit entries and then overwrites the oldest one.
+type history struct {
+	limit   int
+	entries []*undoEntry
+	head    int
+	length  int
+}
+
+// minHistoryCapacity is the number of entries allocated when the first
+// instruction is recorded
+const minHistoryCapacity = 64
+
+// push adds entry as the newest entry, dropping the oldest entry if the
+// history is full
+func (h *history) push(entry *undoEntry) {
+	if h.limit > 0 && h.length == h.limit {
+		h.entries[h.head] = entry
+		h.head = (h.head + 1) % len(h.entries)
+		return
+	}
+
+	if h.length == len(h.entries) {
+		h.grow()
+	}
+	h.entries[(h.head+h.length)%len(h.entries)] = entry
+	h.length++
+}
+
+// grow doubles the capacity of the ring buffer, up to the limit
+func (h *history) grow() {
+	capacity := 2 * len(h.entries)
+	if capacity < minHistoryCapacity {
+		capacity = minHistoryCapacity
+	}
+	if h.limit > 0 && capacity > h.limit {
+		capacity = h.limit
+	}
+
+	entries := make([]*undoEntry, capacity)
+	for i := 0; i < h.length; i++ {
+		entries[i] = h.entries[(h.head+i)%len(h.entries)]
+	}
+	h.entries = entries
+	h.head = 0
+}
+
+// newest returns the most recently pushed entry, or nil if there is none
+func (h *history) newest() *undoEntry {
+	if h.length == 0 {
+		return nil
+	}
+	return h.entries[(h.head+h.length-1)%len(h.entries)]
+}
+
+// pop removes the newest entry
+func (h *history) pop() {
+	if h.length == 0 {
+		return
+	}
+	h.entries[(h.head+h.length-1)%len(h.entries)] = nil
+	h.length--
+}
+
+// clear removes every entry
+func (h *history) clear() {
+	h.entries = nil
+	h.head = 0
+	h.length = 0
+}
+
+// EnableHistory starts recording an undo log of every executed instruction,
+// so that execution can be reversed with StepBack. At most limit
+// instructions are kept, or all of them if limit is zero.
+//
+// Reversing an instruction restores registers, flags and memory, including
+// regions mapped by syscalls, but not the effects of a syscall outside of
+// the VM, like data written to a file.
+func (v *VirtualMachine) EnableHistory(limit int) {
+	v.history = &history{limit: limit}
+}
+
+// DisableHistory stops recording and discards the undo log
+func (v *VirtualMachine) DisableHistory() {
+	v.history = nil
+}
+
+// HistoryLength returns the number of instructions that can be undone
+func (v *VirtualMachine) HistoryLength() int {
+	if v.history == nil {
+		return 0
+	}
+	return v.history.length
+}
+
+// beginUndoEntry starts recording the effects of the next instruction
+func (v *VirtualMachine) beginUndoEntry() *undoEntry {
+	if v.history == nil {
+		return nil
+	}
+
+	entry := &undoEntry{
+		pc:        v.programCounter,
+		registers: v.registers,
+		flags:     v.alu.flags,
+		halted:    v.halted,
+		exitCode:  v.exitCode,
+	}
+	v.memory.undo = entry
+	return entry
+}
+
+// endUndoEntry adds the entry to the undo log
+func (v *VirtualMachine) endUndoEntry(entry *undoEntry) {
+	if entry == nil {
+		return
+	}
+	v.memory.undo = nil
+
+	v.history.push(entry)
+}
+
+// saveHeap records the heap layout in the current undo entry before a
+// syscall can change it
+func (v *VirtualMachine) saveHeap() {
+	entry := v.memory.undo
+	if entry == nil || entry.heap != nil {
+		return
+	}
+
+	saved := heap{
+		start:    v.heap.start,
+		brk:      v.heap.brk,
+		mappings: make(map[uint32]uint32, len(v.heap.mappings)),
+	}
+	for address, size := range v.heap.mappings {
+		saved.mappings[address] = size
+	}
+	entry.heap = &saved
+}
+
+// StepBack undoes the most recently executed instruction. It returns
+// ErrNoHistory if history is disabled or every recorded instruction has
+// already been undone.
+func (v *VirtualMachine) StepBack() error {
+	if v.history == nil || v.history.length == 0 {
+		return ErrNoHistory
+	}
+
+	entry := v.history.newest()
+
+	writes := entry.writes
+	if entry.regions != nil {
+		mem, ok := v.memory.Addressable.(memory.Snapshotter)
+		if !ok {
+			return fmt.Errorf("memory does not support snapshots")
+		}
+		if err := mem.RestoreRegions(entry.regions); err != nil {
+			return err
+		}
+		writes = writes[:entry.remapIndex]
+	}
+
+	for i := len(writes) - 1; i >= 0; i-- {
+		write := writes[i]
+		if err := v.memory.Addressable.Write(write.address, write.size, write.value); err != nil {
+			return err
+		}
+	}
+
+	if entry.heap != nil {
+		v.heap = *entry.heap
+	}
+
+	v.programCounter = entry.pc
+	v.registers = entry.registers
+	v.alu.flags = entry.flags
+	v.halted = entry.halted
+	v.exitCode = entry.exitCode
+	v.steps--
+
+	v.history.pop()
+	return nil
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHistory_Ring(t *testing.T) {
+	h := &history{limit: 100}
+	entries := make([]*undoEntry, 150)
+	for i := range entries {
+		entries[i] = &undoEntry{pc: uint32(i)}
+		h.push(entries[i])
+	}
+	assert.Equal(t, 100, h.length)
+	assert.Len(t, h.entries, 100, "the buffer does not grow past the limit")
+
+	for i := 149; i >= 50; i-- {
+		assert.Same(t, entries[i], h.newest())
+		h.pop()
+	}
+	assert.Nil(t, h.newest())
+	for _, entry := range h.entries {
+		assert.Nil(t, entry, "popped entries are released")
+	}
+
+	// unlimited histories keep growing
+	h = &history{}
+	for _, entry := range entries {
+		h.push(entry)
+	}
+	assert.Equal(t, 150, h.length)
+	assert.Same(t, entries[149], h.newest())
+	h.clear()
+	assert.Equal(t, 0, h.length)
+}
+
+func TestStepBack(t *testing.T) {
+	v := newTestVM(t, loopProgram)
+	v.EnableHistory(5)
+	require.ErrorIs(t, v.Run(context.Background(), RunOptions{MaxSteps: 20}), ErrBudgetExhausted)
+	assert.Equal(t, 5, v.HistoryLength())
+	assert.Equal(t, uint64(10), v.Register(1))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, v.StepBack())
+	}
+	assert.ErrorIs(t, v.StepBack(), ErrNoHistory)
+	assert.Equal(t, uint64(15), v.Steps())
+	assert.Equal(t, uint64(8), v.Register(1))
+	assert.Equal(t, uint32(0x4), v.PC())
+
+	// execution continues from the restored state
+	require.ErrorIs(t, v.Run(context.Background(), RunOptions{MaxSteps: 5}), ErrBudgetExhausted)
+	assert.Equal(t, uint64(10), v.Register(1))
+	assert.Equal(t, 5, v.HistoryLength())
+
+	v.DisableHistory()
+	assert.ErrorIs(t, v.StepBack(), ErrNoHistory)
+}
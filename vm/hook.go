@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"github.com/dnsge/orange/arch"
+)
+
+// Hook observes the instructions executed by the VM, e.g. to profile a
+// program or to measure its coverage
+type Hook interface {
+	// Executed is called after the instruction at pc completed, where next
+	// is the address of the instruction that follows it. Instructions that
+	// fault and instructions undone by StepBack are not reported.
+	Executed(pc uint32, instruction arch.Instruction, next uint32)
+}
+
+// AddHook adds a hook that is called after every executed instruction, after
+// the hooks added before it
+func (v *VirtualMachine) AddHook(hook Hook) {
+	v.hooks = append(v.hooks, hook)
+}
+
+// RemoveHook removes a hook added with AddHook
+func (v *VirtualMachine) RemoveHook(hook Hook) {
+	for i, h := range v.hooks {
+		if h == hook {
+			v.hooks = append(v.hooks[:i:i], v.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// runHooks reports the executed instruction to the hooks
+func (v *VirtualMachine) runHooks(pc uint32, instruction arch.Instruction) {
+	for _, hook := range v.hooks {
+		hook.Executed(pc, instruction, v.programCounter)
+	}
+}
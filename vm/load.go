@@ -0,0 +1,53 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+)
+
+const (
+	// DefaultStackTop is the address directly above the stack of programs
+	// loaded with LoadExecutable
+	DefaultStackTop = 0x80000000
+	// DefaultStackSize is the stack size used by the tools
+	DefaultStackSize = 0x10000
+)
+
+// LoadExecutable loads exe into the memory of the VM, which must be a
+// *memory.Memory, and prepares it to run the program: the heap starts on
+// the first page after the program, a stack of stackSize bytes ends at
+// DefaultStackTop and args and env are copied onto the stack as described
+// by InitArguments. The debug info of exe is used for traces.
+func (v *VirtualMachine) LoadExecutable(exe *executable.File, args []string, env []string, stackSize uint32) error {
+	mem, ok := v.memory.Addressable.(*memory.Memory)
+	if !ok {
+		return fmt.Errorf("memory does not support loading executables")
+	}
+
+	if stackSize == 0 || stackSize%8 != 0 || stackSize > DefaultStackTop/2 {
+		return fmt.Errorf("invalid stack size %d: must be a positive multiple of 8 no larger than 0x%x", stackSize, DefaultStackTop/2)
+	}
+
+	if err := exe.Load(mem); err != nil {
+		return fmt.Errorf("failed to load program into memory: %w", err)
+	}
+	v.SetDebugInfo(exe.Debug)
+
+	// place the heap on the first page after the program
+	heapStart := (exe.End()/memory.PageSize + 1) * memory.PageSize
+	if err := v.InitHeap(heapStart); err != nil {
+		return fmt.Errorf("failed to initialize heap: %w", err)
+	}
+
+	stack, err := MapStack(mem, DefaultStackTop, stackSize)
+	if err != nil {
+		return fmt.Errorf("failed to allocate stack: %w", err)
+	}
+	v.InitStack(stack)
+
+	if err := v.InitArguments(args, env); err != nil {
+		return fmt.Errorf("failed to pass arguments: %w", err)
+	}
+	return nil
+}
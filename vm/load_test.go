@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLoadExecutable(t *testing.T) {
+	exe := assemble(t, "\tNOOP\n\tHALT\n")
+	v := NewVirtualMachine(memory.New(), true)
+	require.NoError(t, v.LoadExecutable(exe, []string{"prog"}, nil, 0x1000))
+
+	assert.Equal(t, Stack{Bottom: DefaultStackTop - 0x1000, Top: DefaultStackTop}, v.stack)
+	assert.Equal(t, uint64(1), v.Register(ArgCountRegister))
+	assert.Equal(t, uint64(DefaultStackTop-8-4*8), v.Register(arch.StackRegister))
+	assert.Same(t, exe.Debug, v.DebugInfo())
+	brk, _ := callSyscall(t, v, SyscallBrk, 0)
+	assert.Equal(t, uint64(heapStart), brk)
+
+	for _, size := range []uint32{0, 12, DefaultStackTop/2 + 8} {
+		err := NewVirtualMachine(memory.New(), true).LoadExecutable(exe, nil, nil, size)
+		assert.Error(t, err, "stack size %d", size)
+	}
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/memory"
+)
+
+var errNoMapping = fmt.Errorf("memory does not support mapping regions")
+
+// memoryWrite is a single write to memory
+type memoryWrite struct {
+	address uint32
+	size    uint32
+	value   uint64
+}
+
+// observedMemory wraps the memory of a VM so that writes and changes to
+// the mapped regions can be recorded for the undo log and syscall log.
+type observedMemory struct {
+	memory.Addressable
+
+	// undo receives the previous contents of written memory and of the
+	// regions before they are remapped, if set
+	undo *undoEntry
+	// capture receives the values written to memory, if set
+	capture *[]memoryWrite
+}
+
+func newObservedMemory(mem memory.Addressable) *observedMemory {
+	return &observedMemory{Addressable: mem}
+}
+
+func (m *observedMemory) Write(address uint32, size uint32, data uint64) error {
+	var old uint64
+	var peekErr error
+	if m.undo != nil {
+		old, peekErr = m.peek(address, size)
+	}
+
+	if err := m.Addressable.Write(address, size, data); err != nil {
+		return err
+	}
+
+	if m.undo != nil && peekErr == nil {
+		m.undo.writes = append(m.undo.writes, memoryWrite{address: address, size: size, value: old})
+	}
+	if m.capture != nil {
+		*m.capture = append(*m.capture, memoryWrite{address: address, size: size, value: data})
+	}
+	return nil
+}
+
+// peek reads memory regardless of its permissions, if supported
+func (m *observedMemory) peek(address uint32, size uint32) (uint64, error) {
+	if peeker, ok := m.Addressable.(interface {
+		Peek(address uint32, size uint32) (uint64, error)
+	}); ok {
+		return peeker.Peek(address, size)
+	}
+	return m.Addressable.Read(address, size)
+}
+
+// mapper returns the memory as a memory.Mapper if the underlying memory
+// supports mapping regions
+func (m *observedMemory) mapper() (memory.Mapper, bool) {
+	if _, ok := m.Addressable.(memory.Mapper); !ok {
+		return nil, false
+	}
+	return m, true
+}
+
+// beforeRemap saves the regions of the memory before they change
+func (m *observedMemory) beforeRemap() {
+	if m.undo == nil || m.undo.regions != nil {
+		return
+	}
+	if snapshotter, ok := m.Addressable.(memory.Snapshotter); ok {
+		m.undo.regions = snapshotter.Regions()
+		m.undo.remapIndex = len(m.undo.writes)
+	}
+}
+
+func (m *observedMemory) Alloc(startAddress uint32, size uint32, perm memory.Permission) error {
+	mapper, ok := m.Addressable.(memory.Mapper)
+	if !ok {
+		return errNoMapping
+	}
+	m.beforeRemap()
+	return mapper.Alloc(startAddress, size, perm)
+}
+
+func (m *observedMemory) Resize(startAddress uint32, size uint32) error {
+	mapper, ok := m.Addressable.(memory.Mapper)
+	if !ok {
+		return errNoMapping
+	}
+	m.beforeRemap()
+	return mapper.Resize(startAddress, size)
+}
+
+func (m *observedMemory) Free(startAddress uint32) error {
+	mapper, ok := m.Addressable.(memory.Mapper)
+	if !ok {
+		return errNoMapping
+	}
+	m.beforeRemap()
+	return mapper.Free(startAddress)
+}
+
+func (m *observedMemory) FindFree(size uint32, low uint32, high uint32) (uint32, bool) {
+	mapper, ok := m.Addressable.(memory.Mapper)
+	if !ok {
+		return 0, false
+	}
+	return mapper.FindFree(size, low, high)
+}
+
+// Peek reads memory at address like a load of size bits, but ignores the
+// permissions of the region if the memory supports it. It is intended for
+// debuggers and does not affect the program.
+func (v *VirtualMachine) Peek(address uint32, size uint32) (uint64, error) {
+	switch size {
+	case 8, 16, 32, 64:
+		return v.memory.peek(address, size)
+	default:
+		return 0, fmt.Errorf("invalid read size of %d: %w", size, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,213 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// protoBuffer encodes protocol buffer messages, which is all that is needed
+// to write the profile.proto format read by pprof
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 writes a varint field, omitting zero values like proto3
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+// packed writes a repeated varint field
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var inner protoBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytes(field, inner.data)
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// message writes a nested message encoded by fn
+func (b *protoBuffer) message(field int, fn func(m *protoBuffer)) {
+	var inner protoBuffer
+	fn(&inner)
+	b.bytes(field, inner.data)
+}
+
+// fields of the messages of profile.proto
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID      = 1
+	locationAddress = 3
+	locationLine    = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+// pprofWriter collects the string table and the ids of the profile
+type pprofWriter struct {
+	strings   []string
+	stringIDs map[string]int64
+
+	locations   map[uint32]uint64
+	functionIDs map[string]uint64
+	// functionFiles holds the source file of each function, if known
+	functionFiles map[string]string
+	functions     []string
+}
+
+func (w *pprofWriter) string(s string) int64 {
+	if id, ok := w.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(w.strings))
+	w.strings = append(w.strings, s)
+	w.stringIDs[s] = id
+	return id
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format of
+// pprof, with one sample per executed instruction and call stack. Each
+// instruction is a location of the function of its symbol and, with debug
+// info, of its source line.
+func (p *Profiler) WritePprof(out io.Writer) error {
+	w := &pprofWriter{
+		stringIDs:     make(map[string]int64),
+		locations:     make(map[uint32]uint64),
+		functionIDs:   make(map[string]uint64),
+		functionFiles: make(map[string]string),
+	}
+	w.string("")
+
+	var b protoBuffer
+	valueType := func(field int, typ string, unit string) {
+		b.message(field, func(m *protoBuffer) {
+			m.int64(valueTypeType, w.string(typ))
+			m.int64(valueTypeUnit, w.string(unit))
+		})
+	}
+	valueType(profileSampleType, "instructions", "count")
+	valueType(profilePeriodType, "instructions", "count")
+	b.int64(profilePeriod, 1)
+
+	location := func(address uint32) uint64 {
+		if id, ok := w.locations[address]; ok {
+			return id
+		}
+		id := uint64(len(w.locations) + 1)
+		w.locations[address] = id
+
+		name := p.Symbol(address)
+		if _, ok := w.functionIDs[name]; !ok {
+			w.functionIDs[name] = uint64(len(w.functions) + 1)
+			w.functions = append(w.functions, name)
+		}
+		var line int64
+		if p.info != nil {
+			if l, ok := p.info.LineFor(address); ok {
+				line = int64(l.Line)
+				if _, ok := w.functionFiles[name]; !ok {
+					w.functionFiles[name] = l.File
+				}
+			}
+		}
+
+		b.message(profileLocation, func(m *protoBuffer) {
+			m.uint64(locationID, id)
+			m.uint64(locationAddress, uint64(address))
+			m.message(locationLine, func(l *protoBuffer) {
+				l.uint64(lineFunctionID, w.functionIDs[name])
+				l.int64(lineLine, line)
+			})
+		})
+		return id
+	}
+
+	// write samples in a stable order
+	keys := make([]sampleKey, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].stack != keys[b].stack {
+			return keys[a].stack < keys[b].stack
+		}
+		return keys[a].pc < keys[b].pc
+	})
+
+	for _, key := range keys {
+		ids := []uint64{location(key.pc)}
+		for _, callSite := range p.stacks[key.stack] {
+			ids = append(ids, location(callSite))
+		}
+		count := p.samples[key]
+		b.message(profileSample, func(m *protoBuffer) {
+			m.packed(sampleLocationID, ids)
+			m.packed(sampleValue, []uint64{count})
+		})
+	}
+
+	for _, name := range w.functions {
+		name := name
+		b.message(profileFunction, func(m *protoBuffer) {
+			m.uint64(functionID, w.functionIDs[name])
+			m.int64(functionName, w.string(name))
+			m.int64(functionSystemName, w.string(name))
+			m.int64(functionFilename, w.string(w.functionFiles[name]))
+		})
+	}
+
+	// the string table is complete once everything else is written
+	for _, s := range w.strings {
+		b.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,220 @@
+// Package profile counts the instructions executed by a vm.VirtualMachine
+// and attributes them to the symbols of the program, for a report of its hot
+// spots or for `go tool pprof`.
+package profile
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
+	"sort"
+)
+
+const (
+	// maxCallDepth limits the number of calls tracked for the call stacks of
+	// samples. Calls nested deeper are attributed to the deepest tracked one.
+	maxCallDepth = 256
+)
+
+// frame is a call tracked by the profiler
+type frame struct {
+	callSite      uint32
+	returnAddress uint32
+}
+
+// sampleKey identifies the instructions executed at pc with the same calls
+// leading to it
+type sampleKey struct {
+	stack int
+	pc    uint32
+}
+
+// Profiler is a vm.Hook that counts the executions of every instruction along
+// with the calls leading to it. Calls are made with BL or BLR and return to
+// the instruction after them.
+type Profiler struct {
+	info *debuginfo.Info
+
+	frames []frame
+	// untracked is the number of calls made beyond maxCallDepth that have
+	// not returned yet
+	untracked int
+
+	// stacks interns the call sites of the frames, innermost first
+	stacks     [][]uint32
+	stackIDs   map[string]int
+	stack      int
+	samples    map[sampleKey]uint64
+	calls      map[uint32]uint64
+	total      uint64
+	symbolized map[uint32]string
+}
+
+// New returns a profiler that names code by the symbols of info, which may be
+// nil
+func New(info *debuginfo.Info) *Profiler {
+	p := &Profiler{
+		info:       info,
+		stackIDs:   make(map[string]int),
+		samples:    make(map[sampleKey]uint64),
+		calls:      make(map[uint32]uint64),
+		symbolized: make(map[uint32]string),
+	}
+	p.stack = p.internStack()
+	return p
+}
+
+// Executed counts the instruction at pc and tracks calls and returns
+func (p *Profiler) Executed(pc uint32, instruction arch.Instruction, next uint32) {
+	p.samples[sampleKey{stack: p.stack, pc: pc}]++
+	p.total++
+
+	switch arch.GetOpcode(instruction) {
+	case arch.BL, arch.BLR:
+		p.calls[next]++
+		if len(p.frames) >= maxCallDepth {
+			p.untracked++
+			return
+		}
+		p.frames = append(p.frames, frame{callSite: pc, returnAddress: pc + 4})
+		p.stack = p.internStack()
+	case arch.BREG:
+		if p.untracked > 0 {
+			p.untracked--
+			return
+		}
+		// returns may skip frames, like a longjmp
+		for i := len(p.frames) - 1; i >= 0; i-- {
+			if p.frames[i].returnAddress == next {
+				p.frames = p.frames[:i]
+				p.stack = p.internStack()
+				return
+			}
+		}
+	}
+}
+
+// internStack returns the id of the call sites of the current frames
+func (p *Profiler) internStack() int {
+	callSites := make([]uint32, len(p.frames))
+	for i, f := range p.frames {
+		callSites[len(p.frames)-1-i] = f.callSite
+	}
+
+	key := fmt.Sprint(callSites)
+	if id, ok := p.stackIDs[key]; ok {
+		return id
+	}
+	id := len(p.stacks)
+	p.stacks = append(p.stacks, callSites)
+	p.stackIDs[key] = id
+	return id
+}
+
+// Total returns the number of executed instructions
+func (p *Profiler) Total() uint64 {
+	return p.total
+}
+
+// Symbol returns the name of the closest symbol at or before the address, or
+// the address itself without one
+func (p *Profiler) Symbol(address uint32) string {
+	if name, ok := p.symbolized[address]; ok {
+		return name
+	}
+
+	name := fmt.Sprintf("0x%08x", address)
+	if p.info != nil {
+		if symbol, ok := p.info.SymbolFor(address); ok {
+			name = symbol.Name
+		}
+	}
+	p.symbolized[address] = name
+	return name
+}
+
+// Instruction is the number of executions of the instruction at Address
+type Instruction struct {
+	Address uint32
+	Count   uint64
+}
+
+// Instructions returns the executed instructions, most executed first
+func (p *Profiler) Instructions() []Instruction {
+	counts := make(map[uint32]uint64)
+	for key, count := range p.samples {
+		counts[key.pc] += count
+	}
+
+	res := make([]Instruction, 0, len(counts))
+	for address, count := range counts {
+		res = append(res, Instruction{Address: address, Count: count})
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].Count != res[b].Count {
+			return res[a].Count > res[b].Count
+		}
+		return res[a].Address < res[b].Address
+	})
+	return res
+}
+
+// Function holds the counts of the code attributed to a symbol
+type Function struct {
+	Name string
+	// Flat is the number of instructions executed in the function itself
+	Flat uint64
+	// Cumulative also includes the instructions of the functions it calls
+	Cumulative uint64
+	// Calls is the number of BL and BLR instructions that called an
+	// address attributed to the function
+	Calls uint64
+}
+
+// Functions returns the counts of every executed or called symbol, most
+// executed first
+func (p *Profiler) Functions() []*Function {
+	functions := make(map[string]*Function)
+	get := func(name string) *Function {
+		f, ok := functions[name]
+		if !ok {
+			f = &Function{Name: name}
+			functions[name] = f
+		}
+		return f
+	}
+
+	for key, count := range p.samples {
+		name := p.Symbol(key.pc)
+		get(name).Flat += count
+
+		// recursive functions only count once per sample
+		seen := map[string]bool{name: true}
+		get(name).Cumulative += count
+		for _, callSite := range p.stacks[key.stack] {
+			caller := p.Symbol(callSite)
+			if !seen[caller] {
+				seen[caller] = true
+				get(caller).Cumulative += count
+			}
+		}
+	}
+	for address, count := range p.calls {
+		get(p.Symbol(address)).Calls += count
+	}
+
+	res := make([]*Function, 0, len(functions))
+	for _, f := range functions {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].Flat != res[b].Flat {
+			return res[a].Flat > res[b].Flat
+		}
+		if res[a].Cumulative != res[b].Cumulative {
+			return res[a].Cumulative > res[b].Cumulative
+		}
+		return res[a].Name < res[b].Name
+	})
+	return res
+}
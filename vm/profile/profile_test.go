@@ -0,0 +1,79 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/dnsge/orange/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+const callProgram = `$main:
+	MOVZ r1, #3
+$loop:
+	BL $work
+	SUBI r1, #1
+	CMPI r1, #0
+	B.NEQ $loop
+	HALT
+$work:
+	NOOP
+	NOOP
+	BREG rrp
+`
+
+func profileProgram(t *testing.T, source string) *Profiler {
+	var out bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(source), &out, &asm.Options{
+		Target: asm.TargetExecutable,
+		Debug:  true,
+	}))
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+	sim := vm.NewVirtualMachine(mem, true)
+
+	p := New(exe.Debug)
+	sim.AddHook(p)
+	require.NoError(t, sim.Run(context.Background(), vm.RunOptions{}))
+	return p
+}
+
+func TestProfiler_Functions(t *testing.T) {
+	p := profileProgram(t, callProgram)
+	assert.Equal(t, uint64(23), p.Total())
+
+	var functions []Function
+	for _, f := range p.Functions() {
+		functions = append(functions, *f)
+	}
+	assert.Equal(t, []Function{
+		{Name: "loop", Flat: 13, Cumulative: 22},
+		{Name: "work", Flat: 9, Cumulative: 9, Calls: 3},
+		{Name: "main", Flat: 1, Cumulative: 1},
+	}, functions)
+
+	assert.Equal(t, Instruction{Address: 0x4, Count: 3}, p.Instructions()[0])
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	p := profileProgram(t, callProgram)
+
+	var out bytes.Buffer
+	require.NoError(t, p.WritePprof(&out))
+	gz, err := gzip.NewReader(&out)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "work")
+	assert.Contains(t, string(data), "instructions")
+}
@@ -0,0 +1,57 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// percent returns count as a percentage of total
+func percent(count uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// WriteReport writes the top functions and instructions by number of
+// executions. A top of zero writes all of them.
+func (p *Profiler) WriteReport(w io.Writer, top int) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, "%d instructions executed\n\n", p.total)
+
+	functions := p.Functions()
+	if top > 0 && len(functions) > top {
+		functions = functions[:top]
+	}
+	_, _ = fmt.Fprintf(bw, "%10s %6s %10s %6s %8s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "symbol")
+	for _, f := range functions {
+		_, _ = fmt.Fprintf(bw, "%10d %5.1f%% %10d %5.1f%% %8d  %s\n",
+			f.Flat, percent(f.Flat, p.total), f.Cumulative, percent(f.Cumulative, p.total), f.Calls, f.Name)
+	}
+
+	instructions := p.Instructions()
+	if top > 0 && len(instructions) > top {
+		instructions = instructions[:top]
+	}
+	_, _ = fmt.Fprintf(bw, "\n%10s %6s  %-10s  %s\n", "count", "%", "address", "location")
+	for _, i := range instructions {
+		_, _ = fmt.Fprintf(bw, "%10d %5.1f%%  0x%08x  %s\n",
+			i.Count, percent(i.Count, p.total), i.Address, p.location(i.Address))
+	}
+	return bw.Flush()
+}
+
+// location describes the source line and symbol of an address
+func (p *Profiler) location(address uint32) string {
+	symbol := p.Symbol(address)
+	if p.info != nil {
+		if line, ok := p.info.LineFor(address); ok {
+			return fmt.Sprintf("%s in $%s", line, symbol)
+		}
+		if s, ok := p.info.SymbolFor(address); ok {
+			return fmt.Sprintf("$%s+%d", s.Name, address-s.Address)
+		}
+	}
+	return symbol
+}
@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"io"
+)
+
+const (
+	syscallLogMagic   = "orange-syscalls\n"
+	syscallLogVersion = 1
+)
+
+var (
+	ErrInvalidSyscallLog = fmt.Errorf("invalid syscall log")
+	ErrReplayDiverged    = fmt.Errorf("replay diverged from recording")
+)
+
+// reexecutedSyscalls only change the state of the VM itself, so they are
+// executed again when replaying rather than restored from the log
+var reexecutedSyscalls = map[uint64]bool{
+	SyscallBrk:    true,
+	SyscallSbrk:   true,
+	SyscallMmap:   true,
+	SyscallMunmap: true,
+	SyscallExit:   true,
+}
+
+// syscallRecord holds the effects of a single syscall
+type syscallRecord struct {
+	number uint64
+	result uint64
+	errno  uint64
+	writes []memoryWrite
+}
+
+// SyscallLog is a recording of the syscalls made by a program, which allows
+// a run to be replayed exactly without access to its original input, files,
+// clock or source of randomness.
+type SyscallLog struct {
+	records []syscallRecord
+	next    int
+}
+
+// Len returns the number of recorded syscalls
+func (l *SyscallLog) Len() int {
+	return len(l.records)
+}
+
+// RecordSyscalls starts recording every syscall made by the program into
+// the returned log.
+func (v *VirtualMachine) RecordSyscalls() *SyscallLog {
+	v.recording = &SyscallLog{}
+	return v.recording
+}
+
+// ReplaySyscalls replays the syscalls in a log recorded by RecordSyscalls.
+//
+// Syscalls that only change the memory layout of the VM or stop the program
+// (brk, sbrk, mmap, munmap and exit) are executed again. All other syscalls
+// are not executed; instead, their results and the memory they wrote are
+// restored from the log. Data written to the standard output and error
+// streams is written again. If the program makes a different syscall than
+// the one recorded, the VM faults with ErrReplayDiverged.
+func (v *VirtualMachine) ReplaySyscalls(log *SyscallLog) {
+	log.next = 0
+	v.replaying = log
+}
+
+func (v *VirtualMachine) recordSyscall(number uint64, writes []memoryWrite) {
+	v.recording.records = append(v.recording.records, syscallRecord{
+		number: number,
+		result: v.registers.Get(arch.SyscallResultRegister),
+		errno:  v.registers.Get(arch.SyscallErrorRegister),
+		writes: writes,
+	})
+}
+
+// nextRecord returns the next record of the replayed log, which must be for
+// the given syscall
+func (v *VirtualMachine) nextRecord(number uint64) (*syscallRecord, error) {
+	log := v.replaying
+	if log.next >= len(log.records) {
+		return nil, fmt.Errorf("syscall %d made after end of recording: %w", number, ErrReplayDiverged)
+	}
+
+	record := &log.records[log.next]
+	if record.number != number {
+		return nil, fmt.Errorf("syscall %d made instead of recorded syscall %d: %w", number, record.number, ErrReplayDiverged)
+	}
+	log.next++
+	return record, nil
+}
+
+// replaySyscall restores the effects of a syscall from the replayed log
+func (v *VirtualMachine) replaySyscall(number uint64) error {
+	record, err := v.nextRecord(number)
+	if err != nil {
+		return err
+	}
+
+	for _, write := range record.writes {
+		if err := v.memory.Write(write.address, write.size, write.value); err != nil {
+			return fmt.Errorf("replay syscall %d: %w", number, err)
+		}
+	}
+
+	if number == SyscallWrite && record.errno == 0 {
+		v.replayOutput()
+	}
+
+	v.setSyscallResult(record.result)
+	v.setSyscallError(record.errno)
+	return nil
+}
+
+// replayOutput writes the buffer of a replayed write syscall again if it
+// was written to the standard output or error stream
+func (v *VirtualMachine) replayOutput() {
+	fileD := int(v.registers.Get(1))
+	if fileD != 1 && fileD != 2 {
+		return
+	}
+	desc, ok := v.fds[fileD]
+	if !ok || desc.name != "" {
+		return
+	}
+	_ = v.syscallWriteExecute(fileD, uint32(v.registers.Get(2)), uint32(v.registers.Get(3)))
+}
+
+// checkReexecutedSyscall compares the results of a syscall that was
+// executed again while replaying with the recorded results
+func (v *VirtualMachine) checkReexecutedSyscall(number uint64) error {
+	record, err := v.nextRecord(number)
+	if err != nil {
+		return err
+	}
+
+	result := v.registers.Get(arch.SyscallResultRegister)
+	errno := v.registers.Get(arch.SyscallErrorRegister)
+	if result != record.result || errno != record.errno {
+		return fmt.Errorf("syscall %d returned %d (error %d), recorded %d (error %d): %w",
+			number, result, errno, record.result, record.errno, ErrReplayDiverged)
+	}
+	return nil
+}
+
+// MarshalTo writes the SyscallLog to the given io.Writer.
+//
+// The format starts with the line "orange-syscalls" followed by a 32-bit
+// version number and the 32-bit number of records, using the same encoding
+// as snapshots. Each record is:
+//
+// [syscall number] [result] [error] [# of writes] [writes: address, size, value]
+func (l *SyscallLog) MarshalTo(writer io.Writer) error {
+	w := &snapshotWriter{w: writer}
+	w.bytes([]byte(syscallLogMagic))
+	w.u32(syscallLogVersion)
+
+	w.u32(uint32(len(l.records)))
+	for _, record := range l.records {
+		w.u64(record.number)
+		w.u64(record.result)
+		w.u64(record.errno)
+		w.u32(uint32(len(record.writes)))
+		for _, write := range record.writes {
+			w.u32(write.address)
+			w.u8(uint8(write.size))
+			w.u64(write.value)
+		}
+	}
+
+	return w.err
+}
+
+// ReadSyscallLog reads a SyscallLog written by MarshalTo
+func ReadSyscallLog(reader io.Reader) (*SyscallLog, error) {
+	r := &snapshotReader{r: reader}
+
+	magic := r.bytes(len(syscallLogMagic))
+	version := r.u32()
+	if r.err != nil {
+		return nil, fmt.Errorf("read syscall log: %v: %w", r.err, ErrInvalidSyscallLog)
+	} else if string(magic) != syscallLogMagic {
+		return nil, fmt.Errorf("missing syscall log header: %w", ErrInvalidSyscallLog)
+	} else if version != syscallLogVersion {
+		return nil, fmt.Errorf("unsupported syscall log version %d: %w", version, ErrInvalidSyscallLog)
+	}
+
+	log := &SyscallLog{}
+	recordCount := r.u32()
+	for i := uint32(0); i < recordCount && r.err == nil; i++ {
+		record := syscallRecord{
+			number: r.u64(),
+			result: r.u64(),
+			errno:  r.u64(),
+		}
+		writeCount := r.u32()
+		for j := uint32(0); j < writeCount && r.err == nil; j++ {
+			record.writes = append(record.writes, memoryWrite{
+				address: r.u32(),
+				size:    uint32(r.u8()),
+				value:   r.u64(),
+			})
+		}
+		log.records = append(log.records, record)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("read syscall log: %v: %w", r.err, ErrInvalidSyscallLog)
+	}
+	return log, nil
+}
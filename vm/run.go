@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// cancelCheckInterval is the number of instructions executed between
+	// checks for cancellation of a run
+	cancelCheckInterval = 1024
+)
+
+// ErrBudgetExhausted is returned by Run when the program executed the
+// maximum number of instructions without halting
+var ErrBudgetExhausted = fmt.Errorf("instruction budget exhausted")
+
+// RunOptions limits a call to Run
+type RunOptions struct {
+	// MaxSteps is the maximum number of instructions to execute, or zero
+	// for no limit
+	MaxSteps uint64
+
+	// Timeout is the maximum wall-clock duration of the run, or zero for
+	// no limit
+	Timeout time.Duration
+
+	// Step, if set, is called after every executed instruction
+	Step func()
+}
+
+// Run executes instructions until the program halts, faults or one of the
+// limits is reached. It returns nil once the program has halted.
+//
+// If the program faults, the *Fault is returned. If MaxSteps instructions
+// were executed, ErrBudgetExhausted is returned. If ctx is cancelled or the
+// timeout passes, an error wrapping ctx.Err() or context.DeadlineExceeded
+// is returned. Unless the program faulted, the VM can be resumed by
+// calling Run again.
+func (v *VirtualMachine) Run(ctx context.Context, opts RunOptions) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// blocking syscalls stop waiting once ctx is done
+	v.runContext = ctx
+	defer func() {
+		v.runContext = nil
+	}()
+
+	var executed uint64
+	for !v.halted {
+		if opts.MaxSteps > 0 && executed >= opts.MaxSteps {
+			return fmt.Errorf("after %d instructions: %w", executed, ErrBudgetExhausted)
+		}
+
+		if executed%cancelCheckInterval == 0 || v.interrupted {
+			v.interrupted = false
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("stopped at pc 0x%08x after %d instructions: %w", v.programCounter, executed, ctx.Err())
+			default:
+			}
+		}
+
+		if err := v.ExecuteInstruction(); err != nil {
+			return err
+		}
+		executed++
+
+		if opts.Step != nil {
+			opts.Step()
+		}
+	}
+	return nil
+}
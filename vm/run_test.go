@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"context"
+	"github.com/dnsge/orange/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+// loopProgram counts up in r1 forever
+const loopProgram = `
+$loop:
+	ADDI r1, r1, #1
+	B $loop
+`
+
+func TestRun_Budget(t *testing.T) {
+	v := newTestVM(t, loopProgram)
+	err := v.Run(context.Background(), RunOptions{MaxSteps: 10})
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.EqualError(t, err, "after 10 instructions: instruction budget exhausted")
+	assert.Equal(t, uint64(5), v.Register(1))
+	assert.False(t, v.Halted())
+
+	// budgets apply per call, so runs can be resumed
+	steps := 0
+	err = v.Run(context.Background(), RunOptions{MaxSteps: 4, Step: func() { steps++ }})
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.Equal(t, 4, steps)
+	assert.Equal(t, uint64(7), v.Register(1))
+	assert.Equal(t, uint64(14), v.Steps())
+
+	// halting within the budget is not an error
+	v = newTestVM(t, "\tNOOP\n\tHALT\n")
+	assert.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 2}))
+	assert.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 2}), "halted VMs stay halted")
+	assert.Equal(t, uint64(2), v.Steps())
+}
+
+func TestRun_Timeout(t *testing.T) {
+	v := newTestVM(t, loopProgram)
+	start := time.Now()
+	err := v.Run(context.Background(), RunOptions{Timeout: 20 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, v.Halted())
+	assert.Equal(t, uint64(0), v.Steps()%cancelCheckInterval, "cancellation is checked periodically")
+
+	steps := v.Steps()
+	err = v.Run(context.Background(), RunOptions{MaxSteps: 10})
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.Equal(t, steps+10, v.Steps())
+}
+
+func TestRun_Cancel(t *testing.T) {
+	v := newTestVM(t, loopProgram)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := v.Run(ctx, RunOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.EqualError(t, err, "stopped at pc 0x00000000 after 0 instructions: context canceled")
+	assert.Equal(t, uint64(0), v.Steps())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	require.ErrorIs(t, v.Run(ctx, RunOptions{}), context.Canceled)
+	assert.NotZero(t, v.Steps())
+}
+
+// readProgram reads up to 8 bytes from stdin and halts
+const readProgram = `
+	SUBI rsp, rsp, #8
+	MOVZ r1, #0
+	MOV r2, rsp
+	MOVZ r3, #8
+	MOVZ r9, #0
+	SYSCALL
+	HALT
+`
+
+func TestRun_TimeoutReading(t *testing.T) {
+	v := newTestVM(t, readProgram)
+	stdin, input := io.Pipe()
+	defer input.Close()
+	v.SetStandardStreams(stdin, io.Discard, io.Discard)
+
+	start := time.Now()
+	err := v.Run(context.Background(), RunOptions{Timeout: 20 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, v.Halted())
+	assert.Equal(t, uint64(arch.ENO_Interrupted), v.Register(arch.SyscallErrorRegister))
+
+	// input that arrives later is returned by the next read
+	callSyscall(t, v, SyscallSbrk, 8)
+	go input.Write([]byte("hello"))
+	n, errno := callSyscall(t, v, SyscallRead, 0, heapStart, 8)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(5), n)
+	val, err := v.memory.Read(heapStart, 8)
+	require.NoError(t, err)
+	assert.Equal(t, uint64('h'), val)
+}
@@ -1,81 +1,132 @@
 package vm
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"github.com/dnsge/orange/arch"
-	"log"
+	"io"
+	"math"
+	"time"
 )
 
 const (
-	syscallRead  = 0
-	syscallWrite = 1
+	// Clocks accepted by clock_gettime
+	ClockRealtime  = 0
+	ClockMonotonic = 1
+
+	// maxRandomLength is the most bytes returned by a single getrandom call
+	maxRandomLength = 256
 )
 
 var (
 	ErrInvalidFileDescriptor = fmt.Errorf("invalid file descriptor")
+	ErrOutOfMemory           = fmt.Errorf("out of memory")
+	ErrInvalidArgument       = fmt.Errorf("invalid argument")
 )
 
-func (v *VirtualMachine) executeSyscall() {
-	syscallNumber := v.registers.Get(arch.SyscallRegister)
-	if !v.quiet {
-		log.Printf("Executing syscall number %d\n", syscallNumber)
-	}
-	switch syscallNumber {
-	case syscallRead:
-		v.syscallRead()
-	case syscallWrite:
-		v.syscallWrite()
-	default:
-		panic(fmt.Sprintf("invalid syscall number %d", syscallNumber))
-	}
-}
-
 // Available Syscalls:
 // - read(int fileD, char *buf, size_t bytes)
 // - write(int fileD, const char *buf, size_t bytes)
+// - brk(void *addr)
+// - sbrk(intptr_t increment)
+// - mmap(void *addr, size_t length, int prot)
+// - munmap(void *addr, size_t length)
+// - exit(int status)
+// - open(const char *path, int flags)
+// - close(int fileD)
+// - lseek(int fileD, off_t offset, int whence)
+// - fstat(int fileD, struct stat *buf)
+// - unlink(const char *path)
+// - clock_gettime(int clockId, struct timespec *tp)
+// - nanosleep(const struct timespec *req)
+// - getrandom(void *buf, size_t nBytes)
 
 // syscallRead performs a generic read syscall
 //
-// Semantics: write(int fileD, const void *buf, size_t nBytes)
+// Semantics: read(int fileD, void *buf, size_t nBytes)
 // fileD:  register 1
 // buf:    register 2
 // nBytes: register 3
 //
-// Reads the first nBytes from the file into buf.
-func (v *VirtualMachine) syscallRead() {
+// Reads up to nBytes from the file into buf and returns the number of bytes
+// read, which is zero at the end of the file. The remainder of buf is zeroed.
+// If the run is stopped while waiting for input on a standard stream, the
+// syscall fails as interrupted.
+func (v *VirtualMachine) syscallRead() error {
 	fileD := int(v.registers.Get(1))
 	bufPtr := uint32(v.registers.Get(2))
 	nBytes := uint32(v.registers.Get(3))
 
-	err := v.syscallReadExecute(fileD, bufPtr, nBytes)
+	n, err := v.syscallReadExecute(fileD, bufPtr, nBytes)
 	if err != nil {
-		if errors.Is(err, ErrInvalidFileDescriptor) {
-			v.setSyscallError(arch.ENO_BadFileDescriptor)
-		} else {
-			v.setSyscallError(arch.ENO_IO)
-		}
-		log.Printf("error: %v\n", err)
+		return err
 	}
+	v.setSyscallResult(uint64(n))
+	return nil
 }
 
-func (v *VirtualMachine) syscallReadExecute(fileD int, bufPtr uint32, nBytes uint32) error {
+func (v *VirtualMachine) syscallReadExecute(fileD int, bufPtr uint32, nBytes uint32) (int, error) {
 	file, ok := v.fds[fileD]
 	if !ok {
-		return ErrInvalidFileDescriptor
+		return 0, ErrInvalidFileDescriptor
 	}
 
 	buf := make([]byte, nBytes)
-	_, err := file.Read(buf)
-	if err != nil {
-		return err // todo: EOF
+	var n int
+	var err error
+	if file.name == "" {
+		n, err = v.readStream(file, buf)
+	} else {
+		n, err = file.rw.Read(buf)
+	}
+	if err != nil && err != io.EOF {
+		return 0, err
 	}
 
 	for i := uint32(0); i < nBytes; i++ {
-		v.memory.Write(bufPtr+i, 8, uint64(buf[i]))
+		if err := v.memory.Write(bufPtr+i, 8, uint64(buf[i])); err != nil {
+			return 0, err
+		}
 	}
 
-	return nil
+	return n, nil
+}
+
+// readStream reads from a standard stream like stdin, which may block until
+// the host provides input. The read happens on its own goroutine so that
+// it is interrupted when the run is stopped; its data is then returned by
+// the next read of the stream.
+func (v *VirtualMachine) readStream(file *fileDescriptor, buf []byte) (int, error) {
+	if len(file.unread) == 0 {
+		if file.pending == nil {
+			pending := make(chan streamRead, 1)
+			go func(size int) {
+				data := make([]byte, size)
+				n, err := file.rw.Read(data)
+				pending <- streamRead{data: data[:n], err: err}
+			}(len(buf))
+			file.pending = pending
+		}
+
+		ctx := v.runContext
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		select {
+		case result := <-file.pending:
+			file.pending = nil
+			if result.err != nil && result.err != io.EOF {
+				return 0, result.err
+			}
+			file.unread = result.data
+		case <-ctx.Done():
+			v.interrupted = true
+			return 0, fmt.Errorf("read: %w", ctx.Err())
+		}
+	}
+
+	n := copy(buf, file.unread)
+	file.unread = file.unread[n:]
+	return n, nil
 }
 
 // syscallWrite performs a generic write syscall
@@ -85,21 +136,18 @@ func (v *VirtualMachine) syscallReadExecute(fileD int, bufPtr uint32, nBytes uin
 // buf:    register 2
 // nBytes: register 3
 //
-// Writes the first nBytes bytes of buf to the file.
-func (v *VirtualMachine) syscallWrite() {
+// Writes the first nBytes bytes of buf to the file and returns nBytes.
+func (v *VirtualMachine) syscallWrite() error {
 	fileD := int(v.registers.Get(1))
 	bufPtr := uint32(v.registers.Get(2))
 	nBytes := uint32(v.registers.Get(3))
 
 	err := v.syscallWriteExecute(fileD, bufPtr, nBytes)
 	if err != nil {
-		if errors.Is(err, ErrInvalidFileDescriptor) {
-			v.setSyscallError(arch.ENO_BadFileDescriptor)
-		} else {
-			v.setSyscallError(arch.ENO_IO)
-		}
-		log.Printf("error: %v\n", err)
+		return err
 	}
+	v.setSyscallResult(uint64(nBytes))
+	return nil
 }
 
 func (v *VirtualMachine) syscallWriteExecute(fileD int, bufPtr uint32, nBytes uint32) error {
@@ -109,9 +157,12 @@ func (v *VirtualMachine) syscallWriteExecute(fileD int, bufPtr uint32, nBytes ui
 	}
 
 	for i := uint32(0); i < nBytes; i++ {
-		data := v.memory.Read(bufPtr, 8) // read single byte
+		data, err := v.memory.Read(bufPtr, 8) // read single byte
+		if err != nil {
+			return err
+		}
 		singleByte := byte(data)
-		_, err := file.Write([]byte{singleByte})
+		_, err = file.rw.Write([]byte{singleByte})
 		if err != nil {
 			return fmt.Errorf("syscall write: %w", err)
 		}
@@ -121,6 +172,116 @@ func (v *VirtualMachine) syscallWriteExecute(fileD int, bufPtr uint32, nBytes ui
 	return nil
 }
 
-func (v *VirtualMachine) setSyscallError(eno uint64) {
-	v.registers.Set(arch.SyscallErrorRegister, eno)
+// syscallExit stops the program
+//
+// Semantics: exit(int status)
+// status: register 1
+//
+// Halts the VM, recording status as the exit code of the program.
+func (v *VirtualMachine) syscallExit() error {
+	v.exitCode = int(int64(v.registers.Get(1)))
+	v.Halt()
+	return nil
+}
+
+// syscallClockGettime reads a clock
+//
+// Semantics: clock_gettime(int clockId, struct timespec *tp)
+// clockId: register 1 (0 = realtime, 1 = monotonic)
+// tp:      register 2
+//
+// Writes the time as seconds (offset 0) and nanoseconds (offset 8) to tp.
+// The realtime clock counts from the Unix epoch, the monotonic clock from
+// the start of the program.
+func (v *VirtualMachine) syscallClockGettime() error {
+	clockID := v.registers.Get(1)
+	tpPtr := uint32(v.registers.Get(2))
+
+	var elapsed time.Duration
+	switch clockID {
+	case ClockRealtime:
+		elapsed = time.Duration(v.clock.Now().UnixNano())
+	case ClockMonotonic:
+		elapsed = v.clock.Now().Sub(v.clockStart)
+	default:
+		return fmt.Errorf("clock_gettime clock %d: %w", clockID, ErrInvalidArgument)
+	}
+
+	if err := v.writeTimespec(tpPtr, elapsed); err != nil {
+		return err
+	}
+	v.setSyscallResult(0)
+	return nil
+}
+
+// syscallNanosleep pauses the program
+//
+// Semantics: nanosleep(const struct timespec *req)
+// req: register 1
+//
+// Sleeps for the seconds (offset 0) and nanoseconds (offset 8) in req. If
+// the run is stopped while sleeping, the syscall fails as interrupted.
+func (v *VirtualMachine) syscallNanosleep() error {
+	reqPtr := uint32(v.registers.Get(1))
+
+	seconds, err := v.memory.Read(reqPtr, 64)
+	if err != nil {
+		return err
+	}
+	nanoseconds, err := v.memory.Read(reqPtr+8, 64)
+	if err != nil {
+		return err
+	}
+
+	// the whole duration must fit into a time.Duration
+	if nanoseconds >= uint64(time.Second) || seconds > (math.MaxInt64-nanoseconds)/uint64(time.Second) {
+		return fmt.Errorf("nanosleep %d.%09d: %w", seconds, nanoseconds, ErrInvalidArgument)
+	}
+
+	ctx := v.runContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := v.clock.Sleep(ctx, time.Duration(seconds)*time.Second+time.Duration(nanoseconds)); err != nil {
+		v.interrupted = true
+		return fmt.Errorf("nanosleep: %w", err)
+	}
+	v.setSyscallResult(0)
+	return nil
+}
+
+// syscallGetrandom fills a buffer with random bytes
+//
+// Semantics: getrandom(void *buf, size_t nBytes)
+// buf:    register 1
+// nBytes: register 2
+//
+// Returns the number of bytes written, which is at most 256.
+func (v *VirtualMachine) syscallGetrandom() error {
+	bufPtr := uint32(v.registers.Get(1))
+	nBytes := v.registers.Get(2)
+	if nBytes > maxRandomLength {
+		nBytes = maxRandomLength
+	}
+
+	buf := make([]byte, nBytes)
+	if _, err := io.ReadFull(v.random, buf); err != nil {
+		return err
+	}
+
+	for i, b := range buf {
+		if err := v.memory.Write(bufPtr+uint32(i), 8, uint64(b)); err != nil {
+			return err
+		}
+	}
+	v.setSyscallResult(nBytes)
+	return nil
+}
+
+// writeTimespec writes d as seconds and nanoseconds to address
+func (v *VirtualMachine) writeTimespec(address uint32, d time.Duration) error {
+	if err := v.memory.Write(address, 64, uint64(d/time.Second)); err != nil {
+		return err
+	}
+	return v.memory.Write(address+8, 64, uint64(d%time.Second))
 }
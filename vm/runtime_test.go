@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRuntime_Exit(t *testing.T) {
+	v := newTestVM(t, `
+	MOVZ r1, #42
+	MOVZ r9, #6
+	SYSCALL
+	MOVZ r2, #1
+	HALT
+`)
+	require.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 100}))
+	assert.True(t, v.Halted())
+	assert.Equal(t, 42, v.ExitCode())
+	assert.Equal(t, uint64(0), v.Register(2), "exit stops the program immediately")
+
+	// the status is signed
+	v = newTestVM(t, `
+	MOVZ r1, #0
+	SUBI r1, r1, #3
+	MOVZ r9, #6
+	SYSCALL
+`)
+	require.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 100}))
+	assert.Equal(t, -3, v.ExitCode())
+
+	// halting without exit is success
+	v = newTestVM(t, "\tMOVZ r1, #42\n\tHALT\n")
+	require.NoError(t, v.Run(context.Background(), RunOptions{MaxSteps: 100}))
+	assert.Equal(t, 0, v.ExitCode())
+}
@@ -0,0 +1,484 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/dnsge/orange/memory"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	snapshotMagic   = "orange-snapshot\n"
+	snapshotVersion = 3
+
+	// flag bits of the ALU flags in a serialized snapshot
+	snapshotFlagNegative = 1 << 0
+	snapshotFlagZero     = 1 << 1
+	snapshotFlagCarry    = 1 << 2
+)
+
+var (
+	ErrInvalidSnapshot = fmt.Errorf("invalid snapshot")
+)
+
+// Snapshot is the complete state of a VirtualMachine at an instruction
+// boundary. The clock, source of randomness, syscall handlers and file system
+// belong to the VM a snapshot is restored into and are not included, but the
+// time seen by the program is: the start of its monotonic clock and the time
+// a deterministic clock was advanced by sleeping, as is the state of the
+// random number generator of a deterministic VM.
+type Snapshot struct {
+	PC        uint32
+	Registers [16]uint64
+	Negative  bool
+	Zero      bool
+	Carry     bool
+	Halted    bool
+	ExitCode  int64
+	Steps     uint64
+
+	ClockStart time.Time
+	Slept      time.Duration
+	Random     uint64
+
+	Stack     Stack
+	HeapStart uint32
+	HeapBreak uint32
+	// Mappings maps the start address of each anonymous mapping to its size
+	Mappings map[uint32]uint32
+
+	Regions []memory.Region
+	Files   []SnapshotFile
+}
+
+// SnapshotFile describes an open file descriptor. Files opened by the
+// program are reopened by name when restoring, standard streams have no
+// name.
+type SnapshotFile struct {
+	FD     int
+	Name   string
+	Flags  int
+	Offset int64
+}
+
+// Snapshot captures the state of the VM. The memory must implement
+// memory.Snapshotter.
+func (v *VirtualMachine) Snapshot() (*Snapshot, error) {
+	mem, ok := v.memory.Addressable.(memory.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("memory does not support snapshots")
+	}
+
+	s := &Snapshot{
+		PC:         v.programCounter,
+		Registers:  v.registers,
+		Negative:   v.alu.flags.Negative,
+		Zero:       v.alu.flags.Zero,
+		Carry:      v.alu.flags.Carry,
+		Halted:     v.halted,
+		ExitCode:   int64(v.exitCode),
+		Steps:      v.steps,
+		ClockStart: v.clockStart,
+		Stack:      v.stack,
+		HeapStart:  v.heap.start,
+		HeapBreak:  v.heap.brk,
+		Mappings:   make(map[uint32]uint32, len(v.heap.mappings)),
+		Regions:    mem.Regions(),
+	}
+
+	for address, size := range v.heap.mappings {
+		s.Mappings[address] = size
+	}
+	if clock, ok := v.clock.(*stepClock); ok {
+		s.Slept = clock.slept
+	}
+	if random, ok := v.random.(*seededRandom); ok {
+		s.Random = random.state
+	}
+
+	for fd, desc := range v.fds {
+		file := SnapshotFile{
+			FD:    fd,
+			Name:  desc.name,
+			Flags: desc.flags,
+		}
+		if desc.name != "" {
+			if seeker, ok := desc.rw.(io.Seeker); ok {
+				offset, err := seeker.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, fmt.Errorf("snapshot fd %d: %w", fd, err)
+				}
+				file.Offset = offset
+			}
+		}
+		s.Files = append(s.Files, file)
+	}
+	sort.Slice(s.Files, func(i, j int) bool {
+		return s.Files[i].FD < s.Files[j].FD
+	})
+
+	return s, nil
+}
+
+// Restore replaces the state of the VM with the snapshot. Files opened by
+// the program are reopened through the VM's FileSystem. If restoring fails,
+// the VM is left unchanged.
+func (v *VirtualMachine) Restore(s *Snapshot) error {
+	mem, ok := v.memory.Addressable.(memory.Snapshotter)
+	if !ok {
+		return fmt.Errorf("memory does not support snapshots")
+	}
+
+	fds, err := v.reopenFiles(s.Files)
+	if err != nil {
+		return err
+	}
+
+	if err := mem.RestoreRegions(s.Regions); err != nil {
+		closeFiles(fds)
+		return err
+	}
+
+	closeFiles(v.fds)
+	v.fds = fds
+
+	v.programCounter = s.PC
+	v.registers = s.Registers
+	v.alu.flags = aluFlags{
+		Negative: s.Negative,
+		Zero:     s.Zero,
+		Carry:    s.Carry,
+	}
+	v.halted = s.Halted
+	v.exitCode = int(s.ExitCode)
+	v.steps = s.Steps
+	v.clockStart = s.ClockStart
+	if clock, ok := v.clock.(*stepClock); ok {
+		clock.slept = s.Slept
+	}
+	if random, ok := v.random.(*seededRandom); ok {
+		random.state = s.Random
+	}
+	v.stack = s.Stack
+	v.heap = heap{
+		start:    s.HeapStart,
+		brk:      s.HeapBreak,
+		mappings: make(map[uint32]uint32, len(s.Mappings)),
+	}
+	for address, size := range s.Mappings {
+		v.heap.mappings[address] = size
+	}
+
+	if v.history != nil {
+		// the undo log does not apply to the restored state
+		v.history.clear()
+	}
+	return nil
+}
+
+// reopenFiles builds a file descriptor table from the files of a snapshot
+func (v *VirtualMachine) reopenFiles(files []SnapshotFile) (map[int]*fileDescriptor, error) {
+	standard := v.standardFileDescriptors()
+	fds := make(map[int]*fileDescriptor, len(files))
+
+	for _, file := range files {
+		if file.Name == "" {
+			desc, ok := standard[file.FD]
+			if !ok {
+				closeFiles(fds)
+				return nil, fmt.Errorf("restore fd %d: not a standard stream: %w", file.FD, ErrInvalidSnapshot)
+			}
+			fds[file.FD] = desc
+			continue
+		}
+
+		if v.fileSystem == nil {
+			closeFiles(fds)
+			return nil, fmt.Errorf("restore fd %d (%s): %w", file.FD, file.Name, ErrNoFileSystem)
+		}
+
+		// the file already exists and must keep its contents
+		flags := file.Flags &^ (OpenCreate | OpenExclusive | OpenTruncate)
+		opened, err := v.fileSystem.OpenFile(file.Name, flags)
+		if err != nil {
+			closeFiles(fds)
+			return nil, fmt.Errorf("restore fd %d: %w", file.FD, err)
+		}
+		if _, err := opened.Seek(file.Offset, io.SeekStart); err != nil {
+			_ = opened.Close()
+			closeFiles(fds)
+			return nil, fmt.Errorf("restore fd %d: %w", file.FD, err)
+		}
+
+		fds[file.FD] = &fileDescriptor{
+			rw:    opened,
+			name:  file.Name,
+			flags: file.Flags,
+		}
+	}
+	return fds, nil
+}
+
+// closeFiles closes the files opened by the program in a file descriptor table
+func closeFiles(fds map[int]*fileDescriptor) {
+	for _, desc := range fds {
+		if desc.name == "" {
+			continue
+		}
+		if closer, ok := desc.rw.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+// MarshalTo writes the Snapshot to the given io.Writer.
+//
+// The format starts with the line "orange-snapshot" followed by a 32-bit
+// version number. All numbers are little endian, strings and byte slices
+// are prefixed with their 32-bit length, and collections with their 32-bit
+// count:
+//
+// [pc] [16 registers] [flags byte: negative, zero, carry] [halted byte]
+// [exit code] [steps] [clock start, Unix nanoseconds] [slept nanoseconds]
+// [random state]
+// [stack bottom] [stack top] [heap start] [heap break]
+// [mappings: address, size] [regions: start, size, permissions, reserved byte, data]
+// [files: fd, name, flags, offset]
+func (s *Snapshot) MarshalTo(writer io.Writer) error {
+	w := &snapshotWriter{w: writer}
+	w.bytes([]byte(snapshotMagic))
+	w.u32(snapshotVersion)
+
+	w.u32(s.PC)
+	for _, reg := range s.Registers {
+		w.u64(reg)
+	}
+
+	var flags uint8
+	if s.Negative {
+		flags |= snapshotFlagNegative
+	}
+	if s.Zero {
+		flags |= snapshotFlagZero
+	}
+	if s.Carry {
+		flags |= snapshotFlagCarry
+	}
+	w.u8(flags)
+	w.bool(s.Halted)
+	w.u64(uint64(s.ExitCode))
+	w.u64(s.Steps)
+	w.u64(uint64(s.ClockStart.UnixNano()))
+	w.u64(uint64(s.Slept))
+	w.u64(s.Random)
+
+	w.u32(s.Stack.Bottom)
+	w.u32(s.Stack.Top)
+	w.u32(s.HeapStart)
+	w.u32(s.HeapBreak)
+
+	addresses := make([]uint32, 0, len(s.Mappings))
+	for address := range s.Mappings {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i] < addresses[j]
+	})
+	w.u32(uint32(len(addresses)))
+	for _, address := range addresses {
+		w.u32(address)
+		w.u32(s.Mappings[address])
+	}
+
+	w.u32(uint32(len(s.Regions)))
+	for _, region := range s.Regions {
+		w.u32(region.Start)
+		w.u32(region.Size)
+		w.u8(uint8(region.Perm))
+		w.bool(region.Reserved)
+		if !region.Reserved {
+			w.lengthPrefixed(region.Data)
+		}
+	}
+
+	w.u32(uint32(len(s.Files)))
+	for _, file := range s.Files {
+		w.u32(uint32(file.FD))
+		w.lengthPrefixed([]byte(file.Name))
+		w.u32(uint32(file.Flags))
+		w.u64(uint64(file.Offset))
+	}
+
+	return w.err
+}
+
+// ReadSnapshot reads a Snapshot written by MarshalTo
+func ReadSnapshot(reader io.Reader) (*Snapshot, error) {
+	r := &snapshotReader{r: reader}
+
+	magic := r.bytes(len(snapshotMagic))
+	version := r.u32()
+	if r.err != nil {
+		return nil, r.err
+	} else if string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("missing snapshot header: %w", ErrInvalidSnapshot)
+	} else if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d: %w", version, ErrInvalidSnapshot)
+	}
+
+	s := &Snapshot{}
+	s.PC = r.u32()
+	for i := range s.Registers {
+		s.Registers[i] = r.u64()
+	}
+
+	flags := r.u8()
+	s.Negative = flags&snapshotFlagNegative != 0
+	s.Zero = flags&snapshotFlagZero != 0
+	s.Carry = flags&snapshotFlagCarry != 0
+	s.Halted = r.bool()
+	s.ExitCode = int64(r.u64())
+	s.Steps = r.u64()
+	s.ClockStart = time.Unix(0, int64(r.u64()))
+	s.Slept = time.Duration(r.u64())
+	s.Random = r.u64()
+
+	s.Stack.Bottom = r.u32()
+	s.Stack.Top = r.u32()
+	s.HeapStart = r.u32()
+	s.HeapBreak = r.u32()
+
+	mappingCount := r.u32()
+	s.Mappings = make(map[uint32]uint32)
+	for i := uint32(0); i < mappingCount && r.err == nil; i++ {
+		address := r.u32()
+		s.Mappings[address] = r.u32()
+	}
+
+	regionCount := r.u32()
+	for i := uint32(0); i < regionCount && r.err == nil; i++ {
+		region := memory.Region{
+			Start:    r.u32(),
+			Size:     r.u32(),
+			Perm:     memory.Permission(r.u8()),
+			Reserved: r.bool(),
+		}
+		if !region.Reserved {
+			region.Data = r.lengthPrefixed()
+		}
+		s.Regions = append(s.Regions, region)
+	}
+
+	fileCount := r.u32()
+	for i := uint32(0); i < fileCount && r.err == nil; i++ {
+		s.Files = append(s.Files, SnapshotFile{
+			FD:     int(r.u32()),
+			Name:   string(r.lengthPrefixed()),
+			Flags:  int(r.u32()),
+			Offset: int64(r.u64()),
+		})
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("read snapshot: %v: %w", r.err, ErrInvalidSnapshot)
+	}
+	return s, nil
+}
+
+// snapshotWriter writes binary data, remembering the first error
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *snapshotWriter) bytes(data []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(data)
+	}
+}
+
+func (w *snapshotWriter) u8(val uint8) {
+	w.bytes([]byte{val})
+}
+
+func (w *snapshotWriter) bool(val bool) {
+	if val {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+
+func (w *snapshotWriter) u32(val uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], val)
+	w.bytes(buf[:])
+}
+
+func (w *snapshotWriter) u64(val uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], val)
+	w.bytes(buf[:])
+}
+
+func (w *snapshotWriter) lengthPrefixed(data []byte) {
+	w.u32(uint32(len(data)))
+	w.bytes(data)
+}
+
+// snapshotReader reads binary data, remembering the first error
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	// grow the buffer while reading rather than trusting n up front
+	buf := new(bytes.Buffer)
+	read, err := buf.ReadFrom(io.LimitReader(r.r, int64(n)))
+	if err != nil {
+		r.err = err
+	} else if read != int64(n) {
+		r.err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes()
+}
+
+func (r *snapshotReader) u8() uint8 {
+	buf := r.bytes(1)
+	if r.err != nil {
+		return 0
+	}
+	return buf[0]
+}
+
+func (r *snapshotReader) bool() bool {
+	return r.u8() != 0
+}
+
+func (r *snapshotReader) u32() uint32 {
+	buf := r.bytes(4)
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(buf)
+}
+
+func (r *snapshotReader) u64() uint64 {
+	buf := r.bytes(8)
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(buf)
+}
+
+func (r *snapshotReader) lengthPrefixed() []byte {
+	n := r.u32()
+	return r.bytes(int(n))
+}
@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newSnapshotVM returns a deterministic VM running loopProgram with files
+// from dir
+func newSnapshotVM(t *testing.T, dir string) *VirtualMachine {
+	t.Helper()
+
+	v := newTestVM(t, loopProgram)
+	v.SetDeterministic(3)
+	fileSystem, err := DirFS(dir)
+	require.NoError(t, err)
+	v.SetFileSystem(fileSystem)
+	return v
+}
+
+// writeString writes s and a null terminator to address
+func writeString(t *testing.T, v *VirtualMachine, address uint32, s string) {
+	t.Helper()
+
+	for i := 0; i <= len(s); i++ {
+		var b uint64
+		if i < len(s) {
+			b = uint64(s[i])
+		}
+		require.NoError(t, v.memory.Write(address+uint32(i), 8, b))
+	}
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0644))
+
+	v := newSnapshotVM(t, dir)
+	require.ErrorIs(t, v.Run(context.Background(), RunOptions{MaxSteps: 100}), ErrBudgetExhausted)
+
+	callSyscall(t, v, SyscallSbrk, 0x20)
+	writeString(t, v, heapStart, "data.txt")
+	fd, errno := callSyscall(t, v, SyscallOpen, heapStart, OpenReadWrite)
+	require.Equal(t, uint64(0), errno)
+	callSyscall(t, v, SyscallLseek, fd, 2, 0)
+	mapping, errno := callSyscall(t, v, SyscallMmap, 0, 0x1000, 3)
+	require.Equal(t, uint64(0), errno)
+	require.NoError(t, v.memory.Write(uint32(mapping)+8, 64, 42))
+	require.NoError(t, v.writeTimespec(heapStart+0x10, 5*time.Second))
+	callSyscall(t, v, SyscallNanosleep, heapStart+0x10)
+	callSyscall(t, v, SyscallGetrandom, heapStart+0x10, 16)
+
+	snapshot, err := v.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, snapshot.Slept)
+	assert.True(t, snapshot.ClockStart.Equal(time.Unix(0, 0)))
+
+	var buf bytes.Buffer
+	require.NoError(t, snapshot.MarshalTo(&buf))
+	read, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, read)
+
+	restored := newSnapshotVM(t, dir)
+	require.NoError(t, restored.Restore(read))
+	assert.Equal(t, v.PC(), restored.PC())
+	assert.Equal(t, v.Steps(), restored.Steps())
+	assert.Equal(t, v.clock.Now(), restored.clock.Now())
+
+	for _, clockID := range []uint64{0, 1} {
+		callSyscall(t, v, SyscallClockGettime, clockID, heapStart+0x10)
+		callSyscall(t, restored, SyscallClockGettime, clockID, heapStart+0x10)
+		assert.Equal(t, readTimespec(t, v, heapStart+0x10), readTimespec(t, restored, heapStart+0x10))
+	}
+
+	// both generate the same random numbers
+	var random [2][2]uint64
+	for i, machine := range []*VirtualMachine{v, restored} {
+		n, errno := callSyscall(t, machine, SyscallGetrandom, heapStart+0x10, 16)
+		require.Equal(t, uint64(0), errno)
+		require.Equal(t, uint64(16), n)
+		for j := range random[i] {
+			random[i][j], err = machine.memory.Read(heapStart+0x10+uint32(j)*8, 64)
+			require.NoError(t, err)
+		}
+	}
+	assert.Equal(t, random[0], random[1])
+
+	brk, _ := callSyscall(t, restored, SyscallBrk, 0)
+	assert.Equal(t, uint64(heapStart+0x20), brk)
+	val, err := restored.memory.Read(uint32(mapping)+8, 64)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), val)
+	offset, errno := callSyscall(t, restored, SyscallLseek, fd, 0, 1)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(2), offset)
+	for _, machine := range []*VirtualMachine{v, restored} {
+		_, errno = callSyscall(t, machine, SyscallMunmap, mapping, 0x1000)
+		assert.Equal(t, uint64(0), errno)
+	}
+
+	// both continue identically
+	require.ErrorIs(t, v.Run(context.Background(), RunOptions{MaxSteps: 50}), ErrBudgetExhausted)
+	require.ErrorIs(t, restored.Run(context.Background(), RunOptions{MaxSteps: 50}), ErrBudgetExhausted)
+	assert.Equal(t, v.Register(1), restored.Register(1))
+	assert.Equal(t, v.clock.Now(), restored.clock.Now())
+}
+
+func TestReadSnapshot_Invalid(t *testing.T) {
+	v := newTestVM(t, loopProgram)
+	snapshot, err := v.Snapshot()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, snapshot.MarshalTo(&buf))
+	data := buf.Bytes()
+
+	_, err = ReadSnapshot(bytes.NewReader(data[:len(data)-1]))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	old := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(old[len(snapshotMagic):], 1)
+	_, err = ReadSnapshot(bytes.NewReader(old))
+	assert.EqualError(t, err, "unsupported snapshot version 1: invalid snapshot")
+
+	_, err = ReadSnapshot(bytes.NewReader([]byte("not a snapshot at all")))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
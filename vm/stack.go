@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/memory"
+	"math"
+)
+
+const (
+	// StackGuardSize is the size of the unmapped regions placed directly
+	// below and above the stack. It is large enough that a single SUBI on
+	// the stack pointer cannot skip over the lower guard.
+	StackGuardSize = 0x10000
+
+	// maxCallChainLength limits the number of frames reported in a fault
+	maxCallChainLength = 64
+)
+
+var (
+	// ErrStackOutOfRange is returned by MapStack for stacks whose guard
+	// regions would not fit within the address space
+	ErrStackOutOfRange = fmt.Errorf("stack guard regions exceed the address space")
+)
+
+// Stack describes the region of memory mapped for the stack, which
+// contains the addresses [Bottom, Top).
+type Stack struct {
+	Bottom uint32
+	Top    uint32
+}
+
+// Size returns the size of the stack in bytes
+func (s Stack) Size() uint32 {
+	return s.Top - s.Bottom
+}
+
+// InOverflowGuard returns whether the address lies in the guard region
+// directly below the stack.
+func (s Stack) InOverflowGuard(address uint32) bool {
+	return uint64(address)+StackGuardSize >= uint64(s.Bottom) && address < s.Bottom
+}
+
+// InUnderflowGuard returns whether the address lies in the guard region
+// directly above the stack.
+func (s Stack) InUnderflowGuard(address uint32) bool {
+	return address >= s.Top && uint64(address) < uint64(s.Top)+StackGuardSize
+}
+
+// MapStack allocates a read-write stack of size bytes that ends at top and
+// reserves unmapped guard regions on either side of it. The stack and its
+// guards must fit within the address space.
+func MapStack(mem *memory.Memory, top uint32, size uint32) (Stack, error) {
+	if uint64(size)+StackGuardSize > uint64(top) {
+		return Stack{}, fmt.Errorf("stack of %d bytes ending at 0x%08x: %w", size, top, ErrStackOutOfRange)
+	} else if uint64(top)+StackGuardSize > math.MaxUint32+1 {
+		return Stack{}, fmt.Errorf("stack ending at 0x%08x: %w", top, ErrStackOutOfRange)
+	}
+
+	stack := Stack{
+		Bottom: top - size,
+		Top:    top,
+	}
+
+	if err := mem.Alloc(stack.Bottom, size, memory.PermReadWrite); err != nil {
+		return Stack{}, err
+	}
+	if err := mem.Reserve(stack.Bottom-StackGuardSize, StackGuardSize); err != nil {
+		return Stack{}, err
+	}
+	if err := mem.Reserve(stack.Top, StackGuardSize); err != nil {
+		return Stack{}, err
+	}
+
+	return stack, nil
+}
+
+// classifyStackFault turns faults caused by touching a stack guard region
+// into stack overflow or underflow faults, recording the stack depth and
+// the call chain at the time of the fault.
+func (v *VirtualMachine) classifyStackFault(fault *Fault) {
+	if v.stack.Size() == 0 || fault.Kind != FaultSegmentation {
+		return
+	}
+
+	if v.stack.InOverflowGuard(fault.Address) {
+		fault.Kind = FaultStackOverflow
+	} else if v.stack.InUnderflowGuard(fault.Address) {
+		fault.Kind = FaultStackUnderflow
+	} else {
+		return
+	}
+
+	sp := v.registers.Get(arch.StackRegister)
+	fault.StackDepth = int64(v.stack.Top) - int64(sp)
+	fault.CallChain = v.callChain()
+}
+
+// callChain reconstructs the chain of call sites leading to the current
+// instruction from the return register and the return addresses saved on
+// the stack, innermost first.
+//
+// Since frames are not explicitly linked, any value on the stack that
+// points directly after a BL or BLR instruction is treated as a saved
+// return address.
+func (v *VirtualMachine) callChain() []uint32 {
+	var chain []uint32
+	addReturn := func(returnAddress uint64) {
+		if len(chain) < maxCallChainLength && v.isReturnAddress(returnAddress) {
+			chain = append(chain, uint32(returnAddress)-4)
+		}
+	}
+
+	rrp := v.registers.Get(arch.ReturnRegister)
+	addReturn(rrp)
+	rrpSaved := len(chain) == 0
+
+	sp := v.registers.Get(arch.StackRegister)
+	if sp < uint64(v.stack.Bottom) {
+		sp = uint64(v.stack.Bottom)
+	}
+	for address := sp; address+8 <= uint64(v.stack.Top); address += 8 {
+		val, err := v.memory.Read(uint32(address), 64)
+		if err != nil {
+			break
+		}
+		if !rrpSaved && val == rrp {
+			// the current function saved rrp before calling further, so
+			// this frame was already added
+			rrpSaved = true
+			continue
+		}
+		addReturn(val)
+	}
+
+	return chain
+}
+
+// isReturnAddress returns whether the address directly follows a call
+func (v *VirtualMachine) isReturnAddress(address uint64) bool {
+	if address < 4 || address > math.MaxUint32 || address%4 != 0 {
+		return false
+	}
+
+	i, err := v.memory.Fetch(uint32(address) - 4)
+	if err != nil {
+		return false
+	}
+
+	opcode := arch.GetOpcode(i)
+	return opcode == arch.BL || opcode == arch.BLR
+}
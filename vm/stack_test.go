@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMapStack(t *testing.T) {
+	mem := memory.New()
+	stack, err := MapStack(mem, testStackTop, testStackSize)
+	require.NoError(t, err)
+	assert.Equal(t, Stack{Bottom: testStackTop - testStackSize, Top: testStackTop}, stack)
+	assert.True(t, stack.InOverflowGuard(stack.Bottom-1))
+	assert.True(t, stack.InOverflowGuard(stack.Bottom-StackGuardSize))
+	assert.False(t, stack.InOverflowGuard(stack.Bottom-StackGuardSize-1))
+	assert.True(t, stack.InUnderflowGuard(stack.Top))
+	assert.False(t, stack.InUnderflowGuard(stack.Top+StackGuardSize))
+
+	// the guards stay unmapped
+	assert.ErrorIs(t, mem.Alloc(stack.Top, 8, memory.PermReadWrite), memory.ErrOverlappingRegion)
+
+	_, err = MapStack(memory.New(), 0x20000, 0x10008)
+	assert.ErrorIs(t, err, ErrStackOutOfRange)
+	_, err = MapStack(memory.New(), 0xffff8000, 0x1000)
+	assert.ErrorIs(t, err, ErrStackOutOfRange)
+	_, err = MapStack(memory.New(), 0xffff0000, 0x1000)
+	assert.NoError(t, err)
+}
+
+func TestStack_Overflow(t *testing.T) {
+	fault := runFault(t, `
+$main:
+	BL $recurse
+	HALT
+$recurse:
+	PUSH rrp
+	BL $recurse
+`)
+	assert.Equal(t, FaultStackOverflow, fault.Kind)
+	assert.Equal(t, uint32(0x8), fault.PC)
+	assert.Equal(t, uint32(testStackTop-testStackSize-8), fault.Address)
+	assert.Equal(t, int64(testStackSize), fault.StackDepth)
+	assert.Len(t, fault.CallChain, maxCallChainLength)
+	assert.Equal(t, uint32(0xc), fault.CallChain[0])
+	assert.Equal(t, uint32(0xc), fault.CallChain[1])
+}
+
+func TestStack_Underflow(t *testing.T) {
+	fault := runFault(t, `
+	PUSH r0
+	POP r1
+	POP r1
+`)
+	assert.Equal(t, FaultStackUnderflow, fault.Kind)
+	assert.Equal(t, uint32(0x8), fault.PC)
+	assert.Equal(t, uint32(testStackTop), fault.Address)
+	assert.Equal(t, int64(0), fault.StackDepth)
+	assert.Equal(t, "stack underflow at pc 0x00000008 (address 0x80000000, stack depth 0 bytes)", fault.Error())
+
+	// moving the stack pointer far beyond the guard is a plain
+	// segmentation fault
+	fault = runFault(t, `
+	MOVZ r1, #1
+	LSL r1, #24
+	SUB rsp, rsp, r1
+	STREG r0, [rsp]
+`)
+	assert.Equal(t, FaultSegmentation, fault.Kind)
+}
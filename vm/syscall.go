@@ -0,0 +1,183 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/memory"
+	"io/fs"
+	"log"
+	"syscall"
+)
+
+// Numbers of the built-in syscalls
+const (
+	SyscallRead         = 0
+	SyscallWrite        = 1
+	SyscallBrk          = 2
+	SyscallSbrk         = 3
+	SyscallMmap         = 4
+	SyscallMunmap       = 5
+	SyscallExit         = 6
+	SyscallOpen         = 7
+	SyscallClose        = 8
+	SyscallLseek        = 9
+	SyscallFstat        = 10
+	SyscallUnlink       = 11
+	SyscallClockGettime = 12
+	SyscallNanosleep    = 13
+	SyscallGetrandom    = 14
+)
+
+var ErrNoSyscall = fmt.Errorf("no such syscall")
+
+// SyscallHandler implements a syscall.
+//
+// Arguments are passed in registers 1 through 6 and the result should be
+// placed in the syscall result register (r7). If HandleSyscall returns an
+// error, the corresponding error number is placed in the syscall error
+// register (r8), which is cleared before the handler is called.
+type SyscallHandler interface {
+	HandleSyscall(v *VirtualMachine) error
+}
+
+// SyscallHandlerFunc is a function that implements SyscallHandler
+type SyscallHandlerFunc func(v *VirtualMachine) error
+
+func (f SyscallHandlerFunc) HandleSyscall(v *VirtualMachine) error {
+	return f(v)
+}
+
+// Errno is an error that is reported to programs as the given error number.
+// Handlers can return it to report errors not covered by the built-in ones.
+type Errno uint64
+
+func (e Errno) Error() string {
+	return fmt.Sprintf("errno %d", uint64(e))
+}
+
+// builtinSyscalls are the syscalls registered in every VirtualMachine
+var builtinSyscalls = map[uint64]SyscallHandlerFunc{
+	SyscallRead:         (*VirtualMachine).syscallRead,
+	SyscallWrite:        (*VirtualMachine).syscallWrite,
+	SyscallBrk:          (*VirtualMachine).syscallBrk,
+	SyscallSbrk:         (*VirtualMachine).syscallSbrk,
+	SyscallMmap:         (*VirtualMachine).syscallMmap,
+	SyscallMunmap:       (*VirtualMachine).syscallMunmap,
+	SyscallExit:         (*VirtualMachine).syscallExit,
+	SyscallOpen:         (*VirtualMachine).syscallOpen,
+	SyscallClose:        (*VirtualMachine).syscallClose,
+	SyscallLseek:        (*VirtualMachine).syscallLseek,
+	SyscallFstat:        (*VirtualMachine).syscallFstat,
+	SyscallUnlink:       (*VirtualMachine).syscallUnlink,
+	SyscallClockGettime: (*VirtualMachine).syscallClockGettime,
+	SyscallNanosleep:    (*VirtualMachine).syscallNanosleep,
+	SyscallGetrandom:    (*VirtualMachine).syscallGetrandom,
+}
+
+func defaultSyscalls() map[uint64]SyscallHandler {
+	syscalls := make(map[uint64]SyscallHandler, len(builtinSyscalls))
+	for number, handler := range builtinSyscalls {
+		syscalls[number] = handler
+	}
+	return syscalls
+}
+
+// RegisterSyscall makes handler responsible for the syscall with the given
+// number, replacing any existing handler including the built-in ones. A nil
+// handler removes the syscall.
+func (v *VirtualMachine) RegisterSyscall(number uint64, handler SyscallHandler) {
+	if handler == nil {
+		delete(v.syscalls, number)
+		return
+	}
+	v.syscalls[number] = handler
+}
+
+func (v *VirtualMachine) executeSyscall() error {
+	syscallNumber := v.registers.Get(arch.SyscallRegister)
+	if !v.quiet {
+		log.Printf("Executing syscall number %d\n", syscallNumber)
+	}
+
+	v.saveHeap()
+	if v.replaying != nil && !reexecutedSyscalls[syscallNumber] {
+		return v.replaySyscall(syscallNumber)
+	}
+
+	var writes []memoryWrite
+	if v.recording != nil {
+		v.memory.capture = &writes
+		defer func() {
+			v.memory.capture = nil
+		}()
+	}
+
+	v.setSyscallError(0)
+
+	handler, ok := v.syscalls[syscallNumber]
+	if !ok {
+		v.failSyscall(fmt.Errorf("syscall %d: %w", syscallNumber, ErrNoSyscall))
+	} else if err := handler.HandleSyscall(v); err != nil {
+		v.failSyscall(err)
+	}
+
+	if v.replaying != nil {
+		return v.checkReexecutedSyscall(syscallNumber)
+	}
+	if v.recording != nil {
+		v.recordSyscall(syscallNumber, writes)
+	}
+	return nil
+}
+
+func (v *VirtualMachine) setSyscallError(eno uint64) {
+	v.registers.Set(arch.SyscallErrorRegister, eno)
+}
+
+func (v *VirtualMachine) setSyscallResult(val uint64) {
+	v.registers.Set(arch.SyscallResultRegister, val)
+}
+
+// failSyscall reports the error of a syscall to the program
+func (v *VirtualMachine) failSyscall(err error) {
+	v.setSyscallError(errnoFor(err))
+	if !v.quiet {
+		log.Printf("error: %v\n", err)
+	}
+}
+
+// errnoFor returns the error number reported to programs for err
+func errnoFor(err error) uint64 {
+	var errno Errno
+	var accessErr *memory.AccessError
+	switch {
+	case errors.As(err, &errno):
+		return uint64(errno)
+	case errors.Is(err, ErrNoSyscall):
+		return arch.ENO_NoSyscall
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return arch.ENO_Interrupted
+	case errors.Is(err, ErrInvalidFileDescriptor):
+		return arch.ENO_BadFileDescriptor
+	case errors.Is(err, ErrOutOfMemory):
+		return arch.ENO_OutOfMemory
+	case errors.Is(err, ErrInvalidArgument):
+		return arch.ENO_InvalidArgument
+	case errors.As(err, &accessErr):
+		return arch.ENO_BadAddress
+	case errors.Is(err, fs.ErrNotExist):
+		return arch.ENO_NotFound
+	case errors.Is(err, fs.ErrExist):
+		return arch.ENO_Exists
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, ErrNoFileSystem), errors.Is(err, ErrPathEscape):
+		return arch.ENO_PermissionDenied
+	case errors.Is(err, syscall.EISDIR):
+		return arch.ENO_IsDirectory
+	case errors.Is(err, fs.ErrInvalid):
+		return arch.ENO_InvalidArgument
+	default:
+		return arch.ENO_IO
+	}
+}
@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dnsge/orange/arch"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSyscall_NoSyscall(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	v := newTestVM(t, "\tHALT\n")
+	_, errno := callSyscall(t, v, 1000)
+	assert.Equal(t, uint64(arch.ENO_NoSyscall), errno)
+	assert.Empty(t, logged.String(), "quiet VMs do not log failed syscalls")
+
+	// removing a built-in syscall
+	v.RegisterSyscall(SyscallBrk, nil)
+	_, errno = callSyscall(t, v, SyscallBrk, 0)
+	assert.Equal(t, uint64(arch.ENO_NoSyscall), errno)
+}
+
+func TestSyscall_RegisterSyscall(t *testing.T) {
+	v := newTestVM(t, "\tHALT\n")
+
+	v.RegisterSyscall(100, SyscallHandlerFunc(func(v *VirtualMachine) error {
+		v.setSyscallResult(v.Register(1) + v.Register(2))
+		return nil
+	}))
+	result, errno := callSyscall(t, v, 100, 3, 4)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(7), result)
+
+	// handlers replace built-in syscalls and report errors through Errno
+	v.RegisterSyscall(SyscallBrk, SyscallHandlerFunc(func(v *VirtualMachine) error {
+		return fmt.Errorf("custom brk: %w", Errno(99))
+	}))
+	_, errno = callSyscall(t, v, SyscallBrk, 0)
+	assert.Equal(t, uint64(99), errno)
+
+	// the error register is cleared by the next syscall
+	_, errno = callSyscall(t, v, 100, 1, 1)
+	assert.Equal(t, uint64(0), errno)
+
+	// other VMs keep the built-in handlers
+	brk, errno := callSyscall(t, newTestVM(t, "\tHALT\n"), SyscallBrk, 0)
+	assert.Equal(t, uint64(0), errno)
+	assert.Equal(t, uint64(heapStart), brk)
+}
+
+func TestErrnoFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want uint64
+	}{
+		{Errno(42), 42},
+		{fmt.Errorf("wrapped: %w", ErrNoSyscall), arch.ENO_NoSyscall},
+		{ErrInvalidFileDescriptor, arch.ENO_BadFileDescriptor},
+		{ErrOutOfMemory, arch.ENO_OutOfMemory},
+		{ErrInvalidArgument, arch.ENO_InvalidArgument},
+		{fs.ErrNotExist, arch.ENO_NotFound},
+		{ErrPathEscape, arch.ENO_PermissionDenied},
+		{fmt.Errorf("something else"), arch.ENO_IO},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, errnoFor(tt.err), tt.err.Error())
+	}
+}
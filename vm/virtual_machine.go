@@ -1,11 +1,14 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/debuginfo"
 	"github.com/dnsge/orange/memory"
 	"io"
 	"os"
+	"time"
 )
 
 type VirtualMachine struct {
@@ -14,16 +17,59 @@ type VirtualMachine struct {
 	programCounter uint32
 	registers      registerFile
 	alu            *ALU
-	memory         memory.Addressable
+	memory         *observedMemory
+	stack          Stack
+	heap           heap
 	halted         bool
+	exitCode       int
+	steps          uint64
+	runContext     context.Context
+	interrupted    bool
 
-	fds map[int]io.ReadWriter
+	stdio      [3]io.ReadWriter
+	fds        map[int]*fileDescriptor
+	fileSystem FileSystem
+	syscalls   map[uint64]SyscallHandler
+	clock      Clock
+	clockStart time.Time
+	random     io.Reader
+
+	debugInfo *debuginfo.Info
+	history   *history
+	recording *SyscallLog
+	replaying *SyscallLog
+	hooks     []Hook
 }
 
 func (v *VirtualMachine) Memory() memory.Addressable {
 	return v.memory
 }
 
+// PC returns the address of the next instruction to execute
+func (v *VirtualMachine) PC() uint32 {
+	return v.programCounter
+}
+
+// SetPC sets the address of the next instruction to execute
+func (v *VirtualMachine) SetPC(pc uint32) {
+	v.programCounter = pc
+}
+
+// Register returns the value of register regNum
+func (v *VirtualMachine) Register(regNum uint8) uint64 {
+	return v.registers.Get(regNum)
+}
+
+// SetRegister sets the value of register regNum. Writes to r0 are ignored.
+func (v *VirtualMachine) SetRegister(regNum uint8, val uint64) {
+	v.registers.Set(regNum, val)
+}
+
+// Flags returns the condition flags set by the last arithmetic instruction
+func (v *VirtualMachine) Flags() (negative bool, zero bool, carry bool) {
+	return v.alu.flags.Negative, v.alu.flags.Zero, v.alu.flags.Carry
+}
+
 func (v *VirtualMachine) Halt() {
 	v.halted = true
 }
@@ -32,37 +78,89 @@ func (v *VirtualMachine) Halted() bool {
 	return v.halted
 }
 
+// ExitCode returns the status passed to the exit syscall, or zero if the
+// program did not call exit.
+func (v *VirtualMachine) ExitCode() int {
+	return v.exitCode
+}
+
 func NewVirtualMachine(mem memory.Addressable, quiet bool) *VirtualMachine {
-	return &VirtualMachine{
+	v := &VirtualMachine{
 		quiet: quiet,
 
 		programCounter: 0,
 		registers:      initRegisterFile(),
 		alu:            newALU(),
-		memory:         mem,
+		memory:         newObservedMemory(mem),
 		halted:         false,
-		fds: map[int]io.ReadWriter{
-			0: os.Stdin,
-			1: os.Stdout,
-			2: os.Stderr,
-		},
+		stdio:          [3]io.ReadWriter{os.Stdin, os.Stdout, os.Stderr},
+		syscalls:       defaultSyscalls(),
 	}
+	v.fds = v.standardFileDescriptors()
+	v.initHostServices()
+	return v
+}
+
+// InitStack points the stack pointer at the top of the given stack. Faults
+// within the stack's guard regions are reported as stack overflows and
+// underflows.
+func (v *VirtualMachine) InitStack(stack Stack) {
+	v.stack = stack
+	v.registers.Set(arch.StackRegister, uint64(stack.Top))
 }
 
-func (v *VirtualMachine) InitStack(stackStartAddress uint64) {
-	v.registers.Set(arch.StackRegister, stackStartAddress)
+// Stack returns the stack set by InitStack
+func (v *VirtualMachine) Stack() Stack {
+	return v.stack
 }
 
-func (v *VirtualMachine) ExecuteInstruction() {
+// ExecuteInstruction fetches and executes the instruction at the program
+// counter. If the instruction cannot be completed, the VM is halted and a
+// *Fault describing the cause is returned.
+func (v *VirtualMachine) ExecuteInstruction() error {
 	if v.halted {
-		return
+		return nil
+	}
+
+	pc := v.programCounter
+	entry := v.beginUndoEntry()
+	v.steps++
+	i, err := v.fetchNextInstruction()
+	if err == nil {
+		err = v.executeInstruction(i)
+	}
+	if err == nil && len(v.hooks) > 0 {
+		v.runHooks(pc, i)
 	}
 
-	i := v.fetchNextInstruction()
-	v.executeInstruction(i)
+	if err != nil {
+		v.Halt()
+		fault := faultFor(pc, err)
+		v.classifyStackFault(fault)
+		err = fault
+	}
+	v.endUndoEntry(entry)
+	return err
+}
+
+// SetDebugInfo sets the debug info of the program, which is used to show
+// source locations in traces
+func (v *VirtualMachine) SetDebugInfo(info *debuginfo.Info) {
+	v.debugInfo = info
+}
+
+// DebugInfo returns the debug info set by SetDebugInfo, if any
+func (v *VirtualMachine) DebugInfo() *debuginfo.Info {
+	return v.debugInfo
 }
 
 func (v *VirtualMachine) PrintState() {
 	fmt.Printf("Registers: %v\n", v.registers)
+	if v.debugInfo != nil {
+		if line, ok := v.debugInfo.LineFor(v.programCounter); ok {
+			fmt.Printf("PC: 0x%08x (%s)\n\n", v.programCounter, line)
+			return
+		}
+	}
 	fmt.Printf("PC: 0x%08x (line %d)\n\n", v.programCounter, v.programCounter/4+1)
 }
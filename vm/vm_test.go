@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"bytes"
+	"github.com/dnsge/orange/arch"
+	"github.com/dnsge/orange/asm"
+	"github.com/dnsge/orange/executable"
+	"github.com/dnsge/orange/memory"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	testStackTop  = 0x80000000
+	testStackSize = 0x1000
+)
+
+// assemble assembles source into an executable with debug info
+func assemble(t *testing.T, source string) *executable.File {
+	t.Helper()
+
+	var out bytes.Buffer
+	require.NoError(t, asm.Assemble(strings.NewReader(source), &out, &asm.Options{
+		Target:   asm.TargetExecutable,
+		Debug:    true,
+		FileName: "test.orange",
+	}))
+	exe, err := executable.Read(&out)
+	require.NoError(t, err)
+	return exe
+}
+
+// newTestVM loads the program assembled from source with a heap and a stack
+// like orangevm would. Its output is discarded.
+func newTestVM(t *testing.T, source string) *VirtualMachine {
+	t.Helper()
+
+	exe := assemble(t, source)
+	mem := memory.New()
+	require.NoError(t, exe.Load(mem))
+
+	v := NewVirtualMachine(mem, true)
+	v.SetDebugInfo(exe.Debug)
+	v.SetStandardStreams(bytes.NewReader(nil), io.Discard, io.Discard)
+
+	heapStart := (exe.End()/memory.PageSize + 1) * memory.PageSize
+	require.NoError(t, v.InitHeap(heapStart))
+	stack, err := MapStack(mem, testStackTop, testStackSize)
+	require.NoError(t, err)
+	v.InitStack(stack)
+	return v
+}
+
+// callSyscall makes the syscall with the arguments in r1-r6 and returns its
+// result and error number
+func callSyscall(t *testing.T, v *VirtualMachine, number uint64, args ...uint64) (uint64, uint64) {
+	t.Helper()
+
+	for i, arg := range args {
+		v.SetRegister(uint8(i+1), arg)
+	}
+	v.SetRegister(arch.SyscallRegister, number)
+	require.NoError(t, v.executeSyscall())
+	return v.Register(arch.SyscallResultRegister), v.Register(arch.SyscallErrorRegister)
+}